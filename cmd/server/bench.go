@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"tinker/pkg/bench"
+)
+
+var (
+	benchCfg         bench.Config
+	benchPayloadFile string
+	benchPayloadSize int
+	benchFormat      string
+	benchAudioFile   string
+	benchPCM         bench.Audio
+	benchMix         string
+
+	benchCmd = &cobra.Command{
+		Use:   "bench",
+		Short: "load test a tinker http or websocket endpoint",
+		Args:  cobra.NoArgs,
+		RunE:  executeBench,
+
+		SilenceUsage: true,
+	}
+)
+
+func init() {
+	flags := benchCmd.Flags()
+	flags.StringVar(&benchCfg.Target, "target", "http://localhost:8585/httpcase", "http(s):// or ws(s):// url to load")
+	flags.IntVarP(&benchCfg.Concurrency, "concurrency", "c", 1, "number of concurrent workers, the maximum requests in flight with --rate")
+	flags.DurationVarP(&benchCfg.Duration, "duration", "d", 10*time.Second, "run duration, 0 to only bound by --requests")
+	flags.Int64VarP(&benchCfg.Requests, "requests", "n", 0, "total requests, 0 to only bound by --duration")
+	flags.DurationVar(&benchCfg.RampUp, "ramp-up", 0, "spread the start of the workers over this period")
+	flags.Float64SliceVar(&benchCfg.Rates, "rate", nil, "open-loop arrival rates in req/s, one step per rate, e.g. 50,100,200")
+	flags.DurationVar(&benchCfg.StepDuration, "step-duration", 0, "duration of each --rate step, defaults to --duration split between the steps")
+	flags.DurationVar(&benchCfg.Timeout, "timeout", 30*time.Second, "timeout of one request")
+	flags.StringVarP(&benchCfg.Method, "method", "X", "GET", "http method, --payload needs one with a body, e.g. POST")
+	flags.StringVar(&benchPayloadFile, "payload", "", "file sent as http body or websocket stream")
+	flags.IntVar(&benchPayloadSize, "payload-size", 0, "size of a random payload, used when --payload is not set")
+	flags.IntVar(&benchCfg.ChunkSize, "chunk-size", 4*1024, "websocket frame size")
+	flags.DurationVar(&benchCfg.Pacing, "pacing", 0, "delay between websocket frames")
+	flags.StringVar(&benchFormat, "format", "text", "report format: text or json")
+	flags.StringVar(&benchAudioFile, "audio", "", "WAV or raw PCM file streamed at its real bitrate by websocket sessions")
+	flags.IntVar(&benchPCM.SampleRate, "sample-rate", 16000, "sample rate of a raw PCM --audio file")
+	flags.IntVar(&benchPCM.Channels, "channels", 1, "channels of a raw PCM --audio file")
+	flags.IntVar(&benchPCM.BitsPerSample, "bits", 16, "bits per sample of a raw PCM --audio file")
+	flags.StringVar(&benchMix, "mix", "", "weighted mix of audio session lengths, e.g. 5s:50,30s:30,2m:20")
+
+	appCmd.AddCommand(benchCmd)
+}
+
+func executeBench(cmd *cobra.Command, args []string) (err error) {
+	if benchFormat != "text" && benchFormat != "json" {
+		return fmt.Errorf("unsupported format '%s'", benchFormat)
+	}
+
+	if len(benchCfg.Rates) > 0 && !cmd.Flags().Changed("concurrency") {
+		// the maximum number of requests in flight of an open-loop run
+		benchCfg.Concurrency = 256
+	}
+
+	if benchPayloadFile != "" {
+		benchCfg.Payload, err = ioutil.ReadFile(benchPayloadFile)
+		if err != nil {
+			return err
+		}
+	} else if benchPayloadSize > 0 {
+		benchCfg.Payload = make([]byte, benchPayloadSize)
+		rand.Read(benchCfg.Payload)
+	}
+
+	if benchAudioFile != "" {
+		benchCfg.Audio, err = bench.LoadAudio(benchAudioFile, benchPCM)
+		if err != nil {
+			return err
+		}
+		if !cmd.Flags().Changed("chunk-size") {
+			// 100ms chunks
+			benchCfg.ChunkSize = 0
+		}
+	}
+	if benchMix != "" {
+		benchCfg.Mix, err = bench.ParseMix(benchMix)
+		if err != nil {
+			return err
+		}
+	}
+
+	report, err := bench.Run(benchCfg)
+	if err != nil {
+		return err
+	}
+
+	if benchFormat == "json" {
+		return report.WriteJSON(os.Stdout)
+	}
+
+	report.WriteText(os.Stdout)
+	return nil
+}
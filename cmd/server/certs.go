@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"tinker/pkg/certgen"
+)
+
+var (
+	certsOpts certgen.Options
+
+	certsCmd = &cobra.Command{
+		Use:   "certs",
+		Short: "generate a test CA with server and client certificates for TLS and mTLS",
+		Args:  cobra.NoArgs,
+		RunE:  executeCerts,
+
+		SilenceUsage: true,
+	}
+)
+
+func init() {
+	flags := certsCmd.Flags()
+	flags.StringVar(&certsOpts.Dir, "dir", "certs", "directory of the PEM files")
+	flags.StringSliceVar(&certsOpts.Hosts, "hosts", []string{"localhost", "127.0.0.1"}, "DNS names and IPs of the server certificate")
+	flags.StringVar(&certsOpts.ClientName, "client-name", "tinker-client", "common name of the client certificate")
+	flags.DurationVar(&certsOpts.Validity, "validity", 365*24*time.Hour, "validity of the certificates")
+
+	appCmd.AddCommand(certsCmd)
+}
+
+func executeCerts(cmd *cobra.Command, args []string) error {
+	err := certgen.Generate(certsOpts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("certificates written to %s\n", certsOpts.Dir)
+	return nil
+}
@@ -7,15 +7,48 @@ import (
 	"github.com/spf13/cobra"
 
 	"tinker/pkg/api"
+	"tinker/pkg/config"
+	"tinker/pkg/framework"
 )
 
 var (
+	serveOpts  api.Options
+	configFile string
+
 	appCmd = &cobra.Command{
+		Use:   "tinker",
 		Short: "start tinker server",
 		RunE:  execute,
 	}
 )
 
+func init() {
+	flags := appCmd.Flags()
+	flags.StringVar(&serveOpts.RecordDir, "record-dir", "", "record every session to this directory, see 'tinker replay'")
+	flags.StringSliceVar(&serveOpts.RecordHeaders, "record-headers", nil, "sensitive headers recorded in clear, e.g. Authorization. They are redacted by default")
+	flags.StringSliceVar(&serveOpts.RecordRedactQuery, "record-redact-query", nil, "query parameters redacted from the recordings, e.g. sig, on top of those like token or access_token")
+	flags.StringVar(&serveOpts.GrpcAddr, "grpc-addr", "", "also listen as a grpc server forwarding any method to the backends, e.g. :8587")
+	flags.StringVar(&serveOpts.AdminAddr, "admin-addr", "", "serve /debug/vars and /debug/pipelines on this address, e.g. localhost:8586")
+	flags.StringVar(&configFile, "config", "", "JSON config file, see pkg/config, reloaded when it changes or on SIGHUP. Flags take precedence")
+
+	// TLS of the listeners, see 'tinker certs' for test certificates
+	flags.StringVar(&serveOpts.TLS.CertFile, "tls-cert", "", "certificate of the listeners, reloaded when the file changes")
+	flags.StringVar(&serveOpts.TLS.KeyFile, "tls-key", "", "key of the listeners")
+	flags.StringVar(&serveOpts.TLS.CAFile, "tls-client-ca", "", "CA verifying the client certificates (mTLS)")
+	flags.BoolVar(&serveOpts.TLS.ClientAuth, "tls-client-auth", false, "require client certificates verified by --tls-client-ca")
+	flags.BoolVar(&serveOpts.H2C, "h2c", false, "serve HTTP/2 without TLS")
+
+	// fault injection, for chaos testing only
+	faults := &serveOpts.Faults
+	flags.BoolVar(&faults.Headers, "fault-headers", false, "let clients inject faults with X-Tinker-Fault-* headers, never in production")
+	flags.DurationVar(&faults.Latency, "fault-latency", 0, "delay every session")
+	flags.Float64Var(&faults.AbortRate, "fault-abort-rate", 0, "rate of sessions aborted, 0 to 1")
+	flags.IntVar(&faults.AbortHttpCode, "fault-abort-http-code", framework.DefaultFaultConfig.AbortHttpCode, "http status of aborted http sessions")
+	flags.IntVar(&faults.AbortWsCode, "fault-abort-ws-code", framework.DefaultFaultConfig.AbortWsCode, "error code of aborted websocket sessions")
+	flags.Float64Var(&faults.DropRate, "fault-drop-rate", 0, "rate of websocket frames dropped, 0 to 1")
+	flags.IntVar(&faults.CloseAfter, "fault-close-after", 0, "close websocket connections after that many data frames, http connections without response")
+}
+
 func main() {
 	if err := appCmd.Execute(); err != nil {
 		glog.Error("exit with:", err.Error())
@@ -24,5 +57,19 @@ func main() {
 }
 
 func execute(cmd *cobra.Command, args []string) (err error) {
-	return api.Serve()
+	if configFile != "" {
+		cfg, err := config.Load(configFile)
+		if err != nil {
+			return err
+		}
+
+		if serveOpts.TLS == (framework.TLSConfig{}) {
+			serveOpts.TLS = cfg.TLS
+		}
+		serveOpts.H2C = serveOpts.H2C || cfg.H2C
+		serveOpts.Config = cfg
+		serveOpts.ConfigFile = configFile
+	}
+
+	return api.Serve(serveOpts)
 }
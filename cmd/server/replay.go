@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"tinker/pkg/replay"
+)
+
+var (
+	replayOpts replay.Options
+
+	replayCmd = &cobra.Command{
+		Use:   "replay <recording>...",
+		Short: "replay recorded sessions against a tinker server and diff the responses",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  executeReplay,
+
+		SilenceUsage: true,
+	}
+)
+
+func init() {
+	flags := replayCmd.Flags()
+	flags.StringVar(&replayOpts.Target, "target", "http://localhost:8585", "base url of the tinker server")
+	flags.Float64Var(&replayOpts.Speed, "speed", 1, "replay speed, 2 is twice as fast, 0 sends without delay")
+	flags.DurationVar(&replayOpts.Timeout, "timeout", 30*time.Second, "max wait for responses after the last inbound event")
+
+	appCmd.AddCommand(replayCmd)
+}
+
+func executeReplay(cmd *cobra.Command, args []string) error {
+	failed := 0
+	for _, path := range args {
+		result, err := replay.ReplayFile(path, replayOpts)
+		if err != nil {
+			fmt.Printf("ERROR %s: %v\n", path, err)
+			failed++
+			continue
+		}
+
+		if result.Match() {
+			fmt.Printf("PASS  %s: %d responses\n", path, len(result.Actual))
+			continue
+		}
+
+		failed++
+		fmt.Printf("FAIL  %s: %d responses recorded, %d replayed\n", path, len(result.Expected), len(result.Actual))
+		for _, diff := range result.Diffs {
+			fmt.Println(diff)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d recordings failed", failed, len(args))
+	}
+
+	return nil
+}
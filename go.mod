@@ -5,6 +5,7 @@ go 1.15
 require (
 	git.llsapp.com/algapi/connector v0.3.7 // indirect
 	git.llsapp.com/common/protos v0.1.2141 // indirect
+	github.com/HdrHistogram/hdrhistogram-go v1.1.2
 	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b
 	github.com/golang/protobuf v1.5.2
 	github.com/gorilla/websocket v1.4.2
@@ -12,6 +13,8 @@ require (
 	github.com/prometheus/client_golang v1.11.0 // indirect
 	github.com/rs/xid v1.3.0
 	github.com/spf13/cobra v1.2.1
+	github.com/vmihailenco/msgpack/v5 v5.3.5
+	golang.org/x/net v0.0.0-20210405180319-a5a99cb37ef4
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
 	google.golang.org/grpc v1.41.0
 	google.golang.org/protobuf v1.27.1
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"crypto/rand"
+	"flag"
 	"io"
 	"log"
 	"net"
@@ -42,6 +43,7 @@ func (g *Server) Greet(ctx context.Context, request *hello.GreetRequest) (*hello
 	default:
 		resp.Acking = "Hi " + hello.Name_name[int32(request.GetPerson())]
 	}
+	resp.Acking = callFrom(ctx).acking(resp.Acking)
 
 	resp.Name = hello.Name_Robot
 	resp.Time = timestamppb.Now()
@@ -137,9 +139,28 @@ func (g *Server) Greet(ctx context.Context, request *hello.GreetRequest) (*hello
 	return resp, nil
 }
 
+// defaults when the scenario doesn't set them
+const (
+	defaultPayloadSize = 1024 * 1024 * 6 // 6m
+	defaultMessages    = 7
+)
+
 func main() {
-	// 监听8888端口
-	listen, err := net.Listen("tcp", ":8686")
+	addr := flag.String("addr", ":8686", "listen address")
+	scenarioFile := flag.String("scenario", "", "scenario file, see Scenario")
+	flag.Parse()
+
+	var scenario *Scenario
+	if *scenarioFile != "" {
+		var err error
+		scenario, err = LoadScenario(*scenarioFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Scenario %s loaded", *scenarioFile)
+	}
+
+	listen, err := net.Listen("tcp", *addr)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -148,22 +169,34 @@ func main() {
 	s := grpc.NewServer(
 		grpc.MaxRecvMsgSize(1024*1024*8), // server max recv msg , 默认 4 m
 		grpc.MaxSendMsgSize(1024*1024*8), // server max send msg , 默认 不限制
+		grpc.UnaryInterceptor(scenario.UnaryInterceptor),
+		grpc.StreamInterceptor(scenario.StreamInterceptor),
 	)
 
 	// 注册Love服务
 	hello.RegisterGreetingServer(s, new(Server))
 	hello.RegisterStreamServiceServer(s, new(Server))
+	err = scenario.checkMethods(s.GetServiceInfo())
+	if err != nil {
+		log.Fatalf("invalid scenario %s: %v", *scenarioFile, err)
+	}
 
-	log.Println("Listen on 127.0.0.1:8686...")
+	log.Printf("Listen on %s...", *addr)
 	s.Serve(listen)
 }
 
 func (s *Server) List(r *hello.StreamRequest, stream hello.StreamService_ListServer) error {
-	for n := int32(0); n <= 6; n++ {
-		err := stream.Send(&hello.StreamResponse{
+	call := callFrom(stream.Context())
+	for n := 0; n < call.messages(defaultMessages); n++ {
+		err := call.send(stream.Context())
+		if err != nil {
+			return err
+		}
+
+		err = stream.Send(&hello.StreamResponse{
 			Pt: &hello.StreamPoint{
-				Name:  r.Pt.Name,
-				Value: getBytesN(1024 * 1014 * 6), // 6m
+				Name:  call.name(r.Pt.Name),
+				Value: getBytesN(call.payloadSize(defaultPayloadSize)),
 			},
 		})
 		if err != nil {
@@ -180,7 +213,7 @@ func (s *Server) Record(stream hello.StreamService_RecordServer) error {
 		if err == io.EOF {
 			return stream.SendAndClose(&hello.StreamResponse{
 				Pt: &hello.StreamPoint{
-					Name: "gRPC Stream Server: Record",
+					Name: callFrom(stream.Context()).name("gRPC Stream Server: Record"),
 				},
 			})
 		}
@@ -194,11 +227,17 @@ func (s *Server) Record(stream hello.StreamService_RecordServer) error {
 
 func (s *Server) Route(stream hello.StreamService_RouteServer) error {
 	n := 0
+	call := callFrom(stream.Context())
 	for {
-		err := stream.Send(&hello.StreamResponse{
+		err := call.send(stream.Context())
+		if err != nil {
+			return err
+		}
+
+		err = stream.Send(&hello.StreamResponse{
 			Pt: &hello.StreamPoint{
-				Name:  "gPRC Stream Client: Route",
-				Value: getBytesN(1024 * 1024 * 6), // 6m
+				Name:  call.name("gPRC Stream Client: Route"),
+				Value: getBytesN(call.payloadSize(defaultPayloadSize)),
 			},
 		})
 		if err != nil {
@@ -222,6 +261,7 @@ func (s *Server) Route(stream hello.StreamService_RouteServer) error {
 func (s *Server) Route2(stream hello.StreamService_Route2Server) error {
 	ret := make(chan error)
 	recv := make(chan int)
+	call := callFrom(stream.Context())
 
 	go func() {
 		for {
@@ -230,8 +270,8 @@ func (s *Server) Route2(stream hello.StreamService_Route2Server) error {
 			for n := 0; n <= 6; n++ {
 				err := stream.Send(&hello.StreamResponse{ // send 只能发送一次，阻塞直到 recv，才能第二次 send.
 					Pt: &hello.StreamPoint{
-						Name:  "gPRC Stream Client: Route2",
-						Value: getBytesN(1024 * 1024 * 6), // 6m
+						Name:  call.name("gPRC Stream Client: Route2"),
+						Value: getBytesN(call.payloadSize(defaultPayloadSize)),
 					},
 				})
 				if err != nil {
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Scenario describes how the mock server answers each method.
+//
+// Methods are keyed by full name ("/hello.Greeting/Greet"), by short name
+// ("Greet") or by "*" for the methods not listed. The server refuses to start
+// with keys of unknown methods. E.g.
+//
+//	{
+//	  "methods": {
+//	    "Greet": {
+//	      "latency": {"distribution": "normal", "mean": "50ms", "stddev": "10ms"},
+//	      "errors": [{"code": "Unavailable", "rate": 0.05}],
+//	      "script": [{"acking": "first"}, {"code": "DeadlineExceeded"}]
+//	    },
+//	    "List": {"messages": 3, "payload_size": 1024, "message_latency": {"value": "100ms"}}
+//	  }
+//	}
+type Scenario struct {
+	Methods map[string]*MethodScenario `json:"methods"`
+}
+
+// MethodScenario is the behavior of one method
+type MethodScenario struct {
+	// Latency is waited before handling a call
+	Latency *Latency `json:"latency,omitempty"`
+	// Errors are injected at their rate, before Latency is waited
+	Errors []ErrorRule `json:"errors,omitempty"`
+	// PayloadSize is the size of the random StreamPoint values sent
+	PayloadSize *int `json:"payload_size,omitempty"`
+	// Messages is the number of messages sent by List
+	Messages *int `json:"messages,omitempty"`
+	// MessageLatency is waited before each message sent on a stream
+	MessageLatency *Latency `json:"message_latency,omitempty"`
+	// Script gives the responses of successive calls, cycling when exhausted
+	Script []ScriptEntry `json:"script,omitempty"`
+
+	mu    sync.Mutex
+	calls int
+}
+
+// ErrorRule fails Rate of the calls, 0 to 1, with Code
+type ErrorRule struct {
+	Code    Code    `json:"code"`
+	Rate    float64 `json:"rate"`
+	Message string  `json:"message,omitempty"`
+}
+
+// ScriptEntry is the response of one call, an error when Code is set.
+// Empty fields keep the default response
+type ScriptEntry struct {
+	Code    Code      `json:"code,omitempty"`
+	Message string    `json:"message,omitempty"`
+	Latency *Duration `json:"latency,omitempty"`
+
+	// Acking of a Greet response
+	Acking string `json:"acking,omitempty"`
+	// Name of the StreamPoints sent
+	Name string `json:"name,omitempty"`
+}
+
+// Latency is a random delay following Distribution:
+//   - "fixed" (default): Value
+//   - "uniform": between Min and Max
+//   - "normal": Mean and Stddev, never negative
+//   - "exponential": Mean
+type Latency struct {
+	Distribution string   `json:"distribution,omitempty"`
+	Value        Duration `json:"value,omitempty"`
+	Min          Duration `json:"min,omitempty"`
+	Max          Duration `json:"max,omitempty"`
+	Mean         Duration `json:"mean,omitempty"`
+	Stddev       Duration `json:"stddev,omitempty"`
+}
+
+// Duration is a time.Duration written like "150ms" in JSON
+type Duration time.Duration
+
+func (p *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*p = Duration(d)
+
+	return nil
+}
+
+// Code is a grpc status code written by name in JSON, e.g. "Unavailable"
+type Code codes.Code
+
+func (p *Code) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	// accept both "DeadlineExceeded" and "DEADLINE_EXCEEDED"
+	name := strings.ReplaceAll(s, "_", "")
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		if strings.EqualFold(name, c.String()) {
+			*p = Code(c)
+			return nil
+		}
+	}
+
+	var c codes.Code
+	err = c.UnmarshalJSON(data)
+	if err != nil {
+		return fmt.Errorf("invalid grpc code '%s'", s)
+	}
+	*p = Code(c)
+
+	return nil
+}
+
+func (p *Latency) sample() time.Duration {
+	if p == nil {
+		return 0
+	}
+
+	var ret time.Duration
+	switch p.Distribution {
+	case "", "fixed":
+		ret = time.Duration(p.Value)
+	case "uniform":
+		ret = time.Duration(p.Min)
+		if p.Max > p.Min {
+			ret += time.Duration(rand.Int63n(int64(p.Max - p.Min)))
+		}
+	case "normal":
+		ret = time.Duration(p.Mean) + time.Duration(rand.NormFloat64()*float64(p.Stddev))
+	case "exponential":
+		ret = time.Duration(rand.ExpFloat64() * float64(p.Mean))
+	}
+	if ret < 0 {
+		ret = 0
+	}
+
+	return ret
+}
+
+func (p *Latency) check() error {
+	if p == nil {
+		return nil
+	}
+
+	switch p.Distribution {
+	case "", "fixed", "uniform", "normal", "exponential":
+		return nil
+	default:
+		return fmt.Errorf("unknown latency distribution '%s'", p.Distribution)
+	}
+}
+
+// LoadScenario reads a scenario file
+func LoadScenario(file string) (*Scenario, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := new(Scenario)
+	err = json.Unmarshal(data, ret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scenario %s: %v", file, err)
+	}
+
+	for name, method := range ret.Methods {
+		total := 0.0
+		for _, rule := range method.Errors {
+			if rule.Code == Code(codes.OK) {
+				return nil, fmt.Errorf("invalid scenario %s: error rule of %s without error code", file, name)
+			}
+			total += rule.Rate
+		}
+		if total > 1 {
+			return nil, fmt.Errorf("invalid scenario %s: error rates of %s add up to more than 1", file, name)
+		}
+		for _, latency := range []*Latency{method.Latency, method.MessageLatency} {
+			err = latency.check()
+			if err != nil {
+				return nil, fmt.Errorf("invalid scenario %s: %s: %v", file, name, err)
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+// checkMethods checks that the methods of the scenario are methods of services,
+// the services registered on the server
+func (p *Scenario) checkMethods(services map[string]grpc.ServiceInfo) error {
+	if p == nil {
+		return nil
+	}
+
+	known := map[string]bool{"*": true}
+	for service, info := range services {
+		for _, method := range info.Methods {
+			known["/"+service+"/"+method.Name] = true
+			known[method.Name] = true
+		}
+	}
+	for name := range p.Methods {
+		if !known[name] {
+			return fmt.Errorf("unknown method '%s'", name)
+		}
+	}
+
+	return nil
+}
+
+// method returns the scenario of fullMethod, nil if there is none
+func (p *Scenario) method(fullMethod string) *MethodScenario {
+	if p == nil {
+		return nil
+	}
+	if ret, ok := p.Methods[fullMethod]; ok {
+		return ret
+	}
+	if ret, ok := p.Methods[path.Base(fullMethod)]; ok {
+		return ret
+	}
+
+	return p.Methods["*"]
+}
+
+// next returns the script entry of the next call, nil without script
+func (p *MethodScenario) next() *ScriptEntry {
+	if len(p.Script) == 0 {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ret := &p.Script[p.calls%len(p.Script)]
+	p.calls++
+
+	return ret
+}
+
+// inject waits the latency of a call and returns the injected or scripted
+// error, if any
+func (p *MethodScenario) inject(ctx context.Context, entry *ScriptEntry) error {
+	n := rand.Float64()
+	for _, rule := range p.Errors {
+		if n < rule.Rate {
+			return status.Error(codes.Code(rule.Code), rule.message())
+		}
+		n -= rule.Rate
+	}
+
+	delay := p.Latency.sample()
+	if entry != nil && entry.Latency != nil {
+		delay = time.Duration(*entry.Latency)
+	}
+	err := sleep(ctx, delay)
+	if err != nil {
+		return err
+	}
+
+	if entry != nil && entry.Code != Code(codes.OK) {
+		return status.Error(codes.Code(entry.Code), entry.Message)
+	}
+
+	return nil
+}
+
+func (p *ErrorRule) message() string {
+	if p.Message != "" {
+		return p.Message
+	}
+
+	return "injected " + codes.Code(p.Code).String()
+}
+
+// sleep waits d unless ctx is done first
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+type callKey struct{}
+
+// call is the scenario of one call, read by the handlers for the response content
+type call struct {
+	method *MethodScenario
+	entry  *ScriptEntry
+}
+
+func callFrom(ctx context.Context) *call {
+	ret, _ := ctx.Value(callKey{}).(*call)
+	if ret == nil {
+		ret = &call{method: new(MethodScenario)}
+	}
+
+	return ret
+}
+
+func (p *call) payloadSize(def int) int {
+	if p.method.PayloadSize != nil {
+		return *p.method.PayloadSize
+	}
+
+	return def
+}
+
+func (p *call) messages(def int) int {
+	if p.method.Messages != nil {
+		return *p.method.Messages
+	}
+
+	return def
+}
+
+// send waits the message latency before a stream message is sent
+func (p *call) send(ctx context.Context) error {
+	return sleep(ctx, p.method.MessageLatency.sample())
+}
+
+func (p *call) acking(def string) string {
+	if p.entry != nil && p.entry.Acking != "" {
+		return p.entry.Acking
+	}
+
+	return def
+}
+
+func (p *call) name(def string) string {
+	if p.entry != nil && p.entry.Name != "" {
+		return p.entry.Name
+	}
+
+	return def
+}
+
+func (p *Scenario) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	method := p.method(info.FullMethod)
+	if method == nil {
+		return handler(ctx, req)
+	}
+
+	entry := method.next()
+	err := method.inject(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(context.WithValue(ctx, callKey{}, &call{method: method, entry: entry}), req)
+}
+
+// serverStream overrides the context of a stream
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (p *serverStream) Context() context.Context {
+	return p.ctx
+}
+
+func (p *Scenario) StreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	method := p.method(info.FullMethod)
+	if method == nil {
+		return handler(srv, ss)
+	}
+
+	entry := method.next()
+	err := method.inject(ss.Context(), entry)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.WithValue(ss.Context(), callKey{}, &call{method: method, entry: entry})
+	return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+}
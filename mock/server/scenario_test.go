@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	hello "tinker/mock/pb/hello"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// writeScenario writes data to a scenario file and returns its path
+func writeScenario(t *testing.T, data string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "scenario.json")
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatalf("fail to write scenario: %v", err)
+	}
+
+	return path
+}
+
+func TestLoadScenario(t *testing.T) {
+	s := grpc.NewServer()
+	hello.RegisterGreetingServer(s, new(Server))
+	hello.RegisterStreamServiceServer(s, new(Server))
+
+	tests := []struct {
+		name     string
+		scenario string
+		valid    bool
+	}{
+		{name: "example", scenario: `{"methods": {"Greet": {}, "/hello.StreamService/List": {}, "*": {}}}`, valid: true},
+		{name: "unknown short name", scenario: `{"methods": {"Gret": {}}}`},
+		{name: "unknown full name", scenario: `{"methods": {"/hello.Greeting/List": {}}}`},
+		{name: "error rule without code", scenario: `{"methods": {"Greet": {"errors": [{"rate": 0.5}]}}}`},
+		{name: "error rule with code OK", scenario: `{"methods": {"Greet": {"errors": [{"code": "OK", "rate": 0.5}]}}}`},
+		{name: "error rates above 1", scenario: `{"methods": {"Greet": {"errors": [{"code": "Internal", "rate": 0.6}, {"code": "Unavailable", "rate": 0.6}]}}}`},
+		{name: "unknown code", scenario: `{"methods": {"Greet": {"errors": [{"code": "Broken", "rate": 0.5}]}}}`},
+		{name: "unknown distribution", scenario: `{"methods": {"Greet": {"latency": {"distribution": "pareto"}}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scenario, err := LoadScenario(writeScenario(t, tt.scenario))
+			if err == nil {
+				err = scenario.checkMethods(s.GetServiceInfo())
+			}
+			if tt.valid && err != nil {
+				t.Fatalf("expect a valid scenario, got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Fatalf("expect an invalid scenario")
+			}
+		})
+	}
+
+	if _, err := LoadScenario("../scenario.example.json"); err != nil {
+		t.Fatalf("expect the example scenario to load, got %v", err)
+	}
+}
+
+func TestScenarioScript(t *testing.T) {
+	scenario, err := LoadScenario(writeScenario(t, `{"methods": {
+		"Greet": {"script": [{"acking": "scripted"}, {}, {"code": "NotFound", "message": "no such person"}]},
+		"*": {"errors": [{"code": "Unavailable", "rate": 1}]}
+	}}`))
+	if err != nil {
+		t.Fatalf("LoadScenario: %v", err)
+	}
+
+	greet := func(method string) (string, error) {
+		info := &grpc.UnaryServerInfo{FullMethod: method}
+		resp, err := scenario.UnaryInterceptor(context.Background(), &hello.GreetRequest{Person: hello.Name_Bob}, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return new(Server).Greet(ctx, req.(*hello.GreetRequest))
+		})
+		if err != nil {
+			return "", err
+		}
+		return resp.(*hello.GreetResponse).Acking, nil
+	}
+
+	// the script cycles
+	for i, want := range []string{"scripted", "Hi Bob", "", "scripted"} {
+		acking, err := greet("/hello.Greeting/Greet")
+		if want == "" {
+			if st := status.Convert(err); st.Code() != codes.NotFound || st.Message() != "no such person" {
+				t.Fatalf("call %d: expect the scripted error, got %v", i, err)
+			}
+			continue
+		}
+		if err != nil || acking != want {
+			t.Fatalf("call %d: expect %q, got %q, %v", i, want, acking, err)
+		}
+	}
+
+	// the other methods fall back to "*"
+	if _, err := greet("/hello.Other/Greet2"); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expect the injected error, got %v", err)
+	}
+}
@@ -1,39 +1,297 @@
 package api
 
 import (
+	"crypto/tls"
+	"expvar"
 	"fmt"
+	"net"
 	"net/http"
+	"time"
 
 	"tinker/pkg/api/httpcase"
 	"tinker/pkg/api/websocket"
+	"tinker/pkg/config"
+	"tinker/pkg/framework"
 
 	"github.com/golang/glog"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
-func Serve() (err error) {
+// Options of Serve
+type Options struct {
+	// RecordDir enables session recording when set, see framework.WithRecording
+	RecordDir string
+	// RecordHeaders are sensitive headers recorded in clear, the others are redacted
+	RecordHeaders []string
+	// RecordRedactQuery are query parameters redacted on top of the sensitive ones
+	RecordRedactQuery []string
+	// Faults are injected into every session when enabled, which needs
+	// framework.EnvFaultInjection set, see framework.WithFaultInjection.
+	// Never enable it in production
+	Faults framework.FaultConfig
+	// GrpcAddr is the address of the grpc listener forwarding any method to
+	// the backends, it is disabled when empty
+	GrpcAddr string
+	// AdminAddr is the address of the plain http listener of /debug/vars and
+	// /debug/pipelines, it is disabled when empty. Don't expose it publicly
+	AdminAddr string
 
-	grpcAddr1 := "127.0.0.1:8686"
-	grpcAddr2 := "127.0.0.1:8686"
-	grpcAddr3 := "127.0.0.1:8686"
-	grpcAddr4 := "127.0.0.1:8686"
-	grpcAddr5 := "127.0.0.1:8686"
+	// TLS of the http and grpc listeners, they serve plain text when it isn't enabled
+	TLS framework.TLSConfig
+	// H2C serves HTTP/2 without TLS on the http listener
+	H2C bool
+
+	// Config holds the routes, the upstreams and the TLS of the backends,
+	// config.Default() when nil
+	Config *config.Config
+	// ConfigFile is reloaded when it changes or on SIGHUP, its routes,
+	// upstreams, rate limits and timeouts are swapped without restart.
+	// It isn't watched when empty
+	ConfigFile string
+}
+
+// Handlers build the handlers of the routes by name from the targets of
+// their upstream, see config.Route. The "grpcweb" handler of the grpc-web
+// bridge routes services by upstream name instead
+var Handlers = map[string]func(targets []string) *framework.Handler{
+	"httpcase": func(targets []string) *framework.Handler {
+		return httpcase.NewHttpCase(targets...).Handler()
+	},
+	"greet": func(targets []string) *framework.Handler {
+		return httpcase.NewHttpCase(targets...).GreetHandler()
+	},
+	"websocket": func(targets []string) *framework.Handler {
+		return websocket.NewWebsocket(targets...).Handler()
+	},
+	"websocket_fanout": func(targets []string) *framework.Handler {
+		return websocket.NewWebsocket(targets...).FanOutHandler()
+	},
+	"websocket_fanin": func(targets []string) *framework.Handler {
+		return websocket.NewWebsocket(targets...).FanInHandler()
+	},
+}
+
+func Serve(opts Options) (err error) {
+	if opts.Faults.Enabled() && !framework.FaultInjectionAllowed() {
+		return fmt.Errorf("fault injection needs %s=1 in the environment", framework.EnvFaultInjection)
+	}
+
+	cfg := opts.Config
+	if cfg == nil {
+		cfg = config.Default()
+	}
+
+	for target, backendTLS := range cfg.Backends {
+		err = framework.DefaultBackendCredentials.Set(target, backendTLS)
+		if err != nil {
+			return err
+		}
+	}
+
+	var tlsConfig *tls.Config
+	if opts.TLS.Enabled() {
+		tlsConfig, err = opts.TLS.ServerConfig()
+		if err != nil {
+			return err
+		}
+	}
+
+	// backend connections shared by the proxies
+	pool := framework.NewGrpcPool()
+	defer pool.Close()
+
+	router, err := newRouter(opts, cfg, pool)
+	if err != nil {
+		return err
+	}
+	// the route table is swapped on reload
+	handler := framework.NewSwapHandler(router)
+	proxy := framework.NewGrpcProxy(pool, cfg.Upstreams)
+
+	if opts.ConfigFile != "" {
+		reloader := newReloader(opts.ConfigFile, cfg, func(cfg *config.Config) error {
+			router, err := newRouter(opts, cfg, pool)
+			if err != nil {
+				return err
+			}
+
+			handler.Store(router)
+			proxy.SetBackends(cfg.Upstreams)
+			if n := pool.Retain(upstreamTargets(cfg)...); n > 0 {
+				glog.Infof("reload: close %d connections of removed targets", n)
+			}
+			return nil
+		})
+		go reloader.watch()
+	}
 
 	// kong auth
 	var errGroup errgroup.Group
 	errGroup.Go(func() error {
-		httpCon := httpcase.NewHttpCase(grpcAddr1, grpcAddr2)
-		http.Handle("/httpcase", httpCon.Handler())
-
-		streamCon := websocket.NewWebsocket(grpcAddr1, grpcAddr2, grpcAddr3, grpcAddr4, grpcAddr5)
-		http.Handle("/websocket", streamCon.Handler())
-
-		if err := http.ListenAndServe(fmt.Sprintf(":%d", 8585), nil); err != nil {
+		server := &http.Server{
+			Addr:    fmt.Sprintf(":%d", 8585),
+			Handler: handler,
+		}
+		if tlsConfig != nil {
+			// each listener adds its protocols to its own copy
+			server.TLSConfig = tlsConfig.Clone()
+			// HTTP/2 is negotiated by ALPN
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			if opts.H2C {
+				server.Handler = h2c.NewHandler(handler, &http2.Server{})
+			}
+			err = server.ListenAndServe()
+		}
+		if err != nil {
 			glog.Errorf("api exit with error: %s", err.Error())
 		}
 
 		return err
 	})
 
+	if opts.AdminAddr != "" {
+		errGroup.Go(func() error {
+			glog.Infof("admin listener on %s", opts.AdminAddr)
+			err := http.ListenAndServe(opts.AdminAddr, adminHandler(handler))
+			glog.Errorf("admin listener exit with error: %s", err.Error())
+			return err
+		})
+	}
+
+	if opts.GrpcAddr != "" {
+		errGroup.Go(func() error {
+			serverOpts := []grpc.ServerOption{grpc.MaxRecvMsgSize(proxy.MaxMessageSize)}
+			if tlsConfig != nil {
+				serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(tlsConfig.Clone())))
+			}
+			srv := framework.NewGrpcServer(grpcHandler(opts, proxy), serverOpts...)
+
+			lis, err := net.Listen("tcp", opts.GrpcAddr)
+			if err != nil {
+				glog.Errorf("grpc listener exit with error: %s", err.Error())
+				return err
+			}
+			glog.Infof("grpc listener on %s", opts.GrpcAddr)
+
+			if err := srv.Serve(lis); err != nil {
+				glog.Errorf("grpc listener exit with error: %s", err.Error())
+				return err
+			}
+			return nil
+		})
+	}
+
 	return errGroup.Wait()
 }
+
+// upstreamTargets returns the targets of the upstreams of cfg
+func upstreamTargets(cfg *config.Config) []string {
+	var ret []string
+	for _, targets := range cfg.Upstreams {
+		ret = append(ret, targets...)
+	}
+
+	return ret
+}
+
+// grpcHandler returns the handler of the grpc listener, with the recording and
+// fault injection of opts
+func grpcHandler(opts Options, proxy *framework.GrpcProxy) *framework.Handler {
+	handler := proxy.Handler()
+	if opts.RecordDir != "" {
+		handler.UseFirst(framework.WithRecordingConfig(framework.RecordingConfig{
+			Dir:          opts.RecordDir,
+			AllowHeaders: opts.RecordHeaders,
+			RedactQuery:  opts.RecordRedactQuery,
+		}))
+	}
+	if opts.Faults.Enabled() {
+		handler.Use(framework.WithFaultInjection(opts.Faults))
+	}
+
+	return handler
+}
+
+// newRouter returns the router of the routes of cfg, or an error if they
+// don't make a valid route table
+func newRouter(opts Options, cfg *config.Config, pool *framework.GrpcPool) (router *framework.Router, err error) {
+	defer func() {
+		// the router panics on invalid or duplicated routes
+		if perr := recover(); perr != nil {
+			err = fmt.Errorf("%v", perr)
+		}
+	}()
+
+	// grpc-web and Connect clients, e.g. POST /hello.Greeting/Greet. The
+	// bridge dials the first target of each upstream
+	backends := make(map[string]string, len(cfg.Upstreams))
+	for name, targets := range cfg.Upstreams {
+		backends[name] = targets[0]
+	}
+	bridge := framework.NewGrpcBridge(pool, backends)
+
+	router = framework.NewRouter()
+	for _, route := range cfg.Routes {
+		var handler *framework.Handler
+		if route.Handler == "grpcweb" {
+			handler = bridge.Handler()
+		} else {
+			newHandler, ok := Handlers[route.Handler]
+			if !ok {
+				return nil, fmt.Errorf("route '%s %s': unknown handler '%s'", route.Method, route.Pattern, route.Handler)
+			}
+			handler = newHandler(cfg.Upstreams[route.Upstream])
+		}
+
+		if opts.RecordDir != "" {
+			handler.UseFirst(framework.WithRecordingConfig(framework.RecordingConfig{
+				Dir:          opts.RecordDir,
+				AllowHeaders: opts.RecordHeaders,
+				RedactQuery:  opts.RecordRedactQuery,
+			}))
+		}
+		if opts.Faults.Enabled() {
+			handler.Use(framework.WithFaultInjection(opts.Faults))
+		}
+		if route.RateLimit.Enabled() {
+			useBeforeGrpc(handler, framework.WithRateLimit(framework.NewRateLimiter(route.RateLimit)))
+		}
+		if route.Timeout > 0 {
+			useBeforeGrpc(handler, framework.WithTimeout(time.Duration(route.Timeout)))
+		}
+		router.Handle(route.Method, route.Pattern, handler)
+	}
+
+	for _, route := range router.Routes() {
+		glog.Infof("route %-6s %-28s %s", route.Method, route.Pattern, route.Name)
+	}
+
+	return router, nil
+}
+
+// useBeforeGrpc installs wrappers right outside WithGrpc, inside the error
+// reply wrappers, so that the backends are not dialed for the sessions they
+// reject and the dial counts in the timeout. They are the innermost wrappers
+// of a handler without WithGrpc
+func useBeforeGrpc(handler *framework.Handler, wrappers ...framework.Wrapper) {
+	if err := handler.InsertBefore("WithGrpc", wrappers...); err != nil {
+		handler.Use(wrappers...)
+	}
+}
+
+// adminHandler serves the metrics and the pipelines of the current route table
+// of handler
+func adminHandler(handler *framework.SwapHandler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.Handle("/debug/pipelines", http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		handler.Load().(*framework.Router).PipelinesHandler().ServeHTTP(rw, req)
+	}))
+
+	return mux
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tinker/pkg/config"
+	"tinker/pkg/framework"
+	"tinker/pkg/framework/frameworktest"
+
+	"google.golang.org/grpc"
+)
+
+// countingListener counts the accepted connections
+type countingListener struct {
+	net.Listener
+	accepted int32
+}
+
+func (p *countingListener) Accept() (net.Conn, error) {
+	conn, err := p.Listener.Accept()
+	if err == nil {
+		atomic.AddInt32(&p.accepted, 1)
+	}
+
+	return conn, err
+}
+
+// serveHello serves a HelloServer on a local port
+func serveHello(t *testing.T) (string, *countingListener) {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("fail to listen: %v", err)
+	}
+	counting := &countingListener{Listener: lis}
+	server := grpc.NewServer()
+	new(frameworktest.HelloServer).Register(server)
+	go server.Serve(counting)
+	t.Cleanup(server.Stop)
+
+	return lis.Addr().String(), counting
+}
+
+func TestRateLimitBeforeDial(t *testing.T) {
+	addr, lis := serveHello(t)
+	cfg := config.Default()
+	cfg.Upstreams = map[string][]string{"*": {addr}}
+	cfg.Routes = []config.Route{
+		{Method: "GET", Pattern: "/httpcase", Handler: "httpcase", Upstream: "*", Timeout: config.Duration(5 * time.Second), RateLimit: framework.RateLimit{RPS: 0.001, Burst: 1}},
+		{Method: "GET", Pattern: "/websocket", Handler: "websocket", Upstream: "*", Timeout: config.Duration(5 * time.Second), RateLimit: framework.RateLimit{RPS: 0.001, Burst: 1}},
+	}
+	router, err := newRouter(Options{}, cfg, nil)
+	if err != nil {
+		t.Fatalf("newRouter: %v", err)
+	}
+
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/httpcase", nil))
+		if rec.Code != want {
+			t.Fatalf("request %d: expect status %d, got %d: %s", i, want, rec.Code, rec.Body.String())
+		}
+	}
+	if n := atomic.LoadInt32(&lis.accepted); n != 1 {
+		t.Fatalf("expect the backend dialed once, got %d", n)
+	}
+
+	// the websocket sessions are rejected after the upgrade, with a websocket error
+	expect := []string{"WithRequestID", "WithWebsocket", "WithReplyWsError", "WithRateLimit", "WithTimeout", "WithGrpc"}
+	for _, route := range router.Routes() {
+		if route.Pattern != "/websocket" {
+			continue
+		}
+		if desc := route.Handler.Describe(); !reflect.DeepEqual(desc.Wrappers, expect) {
+			t.Fatalf("expect wrappers %v, got %v", expect, desc.Wrappers)
+		}
+	}
+}
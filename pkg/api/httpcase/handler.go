@@ -1,8 +1,6 @@
 package httpcase
 
 import (
-	"context"
-
 	"tinker/mock/pb/hello"
 	"tinker/pkg/framework"
 
@@ -27,20 +25,33 @@ func (p *httpCase) Handler() *framework.Handler {
 	return ret
 }
 
+// GreetHandler greets the {person} path parameter
+func (p *httpCase) GreetHandler() *framework.Handler {
+	ret := framework.DefaultHttpHandler("greet", p.grpcAddrs)
+
+	ret.Add(p.GreetPerson)
+
+	return ret
+}
+
 // CallGRPC creates a client
 func (p *httpCase) CallGRPC(sess *framework.Session) error {
 	// 此处仅模拟使用1个grpc conn
 	c := hello.NewGreetingClient(sess.GrpcConns[0])
 
-	// 发起请求
+	// 发起请求, 可由 query string 覆盖, e.g. ?Saying=Hi&Person=Bob
 	request := &hello.GreetRequest{
 		Saying: "hello",
 		Person: hello.Name_Joe,
-		Time:   timestamppb.Now(),
 		Fruit:  hello.GreetRequest_apple,
 	}
+	err := framework.Bind(sess, request)
+	if err != nil {
+		return err
+	}
+	request.Time = timestamppb.Now()
 
-	response, err := c.Greet(context.Background(), request)
+	response, err := c.Greet(sess.Ctx, request)
 	if err != nil {
 		sess.Errorf("CallGRPC: fail to call grpc: %s", err.Error())
 		return err
@@ -54,3 +65,39 @@ func (p *httpCase) CallGRPC(sess *framework.Session) error {
 
 	return nil
 }
+
+// greetParams are the parameters of GreetPerson
+type greetParams struct {
+	Person string `path:"person" validate:"required,oneof=Joe Wanghao Bob Robot"`
+	Saying string `query:"saying" validate:"max=64"`
+}
+
+// GreetPerson greets the person named by the {person} path parameter
+func (p *httpCase) GreetPerson(sess *framework.Session) error {
+	params := greetParams{Saying: "Hi"}
+	err := framework.Bind(sess, &params)
+	if err != nil {
+		return err
+	}
+
+	c := hello.NewGreetingClient(sess.GrpcConns[0])
+	request := &hello.GreetRequest{
+		Saying: params.Saying,
+		Person: hello.Name(hello.Name_value[params.Person]),
+		Time:   timestamppb.Now(),
+	}
+
+	response, err := c.Greet(sess.Ctx, request)
+	if err != nil {
+		sess.Errorf("GreetPerson: fail to call grpc: %s", err.Error())
+		return err
+	}
+
+	err = framework.SendHttpResult(sess, response)
+	if err != nil {
+		sess.Errorf("GreetPerson: fail to send response to client: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package httpcase_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"tinker/mock/pb/hello"
+	"tinker/pkg/api/httpcase"
+	"tinker/pkg/framework"
+	"tinker/pkg/framework/frameworktest"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCallGRPC(t *testing.T) {
+	conn := frameworktest.HelloConn(t, new(frameworktest.HelloServer))
+	handler := frameworktest.HttpHandler([]*grpc.ClientConn{conn}, httpcase.NewHttpCase().CallGRPC)
+
+	tests := []struct {
+		target string
+		acking string
+	}{
+		{"/httpcase", "Hi Joe"},
+		{"/httpcase?Person=Bob", "Hi Bob"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			var resp struct {
+				Acking string `json:"Acking"`
+			}
+			rec := frameworktest.ServeHttp(handler, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			frameworktest.ExpectHttp(t, rec, http.StatusOK, &resp)
+			if resp.Acking != tt.acking {
+				t.Fatalf("expect acking %s, got %s", tt.acking, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestCallGRPCInvalidQuery(t *testing.T) {
+	conn := frameworktest.HelloConn(t, new(frameworktest.HelloServer))
+	handler := frameworktest.HttpHandler([]*grpc.ClientConn{conn}, httpcase.NewHttpCase().CallGRPC)
+
+	rec := frameworktest.ServeHttp(handler, httptest.NewRequest(http.MethodGet, "/httpcase?Person=Nobody", nil))
+	frameworktest.ExpectHttpError(t, rec, http.StatusBadRequest)
+}
+
+func TestCallGRPCProtojson(t *testing.T) {
+	conn := frameworktest.HelloConn(t, new(frameworktest.HelloServer))
+	handler := frameworktest.HttpHandler([]*grpc.ClientConn{conn}, httpcase.NewHttpCase().CallGRPC)
+
+	// protojson: proto field names, enums by name, timestamps in RFC 3339
+	var resp map[string]interface{}
+	rec := frameworktest.ServeHttp(handler, httptest.NewRequest(http.MethodGet, "/httpcase?Person=Bob", nil))
+	frameworktest.ExpectHttp(t, rec, http.StatusOK, &resp)
+	if contentType := rec.Header().Get("Content-Type"); contentType != framework.ContentTypeJSON {
+		t.Fatalf("expect JSON, got %s", contentType)
+	}
+
+	var keys []string
+	for k := range resp {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"Acking", "Name", "Time"}) {
+		t.Fatalf("unexpected fields %v", keys)
+	}
+	if resp["Acking"] != "Hi Bob" || resp["Name"] != "Robot" {
+		t.Fatalf("unexpected response %v", resp)
+	}
+	if ts, _ := resp["Time"].(string); ts == "" {
+		t.Fatalf("expect an RFC 3339 time, got %v", resp["Time"])
+	} else if _, err := time.Parse(time.RFC3339Nano, ts); err != nil {
+		t.Fatalf("expect an RFC 3339 time, got %s", ts)
+	}
+}
+
+func TestCallGRPCProtobuf(t *testing.T) {
+	conn := frameworktest.HelloConn(t, new(frameworktest.HelloServer))
+	handler := frameworktest.HttpHandler([]*grpc.ClientConn{conn}, httpcase.NewHttpCase().CallGRPC)
+
+	req := httptest.NewRequest(http.MethodGet, "/httpcase", nil)
+	req.Header.Set("Accept", "application/json;q=0.5, "+framework.ContentTypeProtobuf)
+	rec := frameworktest.ServeHttp(handler, req)
+	frameworktest.ExpectHttp(t, rec, http.StatusOK, nil)
+	if contentType := rec.Header().Get("Content-Type"); contentType != framework.ContentTypeProtobuf {
+		t.Fatalf("expect protobuf, got %s", contentType)
+	}
+
+	resp := new(hello.GreetResponse)
+	err := proto.Unmarshal(rec.Body.Bytes(), resp)
+	if err != nil || resp.Acking != "Hi Joe" || resp.Name != hello.Name_Robot {
+		t.Fatalf("unexpected response %v, %v", resp, err)
+	}
+}
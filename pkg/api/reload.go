@@ -0,0 +1,97 @@
+package api
+
+import (
+	"expvar"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"tinker/pkg/config"
+
+	"github.com/golang/glog"
+)
+
+// ReloadInterval is the interval between checks of the config file
+var ReloadInterval = time.Second
+
+// reload metrics, exported on /debug/vars:
+//   - success and failure count the reloads
+//   - last_success is the time of the last config applied
+//   - last_error is the error of the last reload failed
+var reloadMetrics = expvar.NewMap("config_reload")
+
+// reloader applies the config file when it changes or on SIGHUP. A config
+// failing to load or to apply is logged and the current one kept
+type reloader struct {
+	path  string
+	apply func(cfg *config.Config) error
+
+	current *config.Config
+	modTime time.Time
+}
+
+func newReloader(path string, current *config.Config, apply func(cfg *config.Config) error) *reloader {
+	ret := &reloader{
+		path:    path,
+		apply:   apply,
+		current: current,
+	}
+	if info, err := os.Stat(path); err == nil {
+		ret.modTime = info.ModTime()
+	}
+
+	return ret
+}
+
+// watch reloads the config forever
+func (p *reloader) watch() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hup:
+			glog.Infof("reload: SIGHUP received")
+			p.reload()
+		case <-ticker.C:
+			// the file may be missing for a while when it is replaced
+			info, err := os.Stat(p.path)
+			if err != nil || info.ModTime().Equal(p.modTime) {
+				continue
+			}
+			p.modTime = info.ModTime()
+			glog.Infof("reload: config file %s changed", p.path)
+			p.reload()
+		}
+	}
+}
+
+func (p *reloader) reload() {
+	cfg, err := config.Load(p.path)
+	if err == nil {
+		err = p.apply(cfg)
+	}
+	if err != nil {
+		glog.Errorf("reload: keep the current config: %v", err)
+		reloadMetrics.Add("failure", 1)
+		lastError := new(expvar.String)
+		lastError.Set(err.Error())
+		reloadMetrics.Set("last_error", lastError)
+		return
+	}
+
+	if cfg.TLS != p.current.TLS || cfg.H2C != p.current.H2C || !reflect.DeepEqual(cfg.Backends, p.current.Backends) {
+		glog.Warningf("reload: tls, h2c and backends changes need a restart")
+	}
+	p.current = cfg
+
+	glog.Infof("reload: config %s applied, %d routes and %d upstreams", p.path, len(cfg.Routes), len(cfg.Upstreams))
+	reloadMetrics.Add("success", 1)
+	lastSuccess := new(expvar.String)
+	lastSuccess.Set(time.Now().UTC().Format(time.RFC3339))
+	reloadMetrics.Set("last_success", lastSuccess)
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"tinker/pkg/config"
+)
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	write := func(data string) {
+		t.Helper()
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatalf("fail to write config: %v", err)
+		}
+	}
+
+	var applied []*config.Config
+	var applyErr error
+	current := config.Default()
+	reloader := newReloader(path, current, func(cfg *config.Config) error {
+		if applyErr != nil {
+			return applyErr
+		}
+		applied = append(applied, cfg)
+		return nil
+	})
+
+	// invalid config
+	write(`{"routes": [{"method": "GET", "pattern": "/a", "upstream": "nope"}]}`)
+	reloader.reload()
+	if reloader.current != current || len(applied) != 0 {
+		t.Fatalf("expect the current config kept on invalid config")
+	}
+
+	// config failing to apply
+	write(`{"upstreams": {"*": ["10.0.0.1:8686"]}, "routes": [{"method": "GET", "pattern": "/a", "handler": "httpcase"}]}`)
+	applyErr = errors.New("duplicated route")
+	reloader.reload()
+	if reloader.current != current || len(applied) != 0 {
+		t.Fatalf("expect the current config kept when apply fails")
+	}
+
+	applyErr = nil
+	reloader.reload()
+	if len(applied) != 1 || reloader.current != applied[0] || reloader.current.Upstreams["*"][0] != "10.0.0.1:8686" {
+		t.Fatalf("expect the new config applied, got %+v", reloader.current)
+	}
+}
+
+func TestNewRouterInvalid(t *testing.T) {
+	cfg := config.Default()
+	cfg.Routes = append(cfg.Routes, config.Route{Method: "GET", Pattern: "/httpcase", Handler: "httpcase", Upstream: "*"})
+	if _, err := newRouter(Options{}, cfg, nil); err == nil {
+		t.Fatalf("expect a duplicated route to fail")
+	}
+
+	cfg = config.Default()
+	cfg.Routes = []config.Route{{Method: "GET", Pattern: "/a", Handler: "nope", Upstream: "*"}}
+	if _, err := newRouter(Options{}, cfg, nil); err == nil {
+		t.Fatalf("expect an unknown handler to fail")
+	}
+}
@@ -0,0 +1,50 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+
+	"tinker/mock/pb/hello"
+	"tinker/pkg/framework"
+
+	"google.golang.org/grpc"
+)
+
+// FanInHandler multiplexes the List streams of every grpc connection onto the websocket
+func (p *websocket) FanInHandler() *framework.Handler {
+	ret := framework.DefaultWsHandler("websocketFanIn", p.grpcAddrs)
+
+	ret.Add(func(sess *framework.Session) error {
+		sources := make([]*framework.FanInSource, len(sess.GrpcConns))
+		for i, conn := range sess.GrpcConns {
+			sources[i] = listSource(grpcClientKey(i), conn)
+		}
+
+		return framework.FanIn(sources...)(sess)
+	})
+
+	return ret
+}
+
+func listSource(name string, conn *grpc.ClientConn) *framework.FanInSource {
+	return &framework.FanInSource{
+		Name: name,
+		Open: func(ctx context.Context, sess *framework.Session) (grpc.ClientStream, error) {
+			return hello.NewStreamServiceClient(conn).List(ctx, &hello.StreamRequest{
+				Pt: &hello.StreamPoint{
+					Name: "gRPC Stream Server: List " + name,
+				},
+			}, grpc.MaxCallRecvMsgSize(1024*1024*8)) // mock server sends 6m messages
+		},
+		NewMsg: func() interface{} {
+			return new(hello.StreamResponse)
+		},
+		Render: func(msg interface{}) (interface{}, error) {
+			resp, ok := msg.(*hello.StreamResponse)
+			if !ok || resp.GetPt() == nil {
+				return nil, fmt.Errorf("reply without point")
+			}
+			return fmt.Sprintf("resp: pj.name: %s, len(pt.value): %d", resp.Pt.Name, len(resp.Pt.Value)), nil
+		},
+	}
+}
@@ -0,0 +1,20 @@
+package websocket
+
+import (
+	"testing"
+
+	"tinker/mock/pb/hello"
+)
+
+func TestListSourceRender(t *testing.T) {
+	source := listSource("grpc1", nil)
+
+	if _, err := source.Render(&hello.StreamResponse{}); err == nil {
+		t.Fatalf("expect a reply without point to fail")
+	}
+
+	data, err := source.Render(&hello.StreamResponse{Pt: &hello.StreamPoint{Name: "a", Value: []byte("xyz")}})
+	if err != nil || data != "resp: pj.name: a, len(pt.value): 3" {
+		t.Fatalf("unexpected rendering %v, %v", data, err)
+	}
+}
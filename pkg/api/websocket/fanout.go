@@ -0,0 +1,73 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+
+	"tinker/mock/pb/hello"
+	"tinker/pkg/framework"
+)
+
+// FanOutHandler streams the websocket to the Record method of every grpc connection
+func (p *websocket) FanOutHandler() *framework.Handler {
+	ret := framework.DefaultWsHandler("websocketFanOut", p.grpcAddrs)
+
+	ret.Add(framework.FanOut(framework.FanOutConfig{
+		Open:       p.openRecordStreams,
+		Policy:     framework.FanOutDegraded,
+		Merge:      mergeRecordResults,
+		BufferSize: 8,
+	}))
+
+	return ret
+}
+
+func (p *websocket) openRecordStreams(ctx context.Context, sess *framework.Session) ([]*framework.FanOutTarget, error) {
+	ret := make([]*framework.FanOutTarget, 0, len(sess.GrpcConns))
+	for i, conn := range sess.GrpcConns {
+		name := grpcClientKey(i)
+
+		streamc, err := hello.NewStreamServiceClient(conn).Record(ctx)
+		if err != nil {
+			sess.Errorf("openRecordStreams: fail to call grpc: %s", err.Error())
+			return nil, err
+		}
+
+		ret = append(ret, &framework.FanOutTarget{
+			Name:   name,
+			Stream: streamc,
+			Transform: func(data []byte) (interface{}, error) {
+				return &hello.StreamRequest{
+					Pt: &hello.StreamPoint{
+						Name:  "gRPC Stream Server: Record " + name,
+						Value: data,
+					},
+				}, nil
+			},
+			NewReply: func() interface{} {
+				return new(hello.StreamResponse)
+			},
+		})
+	}
+
+	return ret, nil
+}
+
+func mergeRecordResults(sess *framework.Session, results []*framework.FanOutResult) (interface{}, error) {
+	ret := make(map[string]string, len(results))
+	for _, result := range results {
+		if result.Err != nil {
+			ret[result.Name] = "error: " + result.Err.Error()
+			continue
+		}
+
+		resp, ok := result.Reply.(*hello.StreamResponse)
+		if !ok || resp.GetPt() == nil {
+			ret[result.Name] = "error: reply without point"
+			continue
+		}
+		ret[result.Name] = fmt.Sprintf("resp: pj.name: %s, len(pt.value): %d", resp.Pt.Name, len(resp.Pt.Value))
+	}
+
+	return ret, nil
+}
@@ -1,7 +1,6 @@
 package websocket
 
 import (
-	"context"
 	"crypto/rand"
 	"fmt"
 
@@ -9,8 +8,11 @@ import (
 	"tinker/pkg/framework"
 )
 
-// 与 NewWebsocket 注册时保持一致
-var gprcClientKeys = []string{"grpc1", "grpc2", "grpc3", "grpc4", "grpc5"}
+// grpcClientKey is the session key of the client of the i-th grpc connection,
+// e.g. "grpc1"
+func grpcClientKey(i int) string {
+	return fmt.Sprintf("grpc%d", i+1)
+}
 
 type websocket struct {
 	grpcAddrs []string
@@ -40,13 +42,13 @@ func (p *websocket) CreateClient(sess *framework.Session) error {
 		// 初始化客户端
 		// 此处仅模拟使用的是5个相同的grpc服务，实际场景根据业务需求请求相应的grpc 服务
 		c := hello.NewStreamServiceClient(conn)
-		streamc, err := c.Record(context.Background())
+		streamc, err := c.Record(sess.Ctx)
 		if err != nil {
 			sess.Errorf("CreateClient: fail to call grpc: %s", err.Error())
 			return err
 		}
 
-		sess.Set(gprcClientKeys[i], streamc)
+		sess.Set(grpcClientKey(i), streamc)
 	}
 
 	return nil
@@ -63,7 +65,7 @@ func getGrpcClient(sess *framework.Session, gprcClientKey string) hello.StreamSe
 // SendStream
 func (p *websocket) SendStream(sess *framework.Session) error {
 	// 仅用 grpc1 作演示
-	grpc1Client := getGrpcClient(sess, gprcClientKeys[0])
+	grpc1Client := getGrpcClient(sess, grpcClientKey(0))
 
 	processMethod := func(data []byte) error {
 		grpcRequest := &hello.StreamRequest{
@@ -80,7 +82,9 @@ func (p *websocket) SendStream(sess *framework.Session) error {
 		return ierr
 	}
 
-	err := framework.StreamForeach(sess, processMethod)
+	// buffer frames between websocket reads and grpc sends, a slow grpc server
+	// makes the client receive backpressure signals instead of stalling reads
+	_, err := framework.StreamPipeline(sess, framework.DefaultPipelineConfig, processMethod)
 	if err != nil {
 		return err
 	}
@@ -99,7 +103,7 @@ func (p *websocket) SendStream(sess *framework.Session) error {
 // ReceveResult
 func (p *websocket) ReceveResult(sess *framework.Session) error {
 	// 仅用 grpc1 作演示
-	grpc1Client := getGrpcClient(sess, gprcClientKeys[0])
+	grpc1Client := getGrpcClient(sess, grpcClientKey(0))
 
 	grpcResp, err := grpc1Client.CloseAndRecv()
 	if err != nil {
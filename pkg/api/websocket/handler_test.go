@@ -0,0 +1,71 @@
+package websocket_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	apiws "tinker/pkg/api/websocket"
+	"tinker/pkg/framework/frameworktest"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc"
+)
+
+func TestRecord(t *testing.T) {
+	srv := new(frameworktest.HelloServer)
+	conn := frameworktest.HelloConn(t, srv)
+	ws := apiws.NewWebsocket()
+	handler := frameworktest.WsHandler([]*grpc.ClientConn{conn}, ws.CreateClient, ws.SendStream, ws.ReceveResult)
+
+	client := frameworktest.ServeWs(t, handler, nil)
+	client.Send([]byte("hello"))
+	client.Send([]byte("world"))
+	client.SendEOS()
+
+	var result string
+	client.ExpectSuccess().Decode(t, &result)
+	if !strings.Contains(result, "gRPC Stream Server: Record") {
+		t.Fatalf("unexpected result %s", result)
+	}
+	client.ExpectClosed(websocket.CloseNormalClosure)
+
+	if n := len(srv.Received()); n != 2 {
+		t.Fatalf("expect 2 stream requests, got %d", n)
+	}
+}
+
+// serveHello serves srv on a local port and returns its address
+func serveHello(t *testing.T, srv *frameworktest.HelloServer) string {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("fail to listen: %v", err)
+	}
+	server := grpc.NewServer()
+	srv.Register(server)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	return lis.Addr().String()
+}
+
+func TestFanOutManyBackends(t *testing.T) {
+	srv := new(frameworktest.HelloServer)
+	addr := serveHello(t, srv)
+	addrs := []string{addr, addr, addr, addr, addr, addr, addr}
+
+	client := frameworktest.ServeWs(t, apiws.NewWebsocket(addrs...).FanOutHandler(), nil)
+	client.Send([]byte("hello"))
+	client.SendEOS()
+
+	var result map[string]string
+	client.ExpectSuccess().Decode(t, &result)
+	if len(result) != len(addrs) || !strings.HasPrefix(result["grpc7"], "resp:") {
+		t.Fatalf("expect the replies of %d backends, got %v", len(addrs), result)
+	}
+	if n := len(srv.Received()); n != len(addrs) {
+		t.Fatalf("expect %d stream requests, got %d", len(addrs), n)
+	}
+}
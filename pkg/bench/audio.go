@@ -0,0 +1,185 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Audio is PCM audio streamed at its real bitrate by websocket sessions
+type Audio struct {
+	Data          []byte
+	SampleRate    int
+	Channels      int
+	BitsPerSample int
+}
+
+// ByteRate is the number of bytes per second of audio
+func (p *Audio) ByteRate() int {
+	return p.SampleRate * p.Channels * p.BitsPerSample / 8
+}
+
+func (p *Audio) blockAlign() int {
+	return p.Channels * p.BitsPerSample / 8
+}
+
+// validate checks that the format describes whole samples
+func (p *Audio) validate() error {
+	if p.SampleRate <= 0 || p.Channels <= 0 || p.BitsPerSample <= 0 || p.BitsPerSample%8 != 0 {
+		return fmt.Errorf("invalid pcm format: %d Hz, %d channels, %d bits per sample", p.SampleRate, p.Channels, p.BitsPerSample)
+	}
+
+	return nil
+}
+
+// Duration of the audio
+func (p *Audio) Duration() time.Duration {
+	return p.bytesDuration(len(p.Data))
+}
+
+func (p *Audio) bytesDuration(n int) time.Duration {
+	return time.Duration(n) * time.Second / time.Duration(p.ByteRate())
+}
+
+// Clip returns d of audio, looping the audio if it is shorter than d
+func (p *Audio) Clip(d time.Duration) []byte {
+	n := int(d.Seconds() * float64(p.ByteRate()))
+	n -= n % p.blockAlign()
+	if n <= 0 || n == len(p.Data) {
+		return p.Data
+	}
+
+	ret := make([]byte, 0, n)
+	for len(ret) < n {
+		rest := n - len(ret)
+		if rest > len(p.Data) {
+			rest = len(p.Data)
+		}
+		ret = append(ret, p.Data[:rest]...)
+	}
+
+	return ret
+}
+
+// LoadAudio loads a WAV file, or raw PCM described by pcm for other files
+func LoadAudio(path string, pcm Audio) (*Audio, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(data, []byte("RIFF")) {
+		return parseWav(data)
+	}
+
+	if err := pcm.validate(); err != nil {
+		return nil, err
+	}
+	pcm.Data = data
+
+	return &pcm, nil
+}
+
+// parseWav reads the fmt and data chunks of a PCM WAV file
+func parseWav(data []byte) (*Audio, error) {
+	if len(data) < 12 || string(data[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("invalid wav file")
+	}
+
+	ret := new(Audio)
+	for offset := 12; offset+8 <= len(data); {
+		id := string(data[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+		body := data[offset+8:]
+		if size > len(body) {
+			size = len(body)
+		}
+		body = body[:size]
+
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, fmt.Errorf("invalid wav fmt chunk")
+			}
+			if format := binary.LittleEndian.Uint16(body[0:2]); format != 1 {
+				return nil, fmt.Errorf("unsupported wav format %d, only PCM is supported", format)
+			}
+			ret.Channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			ret.SampleRate = int(binary.LittleEndian.Uint32(body[4:8]))
+			ret.BitsPerSample = int(binary.LittleEndian.Uint16(body[14:16]))
+		case "data":
+			ret.Data = body
+		}
+
+		// chunks are padded to an even size
+		offset += 8 + size + size%2
+	}
+
+	if ret.SampleRate == 0 || ret.Data == nil {
+		return nil, fmt.Errorf("invalid wav file: missing fmt or data chunk")
+	}
+	if err := ret.validate(); err != nil {
+		return nil, fmt.Errorf("invalid wav file: %v", err)
+	}
+
+	return ret, nil
+}
+
+// MixEntry is a session length with its weight in a SessionMix
+type MixEntry struct {
+	Length time.Duration
+	Weight int
+}
+
+// SessionMix is a weighted mix of audio session lengths
+type SessionMix []MixEntry
+
+// ParseMix parses a mix like "5s:50,30s:30,2m:20"
+func ParseMix(s string) (SessionMix, error) {
+	var ret SessionMix
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid mix entry '%s', expect <length>:<weight>", item)
+		}
+
+		length, err := time.ParseDuration(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid mix length '%s': %v", parts[0], err)
+		}
+		weight, err := strconv.Atoi(parts[1])
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("invalid mix weight '%s'", parts[1])
+		}
+
+		ret = append(ret, MixEntry{Length: length, Weight: weight})
+	}
+
+	return ret, nil
+}
+
+// pick returns a session length drawn from the mix, 0 if the mix is empty
+func (p SessionMix) pick(rnd *rand.Rand) time.Duration {
+	total := 0
+	for _, entry := range p {
+		total += entry.Weight
+	}
+	if total == 0 {
+		return 0
+	}
+
+	n := rnd.Intn(total)
+	for _, entry := range p {
+		if n < entry.Weight {
+			return entry.Length
+		}
+		n -= entry.Weight
+	}
+
+	return 0
+}
@@ -0,0 +1,83 @@
+package bench
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// audioStats are the metrics of audio streaming sessions
+type audioStats struct {
+	firstResult *hdrhistogram.Histogram // stream start to first result
+	finalResult *hdrhistogram.Histogram // EOS to final result
+	jitter      *hdrhistogram.Histogram // delay of chunk sends against their schedule
+	rtf         *hdrhistogram.Histogram // real-time factor x1000
+	audio       time.Duration
+}
+
+func newAudioStats() *audioStats {
+	return &audioStats{
+		firstResult: newHistogram(),
+		finalResult: newHistogram(),
+		jitter:      newHistogram(),
+		rtf:         hdrhistogram.New(1, 1000*1000, histogramSigFigs),
+	}
+}
+
+func (p *audioStats) merge(other *audioStats) {
+	p.firstResult.Merge(other.firstResult)
+	p.finalResult.Merge(other.finalResult)
+	p.jitter.Merge(other.jitter)
+	p.rtf.Merge(other.rtf)
+	p.audio += other.audio
+}
+
+func (p *audioStats) report() *AudioReport {
+	rtf := func(v int64) float64 {
+		return float64(v) / 1000
+	}
+
+	return &AudioReport{
+		AudioSeconds: p.audio.Seconds(),
+		FirstResult:  newLatencyReport(p.firstResult),
+		FinalResult:  newLatencyReport(p.finalResult),
+		Jitter:       newLatencyReport(p.jitter),
+		RTF: RTFReport{
+			Mean: p.rtf.Mean() / 1000,
+			P50:  rtf(p.rtf.ValueAtQuantile(50)),
+			P90:  rtf(p.rtf.ValueAtQuantile(90)),
+			P99:  rtf(p.rtf.ValueAtQuantile(99)),
+			Max:  rtf(p.rtf.Max()),
+		},
+	}
+}
+
+// RTFReport summarizes the real-time factor: time from the stream start to the
+// final result divided by the audio duration
+type RTFReport struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// AudioReport is the part of Report about audio streaming sessions
+type AudioReport struct {
+	AudioSeconds float64       `json:"audio_s"`
+	FirstResult  LatencyReport `json:"time_to_first_result"`
+	FinalResult  LatencyReport `json:"eos_to_final_result"`
+	Jitter       LatencyReport `json:"send_jitter"`
+	RTF          RTFReport     `json:"real_time_factor"`
+}
+
+func (p *AudioReport) writeText(w io.Writer) {
+	fmt.Fprintf(w, "audio streamed: %.1fs\n", p.AudioSeconds)
+	p.FirstResult.writeText(w, "time to first result")
+	p.FinalResult.writeText(w, "EOS to final result")
+	p.Jitter.writeText(w, "chunk send jitter")
+	fmt.Fprintf(w, "real-time factor:\n  mean %.3f  p50 %.3f  p90 %.3f  p99 %.3f  max %.3f\n",
+		p.RTF.Mean, p.RTF.P50, p.RTF.P90, p.RTF.P99, p.RTF.Max)
+}
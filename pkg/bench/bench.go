@@ -0,0 +1,201 @@
+package bench
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// Config of a benchmark run
+type Config struct {
+	// Target is an http(s):// url for http requests or a ws(s):// url for
+	// websocket sessions
+	Target string
+	// Concurrency is the number of workers issuing requests back to back, or
+	// the maximum number of requests in flight of an open-loop run
+	Concurrency int
+	// the run stops after Duration or after Requests requests, whichever comes first.
+	// 0 disables the bound
+	Duration time.Duration
+	Requests int64
+	// RampUp spreads the start of the workers evenly over this period
+	RampUp time.Duration
+	// Rates switches to an open-loop run issuing requests at each arrival rate
+	// in turn, in requests per second, for StepDuration each. StepDuration
+	// defaults to Duration split evenly between the rates
+	Rates        []float64
+	StepDuration time.Duration
+	// Timeout of one request
+	Timeout time.Duration
+
+	// Method of http requests, GET by default. A Payload needs a method with
+	// a body, e.g. POST
+	Method string
+	// Payload is the http request body or the websocket stream
+	Payload []byte
+	// ChunkSize is the size of websocket frames the payload is split into
+	ChunkSize int
+	// Pacing is the delay between two websocket frames
+	Pacing time.Duration
+
+	// Audio is streamed at its real bitrate instead of Payload when set,
+	// session lengths are drawn from Mix, the whole audio is sent if Mix is empty
+	Audio *Audio
+	Mix   SessionMix
+}
+
+// requestFunc performs one request, an http call or a websocket session.
+// Detailed metrics are recorded to the worker
+type requestFunc func(w *worker) error
+
+func newRequestFunc(cfg *Config) (requestFunc, error) {
+	u, err := url.Parse(cfg.Target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target: %v", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		if cfg.Method == "" {
+			cfg.Method = http.MethodGet
+		}
+		if len(cfg.Payload) > 0 && (cfg.Method == http.MethodGet || cfg.Method == http.MethodHead) {
+			return nil, fmt.Errorf("a payload can't be sent with method %s, set the method, e.g. POST", cfg.Method)
+		}
+		return httpRequest(cfg), nil
+	case "ws", "wss":
+		return wsRequest(cfg), nil
+	default:
+		return nil, fmt.Errorf("unsupported target scheme '%s'", u.Scheme)
+	}
+}
+
+// the histograms record microseconds, from 1us to 10min
+const (
+	histogramMin     = 1
+	histogramMax     = int64(10 * time.Minute / time.Microsecond)
+	histogramSigFigs = 3
+)
+
+func newHistogram() *hdrhistogram.Histogram {
+	return hdrhistogram.New(histogramMin, histogramMax, histogramSigFigs)
+}
+
+// worker results, merged into a Report once the run is over
+type worker struct {
+	latency  *hdrhistogram.Histogram
+	requests int64
+	errors   map[string]int64
+
+	rnd   *rand.Rand
+	audio *audioStats
+
+	// open-loop runs only
+	lag     *hdrhistogram.Histogram
+	service *hdrhistogram.Histogram
+	steps   []*stepStats
+}
+
+func (p *worker) record(start time.Time, err error) {
+	p.requests++
+	if err != nil {
+		p.errors[errorKey(err)]++
+		return
+	}
+
+	p.latency.RecordValue(time.Since(start).Microseconds())
+}
+
+// Run runs the benchmark described by cfg, closed-loop unless cfg.Rates is set
+func Run(cfg Config) (*Report, error) {
+	if cfg.Concurrency < 1 {
+		cfg.Concurrency = 1
+	}
+	if len(cfg.Rates) > 0 {
+		err := cfg.checkRates()
+		if err != nil {
+			return nil, err
+		}
+	} else if cfg.Duration <= 0 && cfg.Requests <= 0 {
+		return nil, fmt.Errorf("either duration or requests must be set")
+	}
+	if len(cfg.Mix) > 0 && cfg.Audio == nil {
+		return nil, fmt.Errorf("a session mix needs audio")
+	}
+
+	request, err := newRequestFunc(&cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	workers := make([]*worker, cfg.Concurrency)
+	for i := range workers {
+		workers[i] = &worker{
+			latency: newHistogram(),
+			errors:  make(map[string]int64),
+			rnd:     rand.New(rand.NewSource(start.UnixNano() + int64(i))),
+		}
+		if cfg.Audio != nil {
+			workers[i].audio = newAudioStats()
+		}
+	}
+
+	var scheduled, dropped []int64
+	if len(cfg.Rates) > 0 {
+		scheduled, dropped = runOpenLoop(&cfg, request, workers, start)
+	} else {
+		runClosedLoop(&cfg, request, workers, start)
+	}
+
+	report := newReport(cfg.Target, time.Since(start))
+	for _, w := range workers {
+		report.merge(w)
+	}
+	report.finish()
+	if len(cfg.Rates) > 0 {
+		report.finishSteps(&cfg, workers, scheduled, dropped)
+	}
+
+	return report, nil
+}
+
+// runClosedLoop runs the workers issuing requests back to back
+func runClosedLoop(cfg *Config, request requestFunc, workers []*worker, start time.Time) {
+	var deadline time.Time
+	if cfg.Duration > 0 {
+		deadline = start.Add(cfg.Duration)
+	}
+	var issued int64
+
+	var wg sync.WaitGroup
+	for i, w := range workers {
+		w := w
+		delay := cfg.RampUp * time.Duration(i) / time.Duration(len(workers))
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			time.Sleep(delay)
+
+			for {
+				if !deadline.IsZero() && time.Now().After(deadline) {
+					return
+				}
+				if cfg.Requests > 0 && atomic.AddInt64(&issued, 1) > cfg.Requests {
+					return
+				}
+
+				reqStart := time.Now()
+				w.record(reqStart, request(w))
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,319 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tinker/pkg/framework"
+
+	"github.com/gorilla/websocket"
+)
+
+func newTestWorker() *worker {
+	return &worker{
+		latency: newHistogram(),
+		errors:  make(map[string]int64),
+		rnd:     rand.New(rand.NewSource(1)),
+		audio:   newAudioStats(),
+	}
+}
+
+func TestHttpMethod(t *testing.T) {
+	// a GET only handler, like /httpcase
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		method  string
+		payload []byte
+		config  bool
+		errKey  string
+	}{
+		{name: "default get", config: true},
+		{name: "explicit get", method: http.MethodGet, config: true},
+		{name: "post", method: http.MethodPost, payload: []byte("{}"), config: true, errKey: "http 405"},
+		{name: "payload without method", payload: []byte("{}")},
+		{name: "payload with get", method: http.MethodGet, payload: []byte("{}")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Target: srv.URL, Method: tt.method, Payload: tt.payload, Timeout: time.Second}
+			request, err := newRequestFunc(cfg)
+			if !tt.config {
+				if err == nil {
+					t.Fatalf("expect a config error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newRequestFunc: %v", err)
+			}
+
+			err = request(newTestWorker())
+			if tt.errKey == "" && err != nil {
+				t.Fatalf("request: %v", err)
+			}
+			if tt.errKey != "" && (err == nil || errorKey(err) != tt.errKey) {
+				t.Fatalf("expect %s, got %v", tt.errKey, err)
+			}
+		})
+	}
+}
+
+// wsServer replies messages once it receives EOS then closes the session
+func wsServer(t *testing.T, messages ...string) string {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		conn, err := new(websocket.Upgrader).Upgrade(rw, req, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if bytes.Equal(data, framework.EOS) {
+				break
+			}
+		}
+		for _, msg := range messages {
+			conn.WriteMessage(websocket.TextMessage, []byte(msg))
+		}
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		conn.ReadMessage()
+	}))
+	t.Cleanup(srv.Close)
+
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func TestWsRequestSkipsNonResults(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []string
+		errKey   string
+	}{
+		{
+			name:     "result",
+			messages: []string{`{"type":"success","data":{}}`},
+		},
+		{
+			name: "backpressure and pong before result",
+			messages: []string{
+				`{"type":"backpressure","data":{"pause":true}}`,
+				`{"type":"pong"}`,
+				`{"type":"success","data":{}}`,
+			},
+		},
+		{
+			name: "error after backpressure",
+			messages: []string{
+				`{"type":"backpressure","data":{"pause":true}}`,
+				`{"type":"error","data":{"code":2413,"message":"too large"}}`,
+			},
+			errKey: "ws 2413",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Target: wsServer(t, tt.messages...), Payload: []byte("data"), Timeout: 5 * time.Second}
+			request, err := newRequestFunc(cfg)
+			if err != nil {
+				t.Fatalf("newRequestFunc: %v", err)
+			}
+
+			err = request(newTestWorker())
+			if tt.errKey == "" && err != nil {
+				t.Fatalf("request: %v", err)
+			}
+			if tt.errKey != "" && (err == nil || errorKey(err) != tt.errKey) {
+				t.Fatalf("expect %s, got %v", tt.errKey, err)
+			}
+		})
+	}
+}
+
+func TestAudioSessionResults(t *testing.T) {
+	audio := &Audio{Data: make([]byte, 3200), SampleRate: 8000, Channels: 1, BitsPerSample: 16}
+
+	tests := []struct {
+		name     string
+		messages []string
+		errKey   string
+	}{
+		{
+			name: "results among backpressure",
+			messages: []string{
+				`{"type":"backpressure","data":{"pause":true}}`,
+				`{"type":"success","data":{}}`,
+				`{"type":"backpressure","data":{"pause":false}}`,
+			},
+		},
+		{
+			name:     "no result",
+			messages: []string{`{"type":"backpressure","data":{"pause":true}}`, `{"type":"pong"}`},
+			errKey:   "no result",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Target: wsServer(t, tt.messages...), Audio: audio, Timeout: 5 * time.Second}
+			request, err := newRequestFunc(cfg)
+			if err != nil {
+				t.Fatalf("newRequestFunc: %v", err)
+			}
+
+			w := newTestWorker()
+			err = request(w)
+			if tt.errKey != "" {
+				if err == nil || errorKey(err) != tt.errKey {
+					t.Fatalf("expect %s, got %v", tt.errKey, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("request: %v", err)
+			}
+			if w.audio.firstResult.TotalCount() != 1 || w.audio.finalResult.TotalCount() != 1 {
+				t.Fatalf("expect one first and final result recorded")
+			}
+		})
+	}
+}
+
+func TestMixNeedsAudio(t *testing.T) {
+	mix, err := ParseMix("5s:1")
+	if err != nil {
+		t.Fatalf("ParseMix: %v", err)
+	}
+
+	_, err = Run(Config{Target: "ws://localhost:1/websocket", Requests: 1, Mix: mix})
+	if err == nil {
+		t.Fatalf("expect an error for a mix without audio")
+	}
+}
+
+// wav returns a PCM WAV file of 100 bytes of audio
+func wav(channels, sampleRate, bitsPerSample int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+100))
+	buf.WriteString("WAVEfmt ")
+	blockAlign := channels * bitsPerSample / 8
+	binary.Write(&buf, binary.LittleEndian, uint32(16))
+	binary.Write(&buf, binary.LittleEndian, []uint16{1, uint16(channels)})
+	binary.Write(&buf, binary.LittleEndian, []uint32{uint32(sampleRate), uint32(sampleRate * blockAlign)})
+	binary.Write(&buf, binary.LittleEndian, []uint16{uint16(blockAlign), uint16(bitsPerSample)})
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(100))
+	buf.Write(make([]byte, 100))
+
+	return buf.Bytes()
+}
+
+func TestLoadAudio(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		pcm   Audio
+		valid bool
+	}{
+		{name: "wav", data: wav(1, 8000, 16), valid: true},
+		{name: "wav without channels", data: wav(0, 8000, 16)},
+		{name: "wav with 12 bits samples", data: wav(1, 8000, 12)},
+		{name: "wav with 4 bits samples", data: wav(2, 8000, 4)},
+		{name: "pcm", data: make([]byte, 100), pcm: Audio{SampleRate: 8000, Channels: 1, BitsPerSample: 16}, valid: true},
+		{name: "pcm with 4 bits samples", data: make([]byte, 100), pcm: Audio{SampleRate: 8000, Channels: 1, BitsPerSample: 4}},
+		{name: "pcm without channels", data: make([]byte, 100), pcm: Audio{SampleRate: 8000, BitsPerSample: 16}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audio")
+			if err := ioutil.WriteFile(path, tt.data, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			audio, err := LoadAudio(path, tt.pcm)
+			if !tt.valid {
+				if err == nil {
+					t.Fatalf("expect an invalid format, got %+v", audio)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadAudio: %v", err)
+			}
+			if len(audio.Data) != 100 || audio.blockAlign() != 2 || len(audio.Clip(time.Second)) != 16000 {
+				t.Fatalf("unexpected audio %+v", audio)
+			}
+		})
+	}
+}
+
+func TestOpenLoopArrivals(t *testing.T) {
+	queued := maxQueuedArrivals
+	maxQueuedArrivals = 10
+	defer func() { maxQueuedArrivals = queued }()
+
+	// the only worker is stuck in its first request for the whole run
+	cfg := &Config{Rates: []float64{100}, StepDuration: 500 * time.Millisecond}
+	request := func(*worker) error {
+		time.Sleep(600 * time.Millisecond)
+		return nil
+	}
+	workers := []*worker{newTestWorker()}
+	scheduled, dropped := runOpenLoop(cfg, request, workers, time.Now())
+
+	// the scheduler keeps the arrival rate: 1 arrival in flight, 10 queued,
+	// the others dropped
+	if scheduled[0] != 50 {
+		t.Fatalf("expect 50 arrivals scheduled, got %d", scheduled[0])
+	}
+	if dropped[0] < 38 || dropped[0] > 39 {
+		t.Fatalf("expect 39 arrivals dropped, got %d", dropped[0])
+	}
+	step := newStepReport(100, cfg.StepDuration, scheduled[0], dropped[0], workers[0].steps[0])
+	if step.Requests != 1 || step.Missed != 49 || !step.Saturated {
+		t.Fatalf("expect 1 request and 49 missed, got %+v", step)
+	}
+}
+
+func TestOpenLoopLateArrivals(t *testing.T) {
+	// 20ms requests at 100 req/s on one worker fall behind the schedule
+	cfg := &Config{Rates: []float64{100}, StepDuration: 500 * time.Millisecond}
+	request := func(*worker) error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	}
+	workers := []*worker{newTestWorker()}
+	scheduled, dropped := runOpenLoop(cfg, request, workers, time.Now())
+
+	step := newStepReport(100, cfg.StepDuration, scheduled[0], dropped[0], workers[0].steps[0])
+	if step.Scheduled != 50 || step.Dropped != 0 {
+		t.Fatalf("expect 50 arrivals scheduled and none dropped, got %+v", step)
+	}
+	if step.Requests < 15 || step.Requests > 26 || step.Late == 0 {
+		t.Fatalf("expect about 25 requests, some late, got %+v", step)
+	}
+	// the lag of the queued arrivals is measured from their intended start
+	if lag := time.Duration(step.StartLag.Max * float64(time.Millisecond)); lag < 200*time.Millisecond {
+		t.Fatalf("expect the last requests about 250ms late, got %v", lag)
+	}
+}
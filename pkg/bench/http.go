@@ -0,0 +1,46 @@
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+func httpRequest(cfg *Config) requestFunc {
+	client := &http.Client{
+		Timeout: cfg.Timeout,
+		Transport: &http.Transport{
+			MaxIdleConnsPerHost: cfg.Concurrency,
+		},
+	}
+
+	return func(w *worker) error {
+		req, err := http.NewRequest(cfg.Method, cfg.Target, bytes.NewReader(cfg.Payload))
+		if err != nil {
+			return err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		// drain the body so that the connection is reused
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode >= http.StatusBadRequest {
+			return &benchError{
+				key: fmt.Sprintf("http %d", resp.StatusCode),
+				msg: fmt.Sprintf("unexpected status %s", resp.Status),
+			}
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,216 @@
+package bench
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// a step is saturated when less than saturationRatio of its scheduled requests
+// succeed, or when its requests start more than saturationLag late at the median
+const (
+	saturationRatio = 0.95
+	saturationLag   = 100 * time.Millisecond
+)
+
+// maxQueuedArrivals bounds the arrivals waiting for a free worker, the
+// scheduler drops the arrivals past it rather than wait
+var maxQueuedArrivals = 100000
+
+// job is a request scheduled by the open-loop scheduler
+type job struct {
+	step     int
+	intended time.Time
+}
+
+// stepStats are the worker results of one step of an open-loop run
+type stepStats struct {
+	requests int64
+	errors   int64
+	// late are the requests started more than saturationLag late
+	late    int64
+	latency *hdrhistogram.Histogram
+	lag     *hdrhistogram.Histogram
+}
+
+func newStepStats() *stepStats {
+	return &stepStats{
+		latency: newHistogram(),
+		lag:     newHistogram(),
+	}
+}
+
+func (p *stepStats) merge(o *stepStats) {
+	p.requests += o.requests
+	p.errors += o.errors
+	p.late += o.late
+	p.latency.Merge(o.latency)
+	p.lag.Merge(o.lag)
+}
+
+// recordJob records a request of an open-loop run. The latency is measured
+// from the intended start time so that the time spent waiting for a free
+// worker is not omitted, the service time from the actual start time
+func (p *worker) recordJob(j job, start time.Time, err error) {
+	step := p.steps[j.step]
+	p.requests++
+	step.requests++
+
+	lag := start.Sub(j.intended)
+	p.lag.RecordValue(lag.Microseconds())
+	step.lag.RecordValue(lag.Microseconds())
+	if lag > saturationLag {
+		step.late++
+	}
+
+	if err != nil {
+		p.errors[errorKey(err)]++
+		step.errors++
+		return
+	}
+
+	now := time.Now()
+	p.latency.RecordValue(now.Sub(j.intended).Microseconds())
+	p.service.RecordValue(now.Sub(start).Microseconds())
+	step.latency.RecordValue(now.Sub(j.intended).Microseconds())
+}
+
+func (cfg *Config) stepDuration() time.Duration {
+	if cfg.StepDuration > 0 {
+		return cfg.StepDuration
+	}
+
+	return cfg.Duration / time.Duration(len(cfg.Rates))
+}
+
+func (cfg *Config) checkRates() error {
+	for _, rate := range cfg.Rates {
+		if rate <= 0 {
+			return fmt.Errorf("invalid rate %v, must be positive", rate)
+		}
+	}
+	if cfg.stepDuration() <= 0 {
+		return fmt.Errorf("either duration or step duration must be set with rates")
+	}
+
+	return nil
+}
+
+// runOpenLoop issues requests at the arrival rates of cfg.Rates regardless of
+// the response times, one step per rate. The workers execute the requests,
+// a request scheduled while all of them are busy is queued for the next free
+// one so that the scheduler never waits. Arrivals past maxQueuedArrivals are
+// dropped, and the requests not started before the end of the run are
+// missed. The numbers of scheduled and dropped arrivals per step are returned
+func runOpenLoop(cfg *Config, request requestFunc, workers []*worker, start time.Time) (scheduled, dropped []int64) {
+	jobs := make(chan job, maxQueuedArrivals)
+	stepDuration := cfg.stepDuration()
+	end := start.Add(stepDuration * time.Duration(len(cfg.Rates)))
+
+	var wg sync.WaitGroup
+	for _, w := range workers {
+		w := w
+		w.lag = newHistogram()
+		w.service = newHistogram()
+		w.steps = make([]*stepStats, len(cfg.Rates))
+		for i := range w.steps {
+			w.steps[i] = newStepStats()
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				reqStart := time.Now()
+				if reqStart.After(end) {
+					continue
+				}
+				w.recordJob(j, reqStart, request(w))
+			}
+		}()
+	}
+
+	scheduled = make([]int64, len(cfg.Rates))
+	dropped = make([]int64, len(cfg.Rates))
+	var issued int64
+
+	// once the scheduler falls behind past the end of the run, the rest of
+	// the schedule is only counted
+	behind := false
+loop:
+	for i, rate := range cfg.Rates {
+		stepStart := start.Add(stepDuration * time.Duration(i))
+		stepEnd := stepStart.Add(stepDuration)
+		interval := time.Duration(float64(time.Second) / rate)
+
+		for n := 0; ; n++ {
+			intended := stepStart.Add(interval * time.Duration(n))
+			if !intended.Before(stepEnd) {
+				break
+			}
+			if cfg.Requests > 0 && issued >= cfg.Requests {
+				break loop
+			}
+			scheduled[i]++
+			issued++
+
+			if behind {
+				continue
+			}
+			time.Sleep(time.Until(intended))
+			if time.Now().After(end) {
+				behind = true
+				continue
+			}
+			select {
+			case jobs <- job{step: i, intended: intended}:
+			default:
+				dropped[i]++
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return scheduled, dropped
+}
+
+// StepReport is the result of one step of an open-loop run
+type StepReport struct {
+	Rate       float64       `json:"target_rps"`
+	Scheduled  int64         `json:"scheduled"`
+	Requests   int64         `json:"requests"`
+	Succeeded  int64         `json:"succeeded"`
+	Errors     int64         `json:"errors"`
+	Missed     int64         `json:"missed"`
+	Dropped    int64         `json:"dropped"`
+	Late       int64         `json:"late"`
+	Throughput float64       `json:"throughput_rps"`
+	Latency    LatencyReport `json:"latency"`
+	StartLag   LatencyReport `json:"start_lag"`
+	Saturated  bool          `json:"saturated"`
+}
+
+func newStepReport(rate float64, duration time.Duration, scheduled, dropped int64, stats *stepStats) StepReport {
+	ret := StepReport{
+		Rate:      rate,
+		Scheduled: scheduled,
+		Requests:  stats.requests,
+		Succeeded: stats.requests - stats.errors,
+		Errors:    stats.errors,
+		Missed:    scheduled - stats.requests,
+		Dropped:   dropped,
+		Late:      stats.late,
+		Latency:   newLatencyReport(stats.latency),
+		StartLag:  newLatencyReport(stats.lag),
+	}
+	ret.Throughput = float64(ret.Succeeded) / duration.Seconds()
+
+	lag := time.Duration(stats.lag.ValueAtQuantile(50)) * time.Microsecond
+	ret.Saturated = scheduled > 0 &&
+		(float64(ret.Succeeded) < saturationRatio*float64(scheduled) || lag > saturationLag)
+
+	return ret
+}
@@ -0,0 +1,218 @@
+package bench
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// LatencyReport summarizes a latency histogram, in milliseconds
+type LatencyReport struct {
+	Min  float64 `json:"min_ms"`
+	Mean float64 `json:"mean_ms"`
+	P50  float64 `json:"p50_ms"`
+	P90  float64 `json:"p90_ms"`
+	P99  float64 `json:"p99_ms"`
+	P999 float64 `json:"p999_ms"`
+	Max  float64 `json:"max_ms"`
+}
+
+func newLatencyReport(h *hdrhistogram.Histogram) LatencyReport {
+	ms := func(us int64) float64 {
+		return float64(us) / 1000
+	}
+
+	return LatencyReport{
+		Min:  ms(h.Min()),
+		Mean: h.Mean() / 1000,
+		P50:  ms(h.ValueAtQuantile(50)),
+		P90:  ms(h.ValueAtQuantile(90)),
+		P99:  ms(h.ValueAtQuantile(99)),
+		P999: ms(h.ValueAtQuantile(99.9)),
+		Max:  ms(h.Max()),
+	}
+}
+
+func (p LatencyReport) writeText(w io.Writer, name string) {
+	fmt.Fprintf(w, "%s (ms):\n", name)
+	fmt.Fprintf(w, "  min %.2f  mean %.2f  max %.2f\n", p.Min, p.Mean, p.Max)
+	fmt.Fprintf(w, "  p50 %.2f  p90 %.2f  p99 %.2f  p99.9 %.2f\n", p.P50, p.P90, p.P99, p.P999)
+}
+
+// Report is the result of a benchmark run
+type Report struct {
+	Target     string  `json:"target"`
+	Elapsed    float64 `json:"elapsed_s"`
+	Requests   int64   `json:"requests"`
+	Succeeded  int64   `json:"succeeded"`
+	Errors     int64   `json:"errors"`
+	Throughput float64 `json:"throughput_rps"`
+	// Latency of an open-loop run is measured from the intended start time
+	Latency    LatencyReport    `json:"latency"`
+	ErrorKinds map[string]int64 `json:"error_breakdown,omitempty"`
+	Audio      *AudioReport     `json:"audio,omitempty"`
+
+	// open-loop runs only: the latency measured from the actual start time,
+	// the delay between intended and actual start times, the result of each
+	// step and the rate of the first saturated step
+	ServiceTime *LatencyReport `json:"service_time,omitempty"`
+	StartLag    *LatencyReport `json:"start_lag,omitempty"`
+	Steps       []StepReport   `json:"steps,omitempty"`
+	Saturation  float64        `json:"saturation_rps,omitempty"`
+
+	elapsed time.Duration
+	latency *hdrhistogram.Histogram
+	audio   *audioStats
+}
+
+func newReport(target string, elapsed time.Duration) *Report {
+	return &Report{
+		Target:     target,
+		ErrorKinds: make(map[string]int64),
+		elapsed:    elapsed,
+		latency:    newHistogram(),
+	}
+}
+
+func (p *Report) merge(w *worker) {
+	p.Requests += w.requests
+	p.latency.Merge(w.latency)
+	for k, n := range w.errors {
+		p.ErrorKinds[k] += n
+		p.Errors += n
+	}
+
+	if w.audio != nil {
+		if p.audio == nil {
+			p.audio = newAudioStats()
+		}
+		p.audio.merge(w.audio)
+	}
+}
+
+func (p *Report) finish() {
+	p.Succeeded = p.Requests - p.Errors
+	p.Elapsed = p.elapsed.Seconds()
+	if p.Elapsed > 0 {
+		p.Throughput = float64(p.Succeeded) / p.Elapsed
+	}
+	p.Latency = newLatencyReport(p.latency)
+	if p.audio != nil {
+		p.Audio = p.audio.report()
+	}
+}
+
+func (p *Report) finishSteps(cfg *Config, workers []*worker, scheduled, dropped []int64) {
+	service, lag := newHistogram(), newHistogram()
+	steps := make([]*stepStats, len(cfg.Rates))
+	for i := range steps {
+		steps[i] = newStepStats()
+	}
+	for _, w := range workers {
+		service.Merge(w.service)
+		lag.Merge(w.lag)
+		for i, step := range w.steps {
+			steps[i].merge(step)
+		}
+	}
+
+	serviceTime, startLag := newLatencyReport(service), newLatencyReport(lag)
+	p.ServiceTime, p.StartLag = &serviceTime, &startLag
+
+	for i, rate := range cfg.Rates {
+		step := newStepReport(rate, cfg.stepDuration(), scheduled[i], dropped[i], steps[i])
+		if step.Saturated && p.Saturation == 0 {
+			p.Saturation = rate
+		}
+		p.Steps = append(p.Steps, step)
+	}
+}
+
+// WriteText writes the human readable report
+func (p *Report) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "target:     %s\n", p.Target)
+	fmt.Fprintf(w, "elapsed:    %s\n", p.elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "requests:   %d (%d succeeded, %d failed)\n", p.Requests, p.Succeeded, p.Errors)
+	fmt.Fprintf(w, "throughput: %.2f req/s\n", p.Throughput)
+	p.Latency.writeText(w, "latency")
+	if p.ServiceTime != nil {
+		p.ServiceTime.writeText(w, "service time")
+		p.StartLag.writeText(w, "start lag")
+	}
+	if len(p.Steps) > 0 {
+		p.writeSteps(w)
+	}
+	if p.Audio != nil {
+		p.Audio.writeText(w)
+	}
+
+	if len(p.ErrorKinds) > 0 {
+		fmt.Fprintln(w, "errors:")
+		keys := make([]string, 0, len(p.ErrorKinds))
+		for k := range p.ErrorKinds {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "  %6d  %s\n", p.ErrorKinds[k], k)
+		}
+	}
+}
+
+func (p *Report) writeSteps(w io.Writer) {
+	fmt.Fprintln(w, "steps:")
+	fmt.Fprintf(w, "  %10s %10s %10s %10s %10s %10s %10s %10s %10s\n", "target/s", "actual/s", "scheduled", "failed", "missed", "dropped", "late", "p99 ms", "lag p50 ms")
+	for _, step := range p.Steps {
+		mark := ""
+		if step.Saturated {
+			mark = "  saturated"
+		}
+		fmt.Fprintf(w, "  %10.1f %10.1f %10d %10d %10d %10d %10d %10.2f %10.2f%s\n",
+			step.Rate, step.Throughput, step.Scheduled, step.Errors, step.Missed, step.Dropped, step.Late, step.Latency.P99, step.StartLag.P50, mark)
+	}
+
+	if p.Saturation > 0 {
+		fmt.Fprintf(w, "saturation: %.1f req/s\n", p.Saturation)
+	} else {
+		fmt.Fprintln(w, "saturation: not reached")
+	}
+}
+
+// WriteJSON writes the report as JSON
+func (p *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(p)
+}
+
+// benchError is an error with a low cardinality key for the error breakdown
+type benchError struct {
+	key string
+	msg string
+}
+
+func (p *benchError) Error() string {
+	return p.msg
+}
+
+func errorKey(err error) string {
+	if e, ok := err.(*benchError); ok {
+		return e.key
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return opErr.Op + " error"
+	}
+
+	return err.Error()
+}
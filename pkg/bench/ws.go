@@ -0,0 +1,225 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"tinker/pkg/framework"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsRequest streams the payload in one websocket session and waits for the result
+func wsRequest(cfg *Config) requestFunc {
+	dialer := &websocket.Dialer{
+		HandshakeTimeout: cfg.Timeout,
+	}
+	chunkSize := cfg.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = len(cfg.Payload)
+	}
+
+	return func(w *worker) error {
+		if cfg.Audio != nil {
+			return audioSession(cfg, dialer, w)
+		}
+
+		conn, _, err := dialer.Dial(cfg.Target, nil)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		if cfg.Timeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(cfg.Timeout))
+		}
+
+		for offset := 0; offset < len(cfg.Payload); offset += chunkSize {
+			end := offset + chunkSize
+			if end > len(cfg.Payload) {
+				end = len(cfg.Payload)
+			}
+
+			err = conn.WriteMessage(websocket.BinaryMessage, cfg.Payload[offset:end])
+			if err != nil {
+				return err
+			}
+
+			if cfg.Pacing > 0 {
+				time.Sleep(cfg.Pacing)
+			}
+		}
+
+		err = conn.WriteMessage(websocket.BinaryMessage, framework.EOS)
+		if err != nil {
+			return err
+		}
+
+		// skip the backpressure and pong messages up to the result
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return err
+			}
+
+			result, err := checkWsResponse(msg)
+			if err != nil {
+				return err
+			}
+			if result {
+				break
+			}
+		}
+
+		// answer the server's close frame so that both sides release the connection
+		for {
+			if _, _, err = conn.NextReader(); err != nil {
+				break
+			}
+		}
+
+		return nil
+	}
+}
+
+type wsMessage struct {
+	at   time.Time
+	data []byte
+}
+
+// audioSession streams audio at its real bitrate and records the audio metrics.
+// Results received before EOS are partial results, the last result received
+// before the server closes the session is the final one. Backpressure and pong
+// messages are not results
+func audioSession(cfg *Config, dialer *websocket.Dialer, w *worker) error {
+	audio := cfg.Audio
+	data := audio.Clip(cfg.Mix.pick(w.rnd))
+	chunkSize := cfg.ChunkSize
+	if chunkSize <= 0 {
+		// 100ms chunks
+		chunkSize = audio.ByteRate() / 10
+	}
+	chunkSize -= chunkSize % audio.blockAlign()
+	if chunkSize <= 0 {
+		chunkSize = audio.blockAlign()
+	}
+	chunkDuration := audio.bytesDuration(chunkSize)
+
+	conn, _, err := dialer.Dial(cfg.Target, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var messages []wsMessage
+	done := make(chan error, 1)
+	go func() {
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+					err = nil
+				}
+				done <- err
+				return
+			}
+			messages = append(messages, wsMessage{at: time.Now(), data: msg})
+		}
+	}()
+
+	start := time.Now()
+	for i := 0; i*chunkSize < len(data); i++ {
+		scheduled := start.Add(time.Duration(i) * chunkDuration)
+		time.Sleep(time.Until(scheduled))
+		w.audio.jitter.RecordValue(time.Since(scheduled).Microseconds())
+
+		end := (i + 1) * chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		err = conn.WriteMessage(websocket.BinaryMessage, data[i*chunkSize:end])
+		if err != nil {
+			return err
+		}
+	}
+
+	eos := time.Now()
+	err = conn.WriteMessage(websocket.BinaryMessage, framework.EOS)
+	if err != nil {
+		return err
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = time.Hour
+	}
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		err = &benchError{key: "timeout", msg: "timeout waiting for the final result"}
+	}
+	if err != nil {
+		return err
+	}
+
+	var results []time.Time
+	for _, msg := range messages {
+		result, err := checkWsResponse(msg.data)
+		if err != nil {
+			return err
+		}
+		if result {
+			results = append(results, msg.at)
+		}
+	}
+	if len(results) == 0 {
+		return &benchError{key: "no result", msg: "session closed without result"}
+	}
+
+	first, final := results[0], results[len(results)-1]
+	w.audio.firstResult.RecordValue(first.Sub(start).Microseconds())
+	if final.After(eos) {
+		w.audio.finalResult.RecordValue(final.Sub(eos).Microseconds())
+	} else {
+		w.audio.finalResult.RecordValue(0)
+	}
+
+	length := audio.bytesDuration(len(data))
+	w.audio.audio += length
+	w.audio.rtf.RecordValue(int64(final.Sub(start).Seconds() / length.Seconds() * 1000))
+
+	return nil
+}
+
+// checkWsResponse turns an error WsResponse into an error and tells whether
+// msg is a result, backpressure and pong messages are not
+func checkWsResponse(msg []byte) (bool, error) {
+	var resp struct {
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}
+	err := json.Unmarshal(msg, &resp)
+	if err != nil {
+		return false, &benchError{key: "invalid response", msg: err.Error()}
+	}
+
+	switch resp.Type {
+	case framework.TypeBackpressure, framework.TypePong:
+		return false, nil
+	case framework.TypeError:
+	default:
+		return true, nil
+	}
+
+	var wsErr framework.WsError
+	err = json.Unmarshal(resp.Data, &wsErr)
+	if err != nil {
+		return false, &benchError{key: "invalid response", msg: err.Error()}
+	}
+
+	return false, &benchError{
+		key: fmt.Sprintf("ws %d", wsErr.Code),
+		msg: wsErr.Message,
+	}
+}
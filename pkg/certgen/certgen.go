@@ -0,0 +1,128 @@
+package certgen
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Options of Generate
+type Options struct {
+	// Dir receives the PEM files
+	Dir string
+	// Hosts are the DNS names and IPs of the server certificate
+	Hosts []string
+	// ClientName is the common name of the client certificate
+	ClientName string
+	Validity   time.Duration
+}
+
+// Files written by Generate in Options.Dir
+const (
+	CAFile        = "ca.pem"
+	CAKeyFile     = "ca-key.pem"
+	ServerFile    = "server.pem"
+	ServerKeyFile = "server-key.pem"
+	ClientFile    = "client.pem"
+	ClientKeyFile = "client-key.pem"
+)
+
+// Generate writes a CA, and a server and a client certificate signed by it,
+// to test TLS and mTLS locally. They are not meant for production
+func Generate(opts Options) error {
+	err := os.MkdirAll(opts.Dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	ca := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "tinker test CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(opts.Validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caKey, err := issue(opts.Dir, CAFile, CAKeyFile, ca, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	server := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "tinker"},
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(opts.Validity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range opts.Hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			server.IPAddresses = append(server.IPAddresses, ip)
+		} else {
+			server.DNSNames = append(server.DNSNames, host)
+		}
+	}
+	_, err = issue(opts.Dir, ServerFile, ServerKeyFile, server, ca, caKey)
+	if err != nil {
+		return err
+	}
+
+	client := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: opts.ClientName},
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(opts.Validity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	_, err = issue(opts.Dir, ClientFile, ClientKeyFile, client, ca, caKey)
+
+	return err
+}
+
+// issue generates the key of template and writes it with its certificate
+// signed by parent, or self signed if parent is nil
+func issue(dir, certFile, keyFile string, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template.SerialNumber, err = rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, fmt.Errorf("fail to create certificate %s: %v", certFile, err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, certFile), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, keyFile), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
@@ -0,0 +1,191 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"tinker/pkg/framework"
+)
+
+// Config is the configuration file of tinker, in JSON:
+//
+//	{
+//	  "tls": {"cert_file": "server.pem", "key_file": "server-key.pem", "ca_file": "ca.pem", "client_auth": true},
+//	  "h2c": false,
+//	  "backends": {
+//	    "backend.internal:8686": {"ca_file": "ca.pem", "cert_file": "client.pem", "key_file": "client-key.pem"}
+//	  },
+//	  "upstreams": {
+//	    "*": ["backend.internal:8686"],
+//	    "hello.Greeting": ["10.0.0.1:8686", "10.0.0.2:8686"]
+//	  },
+//	  "routes": [
+//	    {"method": "GET", "pattern": "/httpcase", "handler": "httpcase", "timeout": "5s", "rate_limit": {"rps": 100, "burst": 20}}
+//	  ]
+//	}
+//
+// Upstreams and routes are reloaded without restart, see api.Options.ConfigFile.
+// TLS and h2c apply to the listeners when they start
+type Config struct {
+	// TLS of the http and grpc listeners
+	TLS framework.TLSConfig `json:"tls"`
+	// H2C serves HTTP/2 without TLS
+	H2C bool `json:"h2c"`
+	// Backends are the TLS settings of grpc backends by target, the others
+	// are dialed insecure
+	Backends map[string]framework.TLSConfig `json:"backends"`
+
+	// Upstreams are the backend target lists by name. The grpc proxy and the
+	// grpc-web bridge send a service to the upstream named after it, to "*"
+	// otherwise
+	Upstreams map[string][]string `json:"upstreams"`
+	// Routes of the http listener, DefaultRoutes when empty
+	Routes []Route `json:"routes"`
+}
+
+// Route configures a route of the http listener. The calls of the grpc
+// listener are not routed, they have no timeout nor rate limit but their
+// deadline
+type Route struct {
+	Method  string `json:"method"`
+	Pattern string `json:"pattern"`
+	// Handler names the handler of the route, see api.Handlers
+	Handler string `json:"handler"`
+	// Upstream names the targets of the handler, "*" when empty
+	Upstream string `json:"upstream"`
+	// Timeout bounds the sessions, e.g. "5s", unbounded when 0
+	Timeout Duration `json:"timeout"`
+	// RateLimit of the sessions of the route, unlimited when 0
+	RateLimit framework.RateLimit `json:"rate_limit"`
+}
+
+// Duration is a time.Duration in JSON as a string, e.g. "1m30s"
+type Duration time.Duration
+
+func (p Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(p).String())
+}
+
+func (p *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %s, expect a string like \"5s\"", data)
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*p = Duration(d)
+
+	return nil
+}
+
+// DefaultUpstreams are the upstreams of a config without any
+var DefaultUpstreams = map[string][]string{
+	"*":         {"127.0.0.1:8686"},
+	"httpcase":  {"127.0.0.1:8686", "127.0.0.1:8686"},
+	"websocket": {"127.0.0.1:8686", "127.0.0.1:8686", "127.0.0.1:8686", "127.0.0.1:8686", "127.0.0.1:8686"},
+}
+
+// DefaultRoutes are the routes of a config without any
+var DefaultRoutes = []Route{
+	{Method: http.MethodGet, Pattern: "/httpcase", Handler: "httpcase", Upstream: "httpcase"},
+	{Method: http.MethodGet, Pattern: "/websocket", Handler: "websocket", Upstream: "websocket"},
+	{Method: http.MethodGet, Pattern: "/websocket/fanout", Handler: "websocket_fanout", Upstream: "websocket"},
+	{Method: http.MethodGet, Pattern: "/websocket/fanin", Handler: "websocket_fanin", Upstream: "websocket"},
+	{Method: http.MethodGet, Pattern: "/v1/greet/{person}", Handler: "greet", Upstream: "httpcase"},
+	// grpc-web and Connect clients, e.g. POST /hello.Greeting/Greet
+	{Method: http.MethodPost, Pattern: "/{service}/{method}", Handler: "grpcweb"},
+}
+
+// Default returns the config of tinker without config file
+func Default() *Config {
+	ret := new(Config)
+	ret.setDefaults()
+
+	return ret
+}
+
+func (p *Config) setDefaults() {
+	if len(p.Upstreams) == 0 {
+		p.Upstreams = DefaultUpstreams
+	}
+	if len(p.Routes) == 0 {
+		p.Routes = append([]Route(nil), DefaultRoutes...)
+	}
+	for i := range p.Routes {
+		if p.Routes[i].Upstream == "" {
+			p.Routes[i].Upstream = "*"
+		}
+	}
+}
+
+// Load reads and validates the configuration file at path
+func Load(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ret := new(Config)
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(ret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", path, err)
+	}
+	ret.setDefaults()
+
+	err = ret.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", path, err)
+	}
+
+	return ret, nil
+}
+
+// Validate checks that the certificates of the config load, and that the
+// routes are consistent with the upstreams
+func (p *Config) Validate() error {
+	if p.TLS.CertFile != "" || p.TLS.KeyFile != "" || p.TLS.CAFile != "" {
+		if !p.TLS.Enabled() {
+			return fmt.Errorf("tls needs both cert_file and key_file")
+		}
+		if _, err := p.TLS.ServerConfig(); err != nil {
+			return fmt.Errorf("tls: %v", err)
+		}
+	}
+
+	for target, cfg := range p.Backends {
+		if _, err := cfg.ClientConfig(); err != nil {
+			return fmt.Errorf("backend '%s': %v", target, err)
+		}
+	}
+
+	for name, targets := range p.Upstreams {
+		if len(targets) == 0 {
+			return fmt.Errorf("upstream '%s' has no target", name)
+		}
+	}
+
+	for _, route := range p.Routes {
+		if route.Method == "" || !strings.HasPrefix(route.Pattern, "/") {
+			return fmt.Errorf("route '%s %s' needs a method and a pattern beginning with '/'", route.Method, route.Pattern)
+		}
+		if _, ok := p.Upstreams[route.Upstream]; !ok {
+			return fmt.Errorf("route '%s %s': unknown upstream '%s'", route.Method, route.Pattern, route.Upstream)
+		}
+		if route.Timeout < 0 || route.RateLimit.RPS < 0 || route.RateLimit.Burst < 0 {
+			return fmt.Errorf("route '%s %s': negative timeout or rate limit", route.Method, route.Pattern)
+		}
+	}
+
+	return nil
+}
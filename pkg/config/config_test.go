@@ -0,0 +1,86 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes data to a config file and returns its path
+func writeConfig(t *testing.T, data string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatalf("fail to write config: %v", err)
+	}
+
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	cfg, err := Load(writeConfig(t, `{
+		"upstreams": {"*": ["127.0.0.1:8686"], "hello": ["10.0.0.1:8686"]},
+		"routes": [
+			{"method": "GET", "pattern": "/httpcase", "handler": "httpcase", "upstream": "hello", "timeout": "5s", "rate_limit": {"rps": 10, "burst": 2}},
+			{"method": "GET", "pattern": "/websocket", "handler": "websocket"}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if len(cfg.Routes) != 2 || time.Duration(cfg.Routes[0].Timeout) != 5*time.Second || cfg.Routes[0].RateLimit.RPS != 10 {
+		t.Fatalf("unexpected routes %+v", cfg.Routes)
+	}
+	if cfg.Routes[1].Upstream != "*" {
+		t.Fatalf("expect upstream * by default, got %q", cfg.Routes[1].Upstream)
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := Load(writeConfig(t, `{}`))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(cfg.Routes) != len(DefaultRoutes) || len(cfg.Upstreams) != len(DefaultUpstreams) {
+		t.Fatalf("expect the default routes and upstreams, got %+v", cfg)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{"bad json", `{"routes": [`, "unexpected EOF"},
+		{"unknown field", `{"route": []}`, "unknown field"},
+		{"bad duration", `{"routes": [{"method": "GET", "pattern": "/a", "timeout": 5}]}`, "invalid duration"},
+		{"unknown upstream", `{"routes": [{"method": "GET", "pattern": "/a", "upstream": "nope"}]}`, "unknown upstream 'nope'"},
+		{"empty upstream", `{"upstreams": {"*": []}}`, "has no target"},
+		{"no pattern", `{"routes": [{"method": "GET", "pattern": "a"}]}`, "needs a method and a pattern"},
+		{"negative timeout", `{"routes": [{"method": "GET", "pattern": "/a", "timeout": "-1s"}]}`, "negative timeout or rate limit"},
+		{"negative rps", `{"routes": [{"method": "GET", "pattern": "/a", "rate_limit": {"rps": -1}}]}`, "negative timeout or rate limit"},
+		{"negative burst", `{"routes": [{"method": "GET", "pattern": "/a", "rate_limit": {"burst": -1}}]}`, "negative timeout or rate limit"},
+		{"tls without key", `{"tls": {"cert_file": "server.pem"}}`, "needs both cert_file and key_file"},
+		{"missing backend CA", `{"backends": {"a:443": {"ca_file": "missing.pem"}}}`, "backend 'a:443'"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, test.config))
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expect error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestLoadMissing(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Fatalf("expect a missing file to fail")
+	}
+}
@@ -1,7 +1,14 @@
 package framework
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
 	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // Action is the basic unit of framework
@@ -11,6 +18,8 @@ type Action func(*Session) error
 // Parallel returns an aggragate Action executing given Actions in parallel
 func Parallel(actions ...Action) Action {
 	return func(sess *Session) error {
+		// the branches share the session
+		sess.initKeys()
 		l := len(actions)
 		var g errgroup.Group
 		c := make(chan error, l)
@@ -50,6 +59,244 @@ func Seq(actions ...Action) Action {
 	}
 }
 
+// Predicate is a condition on a Session
+type Predicate func(*Session) bool
+
+// If returns an Action executing then if pred holds, otherwise the otherwise
+// Action which may be nil
+func If(pred Predicate, then, otherwise Action) Action {
+	return func(sess *Session) error {
+		if pred(sess) {
+			return then(sess)
+		}
+		if otherwise != nil {
+			return otherwise(sess)
+		}
+
+		return nil
+	}
+}
+
+// Switch returns an Action executing the case named by selector, e.g. a path
+// parameter, or def which may be nil when no case matches
+func Switch(selector func(*Session) string, cases map[string]Action, def Action) Action {
+	return func(sess *Session) error {
+		value := selector(sess)
+		if action, ok := cases[value]; ok {
+			return action(sess)
+		}
+		if def != nil {
+			return def(sess)
+		}
+
+		return fmt.Errorf("Switch: no case for '%s'", value)
+	}
+}
+
+// RetryPolicy configures Retry
+type RetryPolicy struct {
+	// MaxAttempts including the first one
+	MaxAttempts int
+	// Backoff is the delay before the first retry, multiplied by Multiplier
+	// before each next retry up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Multiplier float64
+	// Retryable tells whether an error is retried, every error when nil
+	Retryable func(error) bool
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	Backoff:     100 * time.Millisecond,
+	MaxBackoff:  2 * time.Second,
+	Multiplier:  2,
+}
+
+// RetryGrpcCodes returns a RetryPolicy.Retryable retrying grpc errors with codes
+func RetryGrpcCodes(retryable ...codes.Code) func(error) bool {
+	return func(err error) bool {
+		code := status.Code(err)
+		for _, c := range retryable {
+			if code == c {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// Retry returns an Action executing action until it succeeds, fails with an
+// error which is not retryable or policy.MaxAttempts are done.
+// The action must be safe to execute again, e.g. it must not have streamed
+// data to the client
+func Retry(action Action, policy RetryPolicy) Action {
+	return func(sess *Session) error {
+		backoff := policy.Backoff
+		var err error
+		for attempt := 1; ; attempt++ {
+			err = action(sess)
+			if err == nil || attempt >= policy.MaxAttempts {
+				return err
+			}
+			if policy.Retryable != nil && !policy.Retryable(err) {
+				return err
+			}
+
+			sess.Warningf("Retry: attempt %d failed, retry in %s: %v", attempt, backoff, err)
+			select {
+			case <-time.After(backoff):
+			case <-sess.Ctx.Done():
+				return err
+			}
+
+			if policy.Multiplier > 0 {
+				backoff = time.Duration(float64(backoff) * policy.Multiplier)
+			}
+			if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+				backoff = policy.MaxBackoff
+			}
+		}
+	}
+}
+
+// ErrActionTimeout is returned by the Actions of Timeout exceeding their deadline
+var ErrActionTimeout = errors.New("action timeout")
+
+// Timeout returns an Action executing action with sess.Ctx bounded by d.
+// The action runs on a copy of the session holding the bounded context, so
+// that the session isn't changed under the other branches of a Parallel; the
+// copy shares the keys and the websocket state of the session. The fields
+// the action sets on the copy, e.g. the connections of WithGrpc, are copied
+// back to the session once it returns, but the contexts it derives end with d.
+// The action must observe sess.Ctx, e.g. pass it to its grpc calls, to be
+// interrupted: an action ignoring it isn't bounded and Timeout waits for it.
+// It fails with ErrActionTimeout once the deadline is exceeded
+func Timeout(action Action, d time.Duration) Action {
+	return func(sess *Session) error {
+		ctx, cancel := context.WithTimeout(sess.Ctx, d)
+		defer cancel()
+
+		branch := sess.branch(ctx)
+		defer sess.merge(branch)
+		err := action(branch)
+		if err != nil && ctx.Err() == context.DeadlineExceeded && sess.Ctx.Err() == nil {
+			sess.Errorf("Timeout: action exceeds %s: %v", d, err)
+			return ErrActionTimeout
+		}
+
+		return err
+	}
+}
+
+// WithTimeout returns a Wrapper bounding the session by d, see Timeout.
+// A session exceeding it fails with HttpErrorTimeout or WsErrorTimeout.
+// Inside WithWebsocket, the reads of the websocket are interrupted once d is
+// exceeded, so that a session waiting for the client ends too
+func WithTimeout(d time.Duration) Wrapper {
+	return func(sess *Session, action Action) error {
+		err := Timeout(func(sess *Session) error {
+			if sess.WsConn != nil {
+				defer interruptReads(sess)()
+			}
+			return action(sess)
+		}, d)(sess)
+		if err == ErrActionTimeout {
+			if sess.WsConn != nil {
+				return WsErrorTimeout
+			}
+			return HttpErrorTimeout
+		}
+
+		return err
+	}
+}
+
+// interruptReads sets the read deadline of the websocket once the deadline of
+// sess.Ctx is exceeded, which fails the pending and next reads. The returned
+// func stops it
+func interruptReads(sess *Session) func() {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-sess.Ctx.Done():
+			if sess.Ctx.Err() == context.DeadlineExceeded {
+				sess.WsConn.SetReadDeadline(time.Now())
+			}
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-exited
+	}
+}
+
+// Fallback returns an Action executing secondary when primary fails
+func Fallback(primary, secondary Action) Action {
+	return func(sess *Session) error {
+		err := primary(sess)
+		if err == nil {
+			return nil
+		}
+
+		sess.Warningf("Fallback: primary action failed, fall back: %v", err)
+		return secondary(sess)
+	}
+}
+
+// ErrBreak ends the Loop or While executing the action returning it,
+// the loop then succeeds
+var ErrBreak = errors.New("break")
+
+// Loop returns an Action executing action until it returns ErrBreak or
+// another error, e.g. the turns of a websocket dialog
+func Loop(action Action) Action {
+	return While(func(*Session) bool { return true }, action)
+}
+
+// While returns an Action executing action as long as pred holds, until it
+// returns ErrBreak or another error, or sess.Ctx is done
+func While(pred Predicate, action Action) Action {
+	return func(sess *Session) error {
+		for pred(sess) {
+			err := sess.Ctx.Err()
+			if err != nil {
+				return err
+			}
+
+			err = action(sess)
+			if err == ErrBreak {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// Finally returns an Action executing cleanup after action, even if it fails
+// or panics. The error of action takes precedence over the one of cleanup
+func Finally(action, cleanup Action) Action {
+	return func(sess *Session) (err error) {
+		defer func() {
+			cerr := cleanup(sess)
+			if err == nil {
+				err = cerr
+			}
+		}()
+
+		return action(sess)
+	}
+}
+
 // Wrapper adds customs behavior around an Action
 // Wrapper is usually used to allocate and release resource for action
 // e.g. create & close websocket connection
@@ -0,0 +1,437 @@
+package framework
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errTest = errors.New("test error")
+
+func newTestSession() *Session {
+	return &Session{Name: "test", Ctx: context.Background()}
+}
+
+// mark returns an Action setting the "ran" key to name
+func mark(name string) Action {
+	return func(sess *Session) error {
+		sess.Set("ran", name)
+		return nil
+	}
+}
+
+func ran(sess *Session) string {
+	v, _ := sess.Get("ran")
+	name, _ := v.(string)
+	return name
+}
+
+func TestIf(t *testing.T) {
+	tests := []struct {
+		name      string
+		pred      bool
+		otherwise Action
+		expect    string
+	}{
+		{"then", true, mark("otherwise"), "then"},
+		{"otherwise", false, mark("otherwise"), "otherwise"},
+		{"no otherwise", false, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess := newTestSession()
+			pred := func(*Session) bool { return tt.pred }
+			err := If(pred, mark("then"), tt.otherwise)(sess)
+			if err != nil || ran(sess) != tt.expect {
+				t.Fatalf("expect %q, got %q, %v", tt.expect, ran(sess), err)
+			}
+		})
+	}
+}
+
+func TestSwitch(t *testing.T) {
+	cases := map[string]Action{"a": mark("a"), "b": mark("b")}
+	tests := []struct {
+		value  string
+		def    Action
+		expect string
+		err    bool
+	}{
+		{value: "a", expect: "a"},
+		{value: "b", def: mark("def"), expect: "b"},
+		{value: "c", def: mark("def"), expect: "def"},
+		{value: "c", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			sess := newTestSession()
+			selector := func(*Session) string { return tt.value }
+			err := Switch(selector, cases, tt.def)(sess)
+			if (err != nil) != tt.err || ran(sess) != tt.expect {
+				t.Fatalf("expect %q, got %q, %v", tt.expect, ran(sess), err)
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts: 4,
+		Backoff:     10 * time.Millisecond,
+		MaxBackoff:  25 * time.Millisecond,
+		Multiplier:  2,
+	}
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	invalid := status.Error(codes.InvalidArgument, "invalid")
+
+	tests := []struct {
+		name      string
+		failures  int
+		err       error
+		retryable func(error) bool
+		attempts  int
+		backoffs  []time.Duration
+		fail      bool
+	}{
+		{name: "success", failures: 0, err: errTest, attempts: 1},
+		{name: "success after retries", failures: 2, err: errTest, attempts: 3,
+			backoffs: []time.Duration{10 * time.Millisecond, 20 * time.Millisecond}},
+		{name: "max attempts", failures: 10, err: errTest, attempts: 4, fail: true,
+			backoffs: []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 25 * time.Millisecond}},
+		{name: "retryable", failures: 1, err: unavailable, retryable: RetryGrpcCodes(codes.Unavailable), attempts: 2,
+			backoffs: []time.Duration{10 * time.Millisecond}},
+		{name: "not retryable", failures: 1, err: invalid, retryable: RetryGrpcCodes(codes.Unavailable), attempts: 1, fail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts []time.Time
+			action := func(sess *Session) error {
+				attempts = append(attempts, time.Now())
+				if len(attempts) <= tt.failures {
+					return tt.err
+				}
+				return nil
+			}
+
+			policy := policy
+			policy.Retryable = tt.retryable
+			err := Retry(action, policy)(newTestSession())
+			if (err != nil) != tt.fail {
+				t.Fatalf("expect failure %v, got %v", tt.fail, err)
+			}
+			if len(attempts) != tt.attempts {
+				t.Fatalf("expect %d attempts, got %d", tt.attempts, len(attempts))
+			}
+			for i, backoff := range tt.backoffs {
+				if gap := attempts[i+1].Sub(attempts[i]); gap < backoff {
+					t.Fatalf("expect backoff %s before attempt %d, got %s", backoff, i+2, gap)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryCancelled(t *testing.T) {
+	sess := newTestSession()
+	ctx, cancel := context.WithCancel(context.Background())
+	sess.Ctx = ctx
+
+	attempts := 0
+	err := Retry(func(*Session) error {
+		attempts++
+		cancel()
+		return errTest
+	}, DefaultRetryPolicy)(sess)
+	if err != errTest || attempts != 1 {
+		t.Fatalf("expect no retry once cancelled, got %d attempts, %v", attempts, err)
+	}
+}
+
+// waitCtx returns an Action blocking until its context is done or after d
+func waitCtx(d time.Duration) Action {
+	return func(sess *Session) error {
+		select {
+		case <-sess.Ctx.Done():
+			return sess.Ctx.Err()
+		case <-time.After(d):
+			return nil
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name   string
+		parent context.Context
+		action Action
+		expect error
+	}{
+		{name: "in time", action: waitCtx(time.Millisecond)},
+		{name: "exceeded", action: waitCtx(time.Second), expect: ErrActionTimeout},
+		{name: "action error", action: func(*Session) error { return errTest }, expect: errTest},
+		{name: "parent cancelled", parent: cancelled, action: waitCtx(time.Second), expect: context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess := newTestSession()
+			if tt.parent != nil {
+				sess.Ctx = tt.parent
+			}
+			parent := sess.Ctx
+
+			err := Timeout(tt.action, 20*time.Millisecond)(sess)
+			if err != tt.expect {
+				t.Fatalf("expect %v, got %v", tt.expect, err)
+			}
+			if sess.Ctx != parent {
+				t.Fatalf("the context of the session changed")
+			}
+		})
+	}
+}
+
+func TestTimeoutSharesKeys(t *testing.T) {
+	sess := newTestSession()
+	err := Timeout(mark("timeout"), time.Second)(sess)
+	if err != nil || ran(sess) != "timeout" {
+		t.Fatalf("expect the key set by the action, got %q, %v", ran(sess), err)
+	}
+}
+
+func TestTimeoutCopiesBack(t *testing.T) {
+	sess := newTestSession()
+	parent := sess.Ctx
+	conn := new(grpc.ClientConn)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	// e.g. WithRequestID and WithGrpc inside WithTimeout
+	err := Timeout(func(sess *Session) error {
+		sess.RequestID = "id"
+		sess.GrpcConns = append(sess.GrpcConns, conn)
+		sess.Request = req
+		sess.ws = new(wsState)
+		sess.maxFrameSize = 1024
+		return errTest
+	}, time.Second)(sess)
+	if err != errTest {
+		t.Fatalf("expect the error of the action, got %v", err)
+	}
+
+	if sess.RequestID != "id" || len(sess.GrpcConns) != 1 || sess.GrpcConns[0] != conn || sess.Request != req {
+		t.Fatalf("expect the fields set by the action, got %+v", sess)
+	}
+	if sess.ws == nil || sess.maxFrameSize != 1024 {
+		t.Fatalf("expect the websocket state set by the action")
+	}
+	if sess.Ctx != parent {
+		t.Fatalf("the context of the session changed")
+	}
+}
+
+func TestTimeoutPanic(t *testing.T) {
+	sess := newTestSession()
+	parent := sess.Ctx
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("expect the panic to propagate")
+			}
+		}()
+		Timeout(func(*Session) error { panic("test") }, time.Second)(sess)
+	}()
+
+	if sess.Ctx != parent || sess.Ctx.Err() != nil {
+		t.Fatalf("the context of the session changed by the panic")
+	}
+}
+
+func TestTimeoutParallel(t *testing.T) {
+	sess := newTestSession()
+	parent := sess.Ctx
+
+	var timeouts int32
+	branch := func(d, wait time.Duration) Action {
+		return func(sess *Session) error {
+			err := Timeout(waitCtx(wait), d)(sess)
+			if err == ErrActionTimeout {
+				atomic.AddInt32(&timeouts, 1)
+				return nil
+			}
+			return err
+		}
+	}
+
+	// run under -race: the branches must not write the shared session
+	err := Parallel(
+		branch(10*time.Millisecond, time.Second),
+		branch(time.Second, 20*time.Millisecond),
+		branch(15*time.Millisecond, time.Second),
+		branch(time.Second, time.Millisecond),
+	)(sess)
+	if err != nil {
+		t.Fatalf("Parallel: %v", err)
+	}
+	if timeouts != 2 {
+		t.Fatalf("expect 2 branches timed out, got %d", timeouts)
+	}
+	if sess.Ctx != parent {
+		t.Fatalf("the context of the session changed")
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	err := WithTimeout(10*time.Millisecond)(newTestSession(), waitCtx(time.Second))
+	if err != HttpErrorTimeout {
+		t.Fatalf("expect HttpErrorTimeout, got %v", err)
+	}
+}
+
+func TestWithTimeoutIdleWebsocket(t *testing.T) {
+	handler := testWsHandler(DefaultWsConfig, func(sess *Session) error {
+		// the client sends nothing
+		return StreamForeach(sess, func([]byte) error { return nil })
+	})
+	handler.Use(WithTimeout(50 * time.Millisecond))
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("fail to dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var resp struct {
+		Type string  `json:"type"`
+		Data WsError `json:"data"`
+	}
+	if err := conn.ReadJSON(&resp); err != nil {
+		t.Fatalf("fail to read error: %v", err)
+	}
+	if resp.Type != TypeError || resp.Data.Code != WsErrorTimeout.Code {
+		t.Fatalf("expect timeout error, got %+v", resp)
+	}
+}
+
+func TestFallback(t *testing.T) {
+	tests := []struct {
+		name    string
+		primary Action
+		expect  string
+	}{
+		{"primary", mark("primary"), "primary"},
+		{"secondary", func(*Session) error { return errTest }, "secondary"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess := newTestSession()
+			err := Fallback(tt.primary, mark("secondary"))(sess)
+			if err != nil || ran(sess) != tt.expect {
+				t.Fatalf("expect %q, got %q, %v", tt.expect, ran(sess), err)
+			}
+		})
+	}
+}
+
+func TestLoopWhile(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		limit  int
+		result error
+		turns  int
+		expect error
+	}{
+		{name: "break", limit: -1, result: ErrBreak, turns: 3},
+		{name: "error", limit: -1, result: errTest, turns: 3, expect: errTest},
+		{name: "predicate", limit: 5, turns: 5},
+		{name: "cancelled", ctx: cancelled, limit: -1, result: ErrBreak, turns: 0, expect: context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess := newTestSession()
+			if tt.ctx != nil {
+				sess.Ctx = tt.ctx
+			}
+
+			turns := 0
+			action := func(*Session) error {
+				turns++
+				if turns == 3 {
+					return tt.result
+				}
+				return nil
+			}
+
+			loop := Loop(action)
+			if tt.limit >= 0 {
+				loop = While(func(*Session) bool { return turns < tt.limit }, action)
+			}
+			err := loop(sess)
+			if err != tt.expect || turns != tt.turns {
+				t.Fatalf("expect %d turns and %v, got %d and %v", tt.turns, tt.expect, turns, err)
+			}
+		})
+	}
+}
+
+func TestFinally(t *testing.T) {
+	errCleanup := errors.New("cleanup error")
+
+	tests := []struct {
+		name    string
+		action  Action
+		cleanup error
+		expect  error
+		panics  bool
+	}{
+		{name: "success", action: mark("action")},
+		{name: "action error", action: func(*Session) error { return errTest }, cleanup: errCleanup, expect: errTest},
+		{name: "cleanup error", action: mark("action"), cleanup: errCleanup, expect: errCleanup},
+		{name: "panic", action: func(*Session) error { panic("test") }, panics: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cleaned := false
+			cleanup := func(*Session) error {
+				cleaned = true
+				return tt.cleanup
+			}
+
+			var err error
+			func() {
+				defer func() {
+					if perr := recover(); (perr != nil) != tt.panics {
+						t.Fatalf("unexpected panic %v", perr)
+					}
+				}()
+				err = Finally(tt.action, cleanup)(newTestSession())
+			}()
+
+			if !cleaned {
+				t.Fatalf("cleanup not executed")
+			}
+			if err != tt.expect {
+				t.Fatalf("expect %v, got %v", tt.expect, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,568 @@
+package framework
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Content types of the request bodies bound by Bind, see Codec
+const (
+	ContentTypeJSON      = "application/json"
+	ContentTypeProtobuf  = "application/x-protobuf"
+	ContentTypeForm      = "application/x-www-form-urlencoded"
+	ContentTypeMultipart = "multipart/form-data"
+)
+
+// FieldError is an invalid field of a bound request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// BindConfig configures Bind
+type BindConfig struct {
+	// MaxBodySize of the request, larger bodies fail with HttpErrorBodyTooLarge
+	MaxBodySize int64
+}
+
+var DefaultBindConfig = BindConfig{
+	MaxBodySize: 1024 * 1024,
+}
+
+// Bind fills obj, a pointer to a struct or a proto message, from the request
+// with DefaultBindConfig. Struct fields are filled by their path, header, query,
+// form and json tags in that precedence, then checked by their validate tag
+// (required, min=n, max=n, oneof=a b). A proto message is filled from a body of
+// any Codec, then from the query and path parameters named after its fields.
+// It returns an HttpError listing the invalid fields
+func Bind(sess *Session, obj interface{}) error {
+	return DefaultBindConfig.Bind(sess, obj)
+}
+
+// Bind fills obj from the request, see Bind
+func (p BindConfig) Bind(sess *Session, obj interface{}) error {
+	req := sess.Request
+	if req == nil {
+		return ErrNoHttpRequest
+	}
+	if req.Body != nil && p.MaxBodySize > 0 {
+		req.Body = http.MaxBytesReader(sess.ResponseWriter, req.Body, p.MaxBodySize)
+	}
+
+	if msg, ok := obj.(proto.Message); ok {
+		return bindProto(sess, msg)
+	}
+
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("Bind: expect a pointer to a struct, got %T", obj)
+	}
+
+	contentType := requestContentType(req)
+	switch contentType {
+	case ContentTypeJSON:
+		err := decodeJSONBody(req, v.Elem())
+		if err != nil {
+			return err
+		}
+	case ContentTypeProtobuf:
+		// protobuf needs a proto message
+		return HttpErrorUnsupportedMediaType
+	case ContentTypeMsgpack:
+		err := RequestDecode(sess, obj)
+		if err != nil {
+			return err
+		}
+	case ContentTypeForm, ContentTypeMultipart:
+		// the body size is already bounded by MaxBytesReader
+		err := req.ParseMultipartForm(32 << 20)
+		if err == http.ErrNotMultipart {
+			err = req.ParseForm()
+		}
+		if err != nil {
+			return bodyError(err)
+		}
+	}
+
+	var errs []FieldError
+	bindStruct(sess, v.Elem(), &errs)
+	if len(errs) == 0 {
+		validateStruct(v.Elem(), &errs)
+	}
+	if len(errs) > 0 {
+		sess.Errorf("Bind: invalid request: %v", errs)
+		return HttpErrorBadRequest.WithFields(errs)
+	}
+
+	return nil
+}
+
+func requestContentType(req *http.Request) string {
+	contentType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+
+	return contentType
+}
+
+// bodyError turns a body read error into an HttpError
+func bodyError(err error) error {
+	// http.MaxBytesReader has no typed error
+	if strings.Contains(err.Error(), "request body too large") {
+		return HttpErrorBodyTooLarge
+	}
+
+	return HttpErrorBadRequest.WithFields([]FieldError{{Field: "body", Message: err.Error()}})
+}
+
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, bodyError(err)
+	}
+
+	return data, nil
+}
+
+// decodeJSONBody fills the json tagged fields of v from a JSON object body
+func decodeJSONBody(req *http.Request, v reflect.Value) error {
+	data, err := readBody(req)
+	if err != nil || len(bytes.TrimSpace(data)) == 0 {
+		return err
+	}
+
+	var body map[string]json.RawMessage
+	err = json.Unmarshal(data, &body)
+	if err != nil {
+		return bodyError(err)
+	}
+
+	var errs []FieldError
+	bindJSON(v, body, &errs)
+	if len(errs) > 0 {
+		return HttpErrorBadRequest.WithFields(errs)
+	}
+
+	return nil
+}
+
+// bindJSON fills the fields of v tagged json from the members of body, so that
+// a body can't set the fields of the other sources
+func bindJSON(v reflect.Value, body map[string]json.RawMessage, errs *[]FieldError) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+
+		tag := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && tag == "" {
+			bindJSON(v.Field(i), body, errs)
+			continue
+		}
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		raw, ok := body[tag]
+		if !ok {
+			// like encoding/json, names match case insensitively
+			for name, value := range body {
+				if strings.EqualFold(name, tag) {
+					raw, ok = value, true
+					break
+				}
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		err := json.Unmarshal(raw, v.Field(i).Addr().Interface())
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+			*errs = append(*errs, FieldError{
+				Field:   tag,
+				Message: fmt.Sprintf("expect %s, got %s", typeErr.Type, typeErr.Value),
+			})
+		} else if err != nil {
+			*errs = append(*errs, FieldError{Field: tag, Message: err.Error()})
+		}
+	}
+}
+
+// bindStruct fills the tagged fields of v, embedded structs included
+func bindStruct(sess *Session, v reflect.Value, errs *[]FieldError) {
+	req := sess.Request
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			bindStruct(sess, v.Field(i), errs)
+			continue
+		}
+
+		var name string
+		var values []string
+		if tag := field.Tag.Get("form"); tag != "" && req.PostForm != nil {
+			if form, ok := req.PostForm[tag]; ok {
+				name, values = tag, form
+			}
+		}
+		if tag := field.Tag.Get("query"); tag != "" {
+			if query, ok := req.URL.Query()[tag]; ok {
+				name, values = tag, query
+			}
+		}
+		if tag := field.Tag.Get("header"); tag != "" {
+			if header := req.Header.Values(tag); len(header) > 0 {
+				name, values = tag, header
+			}
+		}
+		if tag := field.Tag.Get("path"); tag != "" {
+			if param := sess.Param(tag); param != "" {
+				name, values = tag, []string{param}
+			}
+		}
+		if values == nil {
+			continue
+		}
+
+		err := setValue(v.Field(i), values)
+		if err != nil {
+			*errs = append(*errs, FieldError{Field: name, Message: err.Error()})
+		}
+	}
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setValue sets v from the string values of a parameter
+func setValue(v reflect.Value, values []string) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		elem := reflect.New(v.Type().Elem())
+		err := setValue(elem.Elem(), values)
+		if err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	case reflect.Slice:
+		slice := reflect.MakeSlice(v.Type(), len(values), len(values))
+		for i, value := range values {
+			err := setValue(slice.Index(i), []string{value})
+			if err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	}
+
+	value := values[0]
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid bool '%s'", value)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Type() == durationType {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("invalid duration '%s'", value)
+			}
+			v.SetInt(int64(d))
+			return nil
+		}
+		n, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer '%s'", value)
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid unsigned integer '%s'", value)
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number '%s'", value)
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
+
+// fieldName is the name of a field in the request, for error messages
+func fieldName(field reflect.StructField) string {
+	for _, key := range []string{"path", "query", "header", "form", "json"} {
+		if tag := strings.Split(field.Tag.Get(key), ",")[0]; tag != "" && tag != "-" {
+			return tag
+		}
+	}
+
+	return field.Name
+}
+
+// validateStruct checks the validate rules of the fields of v
+func validateStruct(v reflect.Value, errs *[]FieldError) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			validateStruct(v.Field(i), errs)
+			continue
+		}
+
+		rules := field.Tag.Get("validate")
+		if rules == "" {
+			continue
+		}
+
+		for _, rule := range strings.Split(rules, ",") {
+			err := validateRule(v.Field(i), rule)
+			if err != nil {
+				*errs = append(*errs, FieldError{Field: fieldName(field), Message: err.Error()})
+				break
+			}
+		}
+	}
+}
+
+func validateRule(v reflect.Value, rule string) error {
+	name, arg := rule, ""
+	if i := strings.IndexByte(rule, '='); i >= 0 {
+		name, arg = rule[:i], rule[i+1:]
+	}
+
+	if name == "required" {
+		if v.IsZero() {
+			return fmt.Errorf("required")
+		}
+		return nil
+	}
+
+	// the other rules apply to set values only
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch name {
+	case "min", "max":
+		bound, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return fmt.Errorf("invalid rule '%s'", rule)
+		}
+
+		var n float64
+		what := "value"
+		switch v.Kind() {
+		case reflect.String, reflect.Slice, reflect.Map:
+			n, what = float64(v.Len()), "length"
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = float64(v.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n = float64(v.Uint())
+		case reflect.Float32, reflect.Float64:
+			n = v.Float()
+		default:
+			return fmt.Errorf("invalid rule '%s' for %s", rule, v.Type())
+		}
+
+		if name == "min" && n < bound {
+			return fmt.Errorf("%s must be at least %s", what, arg)
+		}
+		if name == "max" && n > bound {
+			return fmt.Errorf("%s must be at most %s", what, arg)
+		}
+	case "oneof":
+		value := fmt.Sprint(v.Interface())
+		for _, allowed := range strings.Fields(arg) {
+			if value == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(strings.Fields(arg), ", "))
+	default:
+		return fmt.Errorf("unknown rule '%s'", name)
+	}
+
+	return nil
+}
+
+// bindProto fills msg from the body, then from the query and path parameters
+func bindProto(sess *Session, msg proto.Message) error {
+	req := sess.Request
+
+	if codec := CodecFor(requestContentType(req)); codec != nil {
+		data, err := readBody(req)
+		if err != nil {
+			return err
+		}
+		if len(data) > 0 {
+			err = codec.Unmarshal(data, msg)
+			if err != nil {
+				return HttpErrorBadRequest.WithFields([]FieldError{{Field: "body", Message: err.Error()}})
+			}
+		}
+	}
+
+	params := req.URL.Query()
+	if route, ok := req.Context().Value(paramsKey{}).(map[string]string); ok {
+		for k, v := range route {
+			params[k] = []string{v}
+		}
+	}
+
+	var errs []FieldError
+	m := msg.ProtoReflect()
+	fields := m.Descriptor().Fields()
+	for name, values := range params {
+		fd := fields.ByName(protoreflect.Name(name))
+		if fd == nil {
+			fd = fields.ByJSONName(name)
+		}
+		if fd == nil {
+			continue
+		}
+
+		err := setProtoField(m, fd, values)
+		if err != nil {
+			errs = append(errs, FieldError{Field: name, Message: err.Error()})
+		}
+	}
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+		sess.Errorf("Bind: invalid request: %v", errs)
+		return HttpErrorBadRequest.WithFields(errs)
+	}
+
+	return nil
+}
+
+func setProtoField(m protoreflect.Message, fd protoreflect.FieldDescriptor, values []string) error {
+	if fd.IsMap() || fd.Message() != nil {
+		return fmt.Errorf("unsupported message field")
+	}
+
+	if fd.IsList() {
+		list := m.Mutable(fd).List()
+		for _, value := range values {
+			v, err := protoValue(fd, value)
+			if err != nil {
+				return err
+			}
+			list.Append(v)
+		}
+		return nil
+	}
+
+	v, err := protoValue(fd, values[0])
+	if err != nil {
+		return err
+	}
+	m.Set(fd, v)
+
+	return nil
+}
+
+// protoValue parses a scalar or enum value of fd
+func protoValue(fd protoreflect.FieldDescriptor, s string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BytesKind:
+		return protoreflect.ValueOfBytes([]byte(s)), nil
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("invalid bool '%s'", s)
+		}
+		return protoreflect.ValueOfBool(b), nil
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil || fd.Enum().Values().ByNumber(protoreflect.EnumNumber(n)) == nil {
+			return protoreflect.Value{}, fmt.Errorf("invalid %s '%s'", fd.Enum().Name(), s)
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), nil
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("invalid integer '%s'", s)
+		}
+		return protoreflect.ValueOfInt32(int32(n)), nil
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("invalid integer '%s'", s)
+		}
+		return protoreflect.ValueOfInt64(n), nil
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		n, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("invalid unsigned integer '%s'", s)
+		}
+		return protoreflect.ValueOfUint32(uint32(n)), nil
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("invalid unsigned integer '%s'", s)
+		}
+		return protoreflect.ValueOfUint64(n), nil
+	case protoreflect.FloatKind:
+		f, err := strconv.ParseFloat(s, 32)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("invalid number '%s'", s)
+		}
+		return protoreflect.ValueOfFloat32(float32(f)), nil
+	case protoreflect.DoubleKind:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("invalid number '%s'", s)
+		}
+		return protoreflect.ValueOfFloat64(f), nil
+	default:
+		return protoreflect.Value{}, fmt.Errorf("unsupported field kind %s", fd.Kind())
+	}
+}
@@ -0,0 +1,267 @@
+package framework
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"tinker/mock/pb/hello"
+
+	"google.golang.org/protobuf/proto"
+)
+
+type bindParams struct {
+	Person string        `path:"person" validate:"required"`
+	Saying string        `query:"saying" json:"saying" validate:"max=8"`
+	Admin  bool          `header:"X-Admin"`
+	Count  int           `json:"count" validate:"min=1"`
+	Tags   []string      `query:"tag"`
+	Wait   time.Duration `query:"wait"`
+	Name   string        `form:"name"`
+}
+
+func bindRequest(method, target, contentType, body string, params map[string]string) *Session {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if params != nil {
+		req = req.WithContext(context.WithValue(req.Context(), paramsKey{}, params))
+	}
+
+	return &Session{Name: "test", Request: req, ResponseWriter: httptest.NewRecorder(), Ctx: context.Background()}
+}
+
+func TestBind(t *testing.T) {
+	bob := map[string]string{"person": "Bob"}
+
+	tests := []struct {
+		name        string
+		target      string
+		contentType string
+		body        string
+		params      map[string]string
+		header      map[string]string
+		expect      bindParams
+		status      int
+		fields      []string
+	}{
+		{
+			name:   "query and path",
+			target: "/?saying=hi&tag=a&tag=b&wait=1s",
+			params: bob,
+			expect: bindParams{Person: "Bob", Saying: "hi", Tags: []string{"a", "b"}, Wait: time.Second},
+			status: http.StatusBadRequest,
+			fields: []string{"count"},
+		},
+		{
+			name:        "json body",
+			target:      "/",
+			contentType: ContentTypeJSON,
+			body:        `{"saying":"yo","count":2}`,
+			params:      bob,
+			expect:      bindParams{Person: "Bob", Saying: "yo", Count: 2},
+		},
+		{
+			name:        "query over json",
+			target:      "/?saying=hi",
+			contentType: ContentTypeJSON,
+			body:        `{"saying":"yo","count":2}`,
+			params:      bob,
+			expect:      bindParams{Person: "Bob", Saying: "hi", Count: 2},
+		},
+		{
+			name:        "json doesn't fill the other sources",
+			target:      "/",
+			contentType: ContentTypeJSON,
+			body:        `{"Person":"Eve","Admin":true,"Tags":["x"],"Name":"eve","count":1}`,
+			params:      bob,
+			expect:      bindParams{Person: "Bob", Count: 1},
+		},
+		{
+			name:        "json doesn't fill a missing path parameter",
+			target:      "/",
+			contentType: ContentTypeJSON,
+			body:        `{"Person":"Eve","count":1}`,
+			status:      http.StatusBadRequest,
+			fields:      []string{"person"},
+		},
+		{
+			name:        "header",
+			target:      "/",
+			contentType: ContentTypeJSON,
+			body:        `{"count":1}`,
+			params:      bob,
+			header:      map[string]string{"X-Admin": "true"},
+			expect:      bindParams{Person: "Bob", Admin: true, Count: 1},
+		},
+		{
+			name:        "form",
+			target:      "/",
+			contentType: ContentTypeForm,
+			body:        "name=joe",
+			params:      bob,
+			expect:      bindParams{Person: "Bob", Name: "joe"},
+			status:      http.StatusBadRequest,
+			fields:      []string{"count"},
+		},
+		{
+			name:        "json type error",
+			target:      "/",
+			contentType: ContentTypeJSON,
+			body:        `{"count":"two"}`,
+			params:      bob,
+			status:      http.StatusBadRequest,
+			fields:      []string{"count"},
+		},
+		{
+			name:        "malformed json",
+			target:      "/",
+			contentType: ContentTypeJSON,
+			body:        `{"count":`,
+			params:      bob,
+			status:      http.StatusBadRequest,
+			fields:      []string{"body"},
+		},
+		{
+			name:   "invalid parameters",
+			target: "/?saying=much+too+long&wait=soon",
+			params: bob,
+			status: http.StatusBadRequest,
+			fields: []string{"wait"},
+		},
+		{
+			name:        "validation",
+			target:      "/?saying=much+too+long",
+			contentType: ContentTypeJSON,
+			body:        `{"count":1}`,
+			params:      bob,
+			status:      http.StatusBadRequest,
+			fields:      []string{"saying"},
+		},
+		{
+			name:        "protobuf into a struct",
+			target:      "/",
+			contentType: ContentTypeProtobuf,
+			body:        "\x08\x01",
+			params:      bob,
+			status:      http.StatusUnsupportedMediaType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess := bindRequest(http.MethodPost, tt.target, tt.contentType, tt.body, tt.params)
+			for k, v := range tt.header {
+				sess.Request.Header.Set(k, v)
+			}
+
+			var params bindParams
+			err := Bind(sess, &params)
+			if tt.status == 0 {
+				if err != nil {
+					t.Fatalf("Bind: %v", err)
+				}
+				if !reflect.DeepEqual(params, tt.expect) {
+					t.Fatalf("expect %+v, got %+v", tt.expect, params)
+				}
+				return
+			}
+
+			herr, ok := err.(*HttpError)
+			if !ok || herr.StatusCode != tt.status {
+				t.Fatalf("expect status %d, got %v", tt.status, err)
+			}
+			var fields []string
+			for _, field := range herr.Fields {
+				fields = append(fields, field.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Fatalf("expect invalid fields %v, got %v", tt.fields, herr.Fields)
+			}
+		})
+	}
+}
+
+func TestBindBodyTooLarge(t *testing.T) {
+	sess := bindRequest(http.MethodPost, "/", ContentTypeJSON, `{"saying":"`+strings.Repeat("a", 100)+`"}`, nil)
+
+	var params bindParams
+	err := BindConfig{MaxBodySize: 10}.Bind(sess, &params)
+	if err != HttpErrorBodyTooLarge {
+		t.Fatalf("expect HttpErrorBodyTooLarge, got %v", err)
+	}
+}
+
+func TestBindProto(t *testing.T) {
+	body, err := proto.Marshal(&hello.GreetRequest{Saying: "hello", Person: hello.Name_Joe})
+	if err != nil {
+		t.Fatalf("proto.Marshal: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		target      string
+		contentType string
+		body        string
+		expect      *hello.GreetRequest
+		status      int
+	}{
+		{
+			name:        "protobuf body and query",
+			target:      "/?Person=Bob",
+			contentType: ContentTypeProtobuf,
+			body:        string(body),
+			expect:      &hello.GreetRequest{Saying: "hello", Person: hello.Name_Bob},
+		},
+		{
+			name:        "json body",
+			target:      "/",
+			contentType: ContentTypeJSON,
+			body:        `{"Saying":"hi","Person":"Robot"}`,
+			expect:      &hello.GreetRequest{Saying: "hi", Person: hello.Name_Robot},
+		},
+		{
+			name:   "invalid enum",
+			target: "/?Person=Nobody",
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess := bindRequest(http.MethodPost, tt.target, tt.contentType, tt.body, nil)
+
+			msg := new(hello.GreetRequest)
+			err := Bind(sess, msg)
+			if tt.status != 0 {
+				if herr, ok := err.(*HttpError); !ok || herr.StatusCode != tt.status {
+					t.Fatalf("expect status %d, got %v", tt.status, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Bind: %v", err)
+			}
+			if !proto.Equal(msg, tt.expect) {
+				t.Fatalf("expect %v, got %v", tt.expect, msg)
+			}
+		})
+	}
+}
+
+func TestBindGrpcSession(t *testing.T) {
+	sess := &Session{Name: "test", Ctx: context.Background()}
+
+	if err := Bind(sess, new(bindParams)); err != ErrNoHttpRequest {
+		t.Fatalf("expect ErrNoHttpRequest from Bind, got %v", err)
+	}
+	if err := RequestDecode(sess, new(bindParams)); err != ErrNoHttpRequest {
+		t.Fatalf("expect ErrNoHttpRequest from RequestDecode, got %v", err)
+	}
+	if err := SendHttpResult(sess, "ok"); err != ErrNoHttpRequest {
+		t.Fatalf("expect ErrNoHttpRequest from SendHttpResult, got %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+package framework
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const ContentTypeMsgpack = "application/msgpack"
+
+// Codec encodes http responses and decodes http requests of a content type.
+// Unmarshal merges into v like encoding/json, keeping the fields set before
+type Codec interface {
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec encodes proto messages with protojson, other values with encoding/json
+type JSONCodec struct{}
+
+func (JSONCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	if msg, ok := v.(proto.Message); ok {
+		return protojson.Marshal(msg)
+	}
+
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	if msg, ok := v.(proto.Message); ok {
+		return protojsonMerge(data, msg)
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// ProtobufCodec encodes proto messages in the protobuf binary format, it
+// fails on other values
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) ContentType() string {
+	return ContentTypeProtobuf
+}
+
+func (ProtobufCodec) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("ProtobufCodec: %T is not a proto message", v)
+	}
+
+	return proto.Marshal(msg)
+}
+
+func (ProtobufCodec) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("ProtobufCodec: %T is not a proto message", v)
+	}
+
+	return proto.UnmarshalOptions{Merge: true}.Unmarshal(data, msg)
+}
+
+// MsgpackCodec encodes values with the field names of their json tags, and
+// proto messages with the field names of protojson
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) ContentType() string {
+	return ContentTypeMsgpack
+}
+
+func (MsgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	if msg, ok := v.(proto.Message); ok {
+		data, err := protojson.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		var generic interface{}
+		err = json.Unmarshal(data, &generic)
+		if err != nil {
+			return nil, err
+		}
+		v = generic
+	}
+
+	var buf bytes.Buffer
+	encoder := msgpack.NewEncoder(&buf)
+	encoder.SetCustomStructTag("json")
+	err := encoder.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	decoder := msgpack.NewDecoder(bytes.NewReader(data))
+	decoder.SetCustomStructTag("json")
+
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return decoder.Decode(v)
+	}
+
+	// through JSON to use the field names of protojson
+	var generic interface{}
+	err := decoder.Decode(&generic)
+	if err != nil {
+		return err
+	}
+	jsonData, err := json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+
+	return protojsonMerge(jsonData, msg)
+}
+
+// protojsonMerge merges the protojson data into msg, protojson.Unmarshal
+// resets msg
+func protojsonMerge(data []byte, msg proto.Message) error {
+	decoded := msg.ProtoReflect().New().Interface()
+	err := protojson.Unmarshal(data, decoded)
+	if err != nil {
+		return err
+	}
+	proto.Merge(msg, decoded)
+
+	return nil
+}
+
+// codecs by content type, JSON first as the default
+var (
+	codecsMu sync.RWMutex
+	codecs   = []Codec{JSONCodec{}, ProtobufCodec{}, MsgpackCodec{}}
+)
+
+// RegisterCodec adds or replaces the codec of a content type, it is safe to
+// call while requests are served
+func RegisterCodec(codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	for i, c := range codecs {
+		if c.ContentType() == codec.ContentType() {
+			codecs[i] = codec
+			return
+		}
+	}
+
+	codecs = append(codecs, codec)
+}
+
+// CodecFor returns the codec of contentType, nil if there is none
+func CodecFor(contentType string) Codec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	for _, codec := range codecs {
+		if codec.ContentType() == contentType {
+			return codec
+		}
+	}
+
+	return nil
+}
+
+// defaultCodec returns the JSON codec
+func defaultCodec() Codec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	return codecs[0]
+}
+
+// acceptedType is a media range of the Accept header
+type acceptedType struct {
+	mediaType string
+	q         float64
+}
+
+// NegotiateCodec returns the codec preferred by the Accept header of req,
+// the JSON codec when the header accepts nothing else
+func NegotiateCodec(req *http.Request) Codec {
+	if req == nil {
+		return defaultCodec()
+	}
+
+	var accepted []acceptedType
+	for _, value := range req.Header.Values("Accept") {
+		for _, item := range strings.Split(value, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(item))
+			if err != nil {
+				continue
+			}
+
+			q := 1.0
+			if v, ok := params["q"]; ok {
+				q, err = strconv.ParseFloat(v, 64)
+				if err != nil {
+					continue
+				}
+			}
+			if q > 0 {
+				accepted = append(accepted, acceptedType{mediaType: mediaType, q: q})
+			}
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].q > accepted[j].q })
+
+	for _, a := range accepted {
+		if codec := CodecFor(a.mediaType); codec != nil {
+			return codec
+		}
+		if a.mediaType == "*/*" || a.mediaType == "application/*" {
+			return defaultCodec()
+		}
+	}
+
+	return defaultCodec()
+}
+
+// RequestDecode decodes the body of the request into obj with the codec of
+// its Content-Type, JSON when it has none
+func RequestDecode(sess *Session, obj interface{}) error {
+	req := sess.Request
+	if req == nil {
+		return ErrNoHttpRequest
+	}
+	if req.Body == nil {
+		return fmt.Errorf("invalid request")
+	}
+
+	codec := defaultCodec()
+	if contentType := requestContentType(req); contentType != "" {
+		codec = CodecFor(contentType)
+		if codec == nil {
+			return HttpErrorUnsupportedMediaType
+		}
+	}
+
+	data, err := readBody(req)
+	if err != nil {
+		return err
+	}
+
+	err = codec.Unmarshal(data, obj)
+	if err != nil {
+		return HttpErrorBadRequest.WithFields([]FieldError{{Field: "body", Message: err.Error()}})
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package framework
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// textCodec encodes values with fmt
+type textCodec struct{}
+
+func (textCodec) ContentType() string {
+	return "text/plain"
+}
+
+func (textCodec) Marshal(v interface{}) ([]byte, error) {
+	return []byte(fmt.Sprint(v)), nil
+}
+
+func (textCodec) Unmarshal(data []byte, v interface{}) error {
+	return fmt.Errorf("textCodec: can't decode")
+}
+
+// registerTestCodec registers codec until the end of the test
+func registerTestCodec(t *testing.T, codec Codec) {
+	codecsMu.RLock()
+	saved := append([]Codec(nil), codecs...)
+	codecsMu.RUnlock()
+	t.Cleanup(func() {
+		codecsMu.Lock()
+		codecs = saved
+		codecsMu.Unlock()
+	})
+
+	RegisterCodec(codec)
+}
+
+func TestNegotiateCodec(t *testing.T) {
+	tests := []struct {
+		accept []string
+		expect string
+	}{
+		{nil, ContentTypeJSON},
+		{[]string{ContentTypeProtobuf}, ContentTypeProtobuf},
+		{[]string{"application/msgpack"}, ContentTypeMsgpack},
+		{[]string{"application/json;q=0.5, application/x-protobuf;q=0.9"}, ContentTypeProtobuf},
+		{[]string{"application/x-protobuf;q=0.4, application/msgpack;q=0.6"}, ContentTypeMsgpack},
+		{[]string{"application/x-protobuf;q=0"}, ContentTypeJSON},
+		{[]string{"application/x-protobuf;q=invalid, application/msgpack"}, ContentTypeMsgpack},
+		{[]string{"text/html, application/xhtml+xml"}, ContentTypeJSON},
+		{[]string{"text/html;q=0.9, */*;q=0.8, application/x-protobuf;q=0.1"}, ContentTypeJSON},
+		{[]string{"application/*;q=0.5, application/x-protobuf"}, ContentTypeProtobuf},
+		{[]string{"text/html", "application/x-protobuf"}, ContentTypeProtobuf},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.accept), func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, accept := range tt.accept {
+				req.Header.Add("Accept", accept)
+			}
+
+			codec := NegotiateCodec(req)
+			if codec.ContentType() != tt.expect {
+				t.Fatalf("expect %s, got %s", tt.expect, codec.ContentType())
+			}
+		})
+	}
+
+	if codec := NegotiateCodec(nil); codec.ContentType() != ContentTypeJSON {
+		t.Fatalf("expect JSON without request, got %s", codec.ContentType())
+	}
+}
+
+func TestSendHttpFallback(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", ContentTypeProtobuf)
+	sess := &Session{Name: "test", Request: req, ResponseWriter: rec}
+
+	// an HttpError isn't a proto message, it is sent as JSON
+	err := SendHttp(sess, http.StatusBadRequest, HttpErrorBadRequest)
+	if err != nil {
+		t.Fatalf("SendHttp: %v", err)
+	}
+	if contentType := rec.Header().Get("Content-Type"); contentType != ContentTypeJSON {
+		t.Fatalf("expect JSON, got %s", contentType)
+	}
+}
+
+func TestRegisterCodec(t *testing.T) {
+	registerTestCodec(t, textCodec{})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "text/html;q=0.9, text/plain")
+	if codec := NegotiateCodec(req); codec.ContentType() != "text/plain" {
+		t.Fatalf("expect the registered codec, got %s", codec.ContentType())
+	}
+}
+
+func TestRegisterCodecConcurrent(t *testing.T) {
+	registerTestCodec(t, textCodec{})
+
+	// run under -race: codecs may be registered while requests are served
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			RegisterCodec(textCodec{})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", "text/plain")
+		for i := 0; i < 100; i++ {
+			NegotiateCodec(req)
+			CodecFor(ContentTypeJSON)
+		}
+	}()
+	wg.Wait()
+}
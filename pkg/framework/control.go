@@ -0,0 +1,198 @@
+package framework
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Control message types
+const (
+	ControlStart  = "start"
+	ControlEnd    = "end"
+	ControlCancel = "cancel"
+	ControlPing   = "ping"
+)
+
+// StartMessageKey is the Session key of the start message received by AwaitStart
+const StartMessageKey = "framework.start"
+
+var (
+	ErrStreamCanceled = WsErrorClient.WithMessage("stream canceled by client")
+	ErrStartTimeout   = WsErrorClient.WithMessage("timeout waiting for start message")
+)
+
+// ControlMessage is a JSON control message sent by the client in a text frame
+// e.g. {"type":"start","params":{"language":"en-US","sample_rate":16000}}
+type ControlMessage struct {
+	Type   string          `json:"type"`
+	Params json.RawMessage `json:"params,omitempty"`
+
+	// Value holds Params decoded into the schema registered for Type,
+	// nil if no schema is registered
+	Value interface{} `json:"-"`
+}
+
+// ControlProtocol is a StreamFraming where text frames carry ControlMessage
+// and binary frames carry data.
+// Besides the builtin types (start, end, cancel, ping), custom message types
+// can be accepted by registering their schema. JSONControlFraming and
+// ProtoWsCodec share their protocol with every session, register types of
+// your own on a NewControlProtocol
+type ControlProtocol struct {
+	mu      sync.RWMutex
+	schemas map[string]func() interface{}
+}
+
+func NewControlProtocol() *ControlProtocol {
+	return &ControlProtocol{
+		schemas: make(map[string]func() interface{}),
+	}
+}
+
+// Register registers the params schema of a message type.
+// newParams returns a pointer to be filled by json.Unmarshal
+func (p *ControlProtocol) Register(msgType string, newParams func() interface{}) *ControlProtocol {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.schemas[msgType] = newParams
+	return p
+}
+
+func (p *ControlProtocol) Decode(messageType int, data []byte) (*Frame, error) {
+	switch messageType {
+	case websocket.BinaryMessage:
+		return &Frame{Kind: FrameData, Data: data}, nil
+	case websocket.TextMessage:
+		msg, err := p.decodeControl(data)
+		if err != nil {
+			return nil, err
+		}
+
+		return controlFrame(msg), nil
+	default:
+		return nil, fmt.Errorf("stream closed before end message")
+	}
+}
+
+// controlFrame is the frame of a control message, the end message ends the stream
+func controlFrame(msg *ControlMessage) *Frame {
+	if msg.Type == ControlEnd {
+		return &Frame{Kind: FrameEnd, Control: msg}
+	}
+
+	return &Frame{Kind: FrameControl, Control: msg}
+}
+
+func (p *ControlProtocol) decodeControl(data []byte) (*ControlMessage, error) {
+	msg := new(ControlMessage)
+	err := json.Unmarshal(data, msg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid control message: %v", err)
+	}
+
+	return p.resolve(msg)
+}
+
+// resolve checks the type of msg and decodes its params into the registered schema
+func (p *ControlProtocol) resolve(msg *ControlMessage) (*ControlMessage, error) {
+	p.mu.RLock()
+	newParams, ok := p.schemas[msg.Type]
+	p.mu.RUnlock()
+	if !ok {
+		switch msg.Type {
+		case ControlStart, ControlEnd, ControlCancel, ControlPing:
+			return msg, nil
+		default:
+			return nil, fmt.Errorf("unsupported control message type '%s'", msg.Type)
+		}
+	}
+
+	msg.Value = newParams()
+	if len(msg.Params) > 0 {
+		err := json.Unmarshal(msg.Params, msg.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid params of control message '%s': %v", msg.Type, err)
+		}
+	}
+
+	return msg, nil
+}
+
+// handleControl handles the builtin control messages, others are passed to control.
+// Messages are ignored if control is nil
+func handleControl(sess *Session, msg *ControlMessage, control func(*ControlMessage) error) error {
+	switch msg.Type {
+	case ControlPing:
+		return sendWs(sess, &WsResponse{
+			Type:      TypePong,
+			RequestID: sess.RequestID,
+		})
+	case ControlCancel:
+		return ErrStreamCanceled
+	}
+
+	if control == nil {
+		sess.Warningf("handleControl: control message '%s' ignored", msg.Type)
+		return nil
+	}
+
+	return control(msg)
+}
+
+// AwaitStart returns an Action waiting for the start control message before
+// the stream begins. Pings are answered while waiting, data is rejected.
+// The message is set in Session with StartMessageKey, see StartMessage.
+// A websocket can't be read after its read deadline, so the session is closed
+// with ClosePolicyViolation once the timeout is exceeded
+func AwaitStart(timeout time.Duration) Action {
+	return func(sess *Session) error {
+		if timeout > 0 {
+			sess.WsConn.SetReadDeadline(time.Now().Add(timeout))
+			defer sess.WsConn.SetReadDeadline(time.Time{})
+		}
+
+		for {
+			frame, err := readFrame(sess)
+			if err != nil {
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					sess.Errorf("AwaitStart: no start message within %s", timeout)
+					closeWith(sess, websocket.ClosePolicyViolation, ErrStartTimeout.Message, true)
+					return ErrStartTimeout
+				}
+				sess.Errorf("AwaitStart: fail to read start message: %v", err)
+				return err
+			}
+
+			if frame.Kind != FrameControl {
+				return WsErrorClient.WithMessage("expected start message")
+			}
+
+			if frame.Control.Type == ControlStart {
+				sess.Set(StartMessageKey, frame.Control)
+				return nil
+			}
+
+			err = handleControl(sess, frame.Control, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// StartMessage returns the start message received by AwaitStart
+func StartMessage(sess *Session) (*ControlMessage, bool) {
+	v, ok := sess.Get(StartMessageKey)
+	if !ok {
+		return nil, false
+	}
+
+	msg, ok := v.(*ControlMessage)
+	return msg, ok
+}
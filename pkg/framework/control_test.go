@@ -0,0 +1,124 @@
+package framework
+
+import (
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialWs serves actions behind WithWebsocket and returns a client of it
+func dialWs(t *testing.T, cfg WsConfig, actions ...Action) *websocket.Conn {
+	t.Helper()
+	return dialWsQuery(t, cfg, "", actions...)
+}
+
+// testWsHandler returns a handler serving actions behind WithWebsocketConfig
+func testWsHandler(cfg WsConfig, actions ...Action) *Handler {
+	handler := &Handler{Name: "test", OnError: LogError, OnPanic: LogPanic}
+	handler.Use(WithRequestID(), WithWebsocketConfig(cfg), WithReplyWsError())
+	handler.Add(actions...)
+
+	return handler
+}
+
+// dialWsQuery is dialWs with the query string of the request
+func dialWsQuery(t *testing.T, cfg WsConfig, query string, actions ...Action) *websocket.Conn {
+	t.Helper()
+
+	srv := httptest.NewServer(testWsHandler(cfg, actions...))
+	t.Cleanup(srv.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/?"+query, nil)
+	if err != nil {
+		t.Fatalf("fail to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	return conn
+}
+
+func TestAwaitStartTimeoutCloses(t *testing.T) {
+	cfg := DefaultWsConfig
+	cfg.Framing = JSONControlFraming
+	conn := dialWs(t, cfg, AwaitStart(50*time.Millisecond))
+
+	var resp struct {
+		Type string  `json:"type"`
+		Data WsError `json:"data"`
+	}
+	err := conn.ReadJSON(&resp)
+	if err != nil {
+		t.Fatalf("fail to read error: %v", err)
+	}
+	if resp.Type != TypeError || resp.Data.Code != CodeClientError {
+		t.Fatalf("expect client error, got %+v", resp)
+	}
+
+	// the server doesn't wait for our close frame, the read deadline broke its reader
+	start := time.Now()
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Fatalf("expect close %d, got %v", websocket.ClosePolicyViolation, err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("close took %s", time.Since(start))
+	}
+}
+
+func TestAwaitStart(t *testing.T) {
+	cfg := DefaultWsConfig
+	cfg.Framing = JSONControlFraming
+	started := make(chan *ControlMessage, 1)
+	conn := dialWs(t, cfg, AwaitStart(time.Second), func(sess *Session) error {
+		msg, _ := StartMessage(sess)
+		started <- msg
+		return nil
+	})
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"ping"}`))
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"start","params":{"rate":16000}}`))
+
+	var resp WsResponse
+	if err := conn.ReadJSON(&resp); err != nil || resp.Type != TypePong {
+		t.Fatalf("expect pong, got %+v, %v", resp, err)
+	}
+	msg := <-started
+	if msg == nil || string(msg.Params) != `{"rate":16000}` {
+		t.Fatalf("unexpected start message %+v", msg)
+	}
+
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Fatalf("expect normal close, got %v", err)
+	}
+}
+
+func TestControlProtocolRegisterConcurrent(t *testing.T) {
+	protocol := NewControlProtocol()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			protocol.Register("config", func() interface{} { return new(map[string]interface{}) })
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			protocol.Decode(websocket.TextMessage, []byte(`{"type":"start"}`))
+		}
+	}()
+	wg.Wait()
+
+	frame, err := protocol.Decode(websocket.TextMessage, []byte(`{"type":"config","params":{"a":1}}`))
+	if err != nil || frame.Kind != FrameControl || frame.Control.Value == nil {
+		t.Fatalf("expect decoded config message, got %+v, %v", frame, err)
+	}
+}
@@ -0,0 +1,155 @@
+package framework
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+// namedWrapper is a Wrapper of a Handler with the name used to describe and
+// edit the wrapper stack
+type namedWrapper struct {
+	name    string
+	wrapper Wrapper
+}
+
+func nameWrappers(wrappers []Wrapper) []namedWrapper {
+	ret := make([]namedWrapper, len(wrappers))
+	for i, wrapper := range wrappers {
+		ret[i] = namedWrapper{name: funcName(wrapper), wrapper: wrapper}
+	}
+
+	return ret
+}
+
+func unnameWrappers(wrappers []namedWrapper) []Wrapper {
+	ret := make([]Wrapper, len(wrappers))
+	for i, wrapper := range wrappers {
+		ret[i] = wrapper.wrapper
+	}
+
+	return ret
+}
+
+// funcName returns the name of a function without package path and closure
+// suffixes, e.g. "WithGrpc" for the Wrapper returned by WithGrpc or
+// "(*httpCase).CallGRPC" for a method value. The Wrapper returned by
+// WithXxxConfig is named WithXxx, the same as the one returned by WithXxx
+func funcName(fn interface{}) string {
+	f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer())
+	if f == nil {
+		return "unknown"
+	}
+
+	name := f.Name()
+	name = name[strings.LastIndex(name, "/")+1:]
+	name = name[strings.Index(name, ".")+1:]
+	name = strings.TrimSuffix(name, "-fm")
+
+	// closures are named like "WithGrpc.func1" or "WithGrpc.func1.1"
+	for {
+		i := strings.LastIndex(name, ".")
+		if i < 0 {
+			break
+		}
+		suffix := strings.TrimPrefix(name[i+1:], "func")
+		if suffix == "" || strings.Trim(suffix, "0123456789") != "" {
+			break
+		}
+		name = name[:i]
+	}
+	if strings.HasPrefix(name, "With") && strings.HasSuffix(name, "Config") {
+		name = strings.TrimSuffix(name, "Config")
+	}
+
+	return name
+}
+
+// UseNamed adds a wrapper under name instead of the name of its function
+func (p *Handler) UseNamed(name string, wrapper Wrapper) {
+	p.wrappers = append(p.wrappers, namedWrapper{name: name, wrapper: wrapper})
+	p.build()
+}
+
+func (p *Handler) indexWrapper(name string) (int, error) {
+	for i, wrapper := range p.wrappers {
+		if wrapper.name == name {
+			return i, nil
+		}
+	}
+
+	return -1, fmt.Errorf("handler %s has no wrapper %s", p.Name, name)
+}
+
+func (p *Handler) insertWrappers(i int, wrappers []Wrapper) {
+	ret := make([]namedWrapper, 0, len(p.wrappers)+len(wrappers))
+	ret = append(ret, p.wrappers[:i]...)
+	ret = append(ret, nameWrappers(wrappers)...)
+	p.wrappers = append(ret, p.wrappers[i:]...)
+	p.build()
+}
+
+// InsertBefore inserts wrappers right outside the wrapper named name
+func (p *Handler) InsertBefore(name string, wrappers ...Wrapper) error {
+	i, err := p.indexWrapper(name)
+	if err != nil {
+		return err
+	}
+	p.insertWrappers(i, wrappers)
+
+	return nil
+}
+
+// InsertAfter inserts wrappers right inside the wrapper named name
+func (p *Handler) InsertAfter(name string, wrappers ...Wrapper) error {
+	i, err := p.indexWrapper(name)
+	if err != nil {
+		return err
+	}
+	p.insertWrappers(i+1, wrappers)
+
+	return nil
+}
+
+// Replace replaces the wrapper named name, the new wrapper keeps the name
+func (p *Handler) Replace(name string, wrapper Wrapper) error {
+	i, err := p.indexWrapper(name)
+	if err != nil {
+		return err
+	}
+	p.wrappers[i] = namedWrapper{name: name, wrapper: wrapper}
+	p.build()
+
+	return nil
+}
+
+// Description is the pipeline of a Handler: its wrappers from the most
+// outside one and its actions in order
+type Description struct {
+	Name     string   `json:"name"`
+	Wrappers []string `json:"wrappers"`
+	Actions  []string `json:"actions"`
+}
+
+// Describe returns the pipeline of the handler
+func (p *Handler) Describe() *Description {
+	ret := &Description{
+		Name:     p.Name,
+		Wrappers: make([]string, len(p.wrappers)),
+		Actions:  make([]string, len(p.actions)),
+	}
+	for i, wrapper := range p.wrappers {
+		ret.Wrappers[i] = wrapper.name
+	}
+	for i, action := range p.actions {
+		ret.Actions[i] = funcName(action)
+	}
+
+	return ret
+}
+
+// String renders the pipeline like "WithRequestID > WithGrpc > [CallGRPC]"
+func (p *Description) String() string {
+	return strings.Join(append(p.Wrappers, "["+strings.Join(p.Actions, ", ")+"]"), " > ")
+}
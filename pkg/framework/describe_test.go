@@ -0,0 +1,110 @@
+package framework
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// traceWrapper appends name to the trace of the session
+func traceWrapper(name string) Wrapper {
+	return func(sess *Session, action Action) error {
+		trace, _ := sess.Get("trace")
+		sess.Set("trace", append(trace.([]string), name))
+		return action(sess)
+	}
+}
+
+func serveTrace(t *testing.T, handler *Handler) []string {
+	t.Helper()
+
+	var trace []string
+	h := *handler
+	h.UseFirst(func(sess *Session, action Action) error {
+		sess.Set("trace", []string(nil))
+		defer func() {
+			v, _ := sess.Get("trace")
+			trace = v.([]string)
+		}()
+		return action(sess)
+	})
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	return trace
+}
+
+func TestHandlerEditWrappers(t *testing.T) {
+	handler := &Handler{Name: "test", OnError: LogError, OnPanic: LogPanic}
+	handler.UseNamed("auth", traceWrapper("auth"))
+	handler.UseNamed("limit", traceWrapper("limit"))
+	handler.Add(func(sess *Session) error { return nil })
+
+	if err := handler.Replace("auth", traceWrapper("auth2")); err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+	if err := handler.InsertAfter("auth", traceWrapper("audit")); err != nil {
+		t.Fatalf("InsertAfter: %v", err)
+	}
+	if err := handler.Replace("unknown", traceWrapper("x")); err == nil {
+		t.Fatalf("expect an error replacing an unknown wrapper")
+	}
+
+	desc := handler.Describe()
+	if !reflect.DeepEqual(desc.Wrappers, []string{"auth", "traceWrapper", "limit"}) {
+		t.Fatalf("unexpected wrappers %v", desc.Wrappers)
+	}
+
+	trace := serveTrace(t, handler)
+	if !reflect.DeepEqual(trace, []string{"auth2", "audit", "limit"}) {
+		t.Fatalf("unexpected trace %v", trace)
+	}
+}
+
+func TestRouteGroupWrappers(t *testing.T) {
+	handler := &Handler{Name: "test", OnError: LogError, OnPanic: LogPanic}
+	handler.Use(traceWrapper("handler"))
+
+	router := NewRouter()
+	group := router.Group("/v1")
+	group.Use(traceWrapper("group"))
+	group.Handle(http.MethodGet, "/a", handler)
+	router.Handle(http.MethodGet, "/a", handler)
+
+	routes := router.Routes()
+	if len(routes) != 2 {
+		t.Fatalf("expect 2 routes, got %d", len(routes))
+	}
+	for _, route := range routes {
+		expect := []string{"handler"}
+		if strings.HasPrefix(route.Pattern, "/v1") {
+			expect = []string{"group", "handler"}
+		}
+		if trace := serveTrace(t, route.Handler); !reflect.DeepEqual(trace, expect) {
+			t.Fatalf("%s: expect trace %v, got %v", route.Pattern, expect, trace)
+		}
+	}
+}
+
+func TestDefaultWrapperNames(t *testing.T) {
+	handler := DefaultWsHandler("test", nil)
+	handler.UseFirst(WithRecording(t.TempDir()))
+
+	desc := handler.Describe()
+	expect := []string{"WithRecording", "WithRequestID", "WithWebsocket", "WithReplyWsError", "WithGrpc"}
+	if !reflect.DeepEqual(desc.Wrappers, expect) {
+		t.Fatalf("expect wrappers %v, got %v", expect, desc.Wrappers)
+	}
+
+	if err := handler.InsertBefore("WithWebsocket", traceWrapper("auth")); err != nil {
+		t.Fatalf("InsertBefore: %v", err)
+	}
+	if err := handler.Replace("WithRecording", WithRecordingConfig(RecordingConfig{Dir: t.TempDir()})); err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+	expect = []string{"WithRecording", "WithRequestID", "traceWrapper", "WithWebsocket", "WithReplyWsError", "WithGrpc"}
+	if desc := handler.Describe(); !reflect.DeepEqual(desc.Wrappers, expect) {
+		t.Fatalf("expect wrappers %v, got %v", expect, desc.Wrappers)
+	}
+}
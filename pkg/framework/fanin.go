@@ -0,0 +1,134 @@
+package framework
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+)
+
+// FanInSource is one server stream multiplexed onto the websocket by FanIn
+type FanInSource struct {
+	Name string
+	// Open starts the server streaming call, ctx is canceled once FanIn returns
+	Open func(ctx context.Context, sess *Session) (grpc.ClientStream, error)
+	// NewMsg returns the message receiving one streamed response
+	NewMsg func() interface{}
+	// Render converts a streamed response into the data sent to the client,
+	// the response is sent as is if nil
+	Render func(msg interface{}) (interface{}, error)
+}
+
+// FanInSummary is the data of the final TypeSummary message of FanIn
+type FanInSummary struct {
+	Sources map[string]*FanInSourceSummary `json:"sources"`
+}
+
+type FanInSourceSummary struct {
+	Messages int64  `json:"messages"`
+	Error    string `json:"error,omitempty"`
+}
+
+// FanIn returns an Action subscribing to several server streams and sending
+// their messages to the websocket as they come, tagged with the source name
+// and a per source sequence number starting at 1. Source names must be unique.
+// A summary is sent when all sources finished or one failed, the others are
+// canceled in the latter case
+func FanIn(sources ...*FanInSource) Action {
+	return func(sess *Session) error {
+		summary := &FanInSummary{
+			Sources: make(map[string]*FanInSourceSummary, len(sources)),
+		}
+		for _, source := range sources {
+			if _, ok := summary.Sources[source.Name]; ok {
+				sess.Errorf("FanIn: duplicated source name '%s'", source.Name)
+				return fmt.Errorf("FanIn: duplicated source name '%s'", source.Name)
+			}
+			summary.Sources[source.Name] = new(FanInSourceSummary)
+		}
+
+		ctx, cancel := context.WithCancel(sess.Ctx)
+		defer cancel()
+
+		var (
+			wg       sync.WaitGroup
+			mu       sync.Mutex
+			firstErr error
+		)
+		for _, source := range sources {
+			source := source
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				ss := summary.Sources[source.Name]
+				err := fanInReceive(ctx, sess, source, ss)
+				if err == nil {
+					return
+				}
+
+				sess.Errorf("FanIn: source '%s' failed: %v", source.Name, err)
+				mu.Lock()
+				ss.Error = err.Error()
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+
+		err := sendWs(sess, &WsResponse{
+			Type:      TypeSummary,
+			RequestID: sess.RequestID,
+			Data:      summary,
+		})
+		if err != nil {
+			sess.Errorf("FanIn: fail to send summary: %v", err)
+			return err
+		}
+
+		return firstErr
+	}
+}
+
+func fanInReceive(ctx context.Context, sess *Session, source *FanInSource, ss *FanInSourceSummary) error {
+	stream, err := source.Open(ctx, sess)
+	if err != nil {
+		return err
+	}
+
+	for {
+		msg := source.NewMsg()
+		err = stream.RecvMsg(msg)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var data interface{} = msg
+		if source.Render != nil {
+			data, err = source.Render(msg)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = sendWs(sess, &WsResponse{
+			Type:      TypeSuccess,
+			RequestID: sess.RequestID,
+			Data:      data,
+			Source:    source.Name,
+			Seq:       atomic.AddInt64(&ss.Messages, 1),
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
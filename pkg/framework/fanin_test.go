@@ -0,0 +1,98 @@
+package framework
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+// countStream is a server stream of n strings
+type countStream struct {
+	grpc.ClientStream
+	n int
+}
+
+func (p *countStream) RecvMsg(m interface{}) error {
+	if p.n == 0 {
+		return io.EOF
+	}
+	p.n--
+	*m.(*string) = "msg"
+
+	return nil
+}
+
+func countSource(name string, n int) *FanInSource {
+	return &FanInSource{
+		Name: name,
+		Open: func(ctx context.Context, sess *Session) (grpc.ClientStream, error) {
+			return &countStream{n: n}, nil
+		},
+		NewMsg: func() interface{} { return new(string) },
+	}
+}
+
+func TestFanIn(t *testing.T) {
+	conn := dialWs(t, DefaultWsConfig, FanIn(countSource("a", 20), countSource("b", 30)))
+
+	seqs := map[string]int64{}
+	for {
+		var resp WsResponse
+		if err := conn.ReadJSON(&resp); err != nil {
+			t.Fatalf("fail to read: %v", err)
+		}
+		if resp.Type == TypeSummary {
+			break
+		}
+		if resp.Seq != seqs[resp.Source]+1 {
+			t.Fatalf("source %s: expect seq %d, got %d", resp.Source, seqs[resp.Source]+1, resp.Seq)
+		}
+		seqs[resp.Source] = resp.Seq
+	}
+
+	if seqs["a"] != 20 || seqs["b"] != 30 {
+		t.Fatalf("unexpected messages by source %v", seqs)
+	}
+}
+
+func TestFanInDuplicatedSources(t *testing.T) {
+	conn := dialWs(t, DefaultWsConfig, FanIn(countSource("a", 1), countSource("a", 1)))
+
+	var resp WsResponse
+	if err := conn.ReadJSON(&resp); err != nil || resp.Type != TypeError {
+		t.Fatalf("expect error, got %+v, %v", resp, err)
+	}
+}
+
+func TestFanInRenderError(t *testing.T) {
+	bad := countSource("bad", 5)
+	bad.Render = func(interface{}) (interface{}, error) { return nil, errors.New("reply without point") }
+	conn := dialWs(t, DefaultWsConfig, FanIn(countSource("a", 3), bad))
+
+	for {
+		var resp WsResponse
+		if err := conn.ReadJSON(&resp); err != nil {
+			t.Fatalf("fail to read: %v", err)
+		}
+		if resp.Source == "bad" {
+			t.Fatalf("expect no message of the failed source")
+		}
+		if resp.Type != TypeSummary {
+			continue
+		}
+
+		sources, _ := resp.Data.(map[string]interface{})["sources"].(map[string]interface{})
+		if bad, _ := sources["bad"].(map[string]interface{}); bad == nil || bad["error"] != "reply without point" {
+			t.Fatalf("expect the error of bad in the summary, got %v", resp.Data)
+		}
+		break
+	}
+
+	var resp WsResponse
+	if err := conn.ReadJSON(&resp); err != nil || resp.Type != TypeError {
+		t.Fatalf("expect the error of bad, got %+v, %v", resp, err)
+	}
+}
@@ -0,0 +1,222 @@
+package framework
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"google.golang.org/grpc"
+)
+
+// FanOutPolicy decides what FanOut does when one backend fails
+type FanOutPolicy int
+
+const (
+	// FanOutAbortAll fails the whole stream on the first backend error
+	FanOutAbortAll FanOutPolicy = iota
+	// FanOutDegraded stops feeding the failed backend and goes on with the
+	// others, the stream fails only when all backends failed
+	FanOutDegraded
+)
+
+// FanOutTarget is one backend stream fed by FanOut
+type FanOutTarget struct {
+	Name   string
+	Stream grpc.ClientStream
+
+	// Transform converts an incoming frame into the request message of the backend
+	Transform func(data []byte) (interface{}, error)
+	// NewReply returns the message receiving the final response of the backend
+	NewReply func() interface{}
+}
+
+// FanOutResult is the final response of one backend, Err is set if it failed
+type FanOutResult struct {
+	Name  string
+	Reply interface{}
+	Err   error
+}
+
+type FanOutConfig struct {
+	// Open opens the backend streams, ctx is canceled once FanOut returns
+	Open   func(ctx context.Context, sess *Session) ([]*FanOutTarget, error)
+	Policy FanOutPolicy
+	// Merge merges the final responses into the data of a single WsResponse,
+	// MergeByName if nil
+	Merge func(sess *Session, results []*FanOutResult) (interface{}, error)
+	// BufferSize is the number of frames queued per backend
+	BufferSize int
+}
+
+// MergeByName merges the final responses into a map keyed by backend name,
+// failed backends are reported as {"error": message}
+func MergeByName(sess *Session, results []*FanOutResult) (interface{}, error) {
+	ret := make(map[string]interface{}, len(results))
+	for _, result := range results {
+		if result.Err != nil {
+			ret[result.Name] = map[string]string{"error": result.Err.Error()}
+			continue
+		}
+		ret[result.Name] = result.Reply
+	}
+
+	return ret, nil
+}
+
+type fanOutWorker struct {
+	sess   *Session
+	target *FanOutTarget
+	frames chan []byte
+	done   chan struct{} // closed when the worker fails
+
+	result FanOutResult
+}
+
+func (p *fanOutWorker) run(wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for data := range p.frames {
+		msg, err := p.target.Transform(data)
+		if err != nil {
+			p.fail(fmt.Errorf("transform: %v", err))
+			return
+		}
+
+		err = p.target.Stream.SendMsg(msg)
+		if err == io.EOF {
+			// the backend ended the stream, the real error comes with RecvMsg
+			err = p.target.Stream.RecvMsg(p.target.NewReply())
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+		}
+		if err != nil {
+			p.fail(err)
+			return
+		}
+	}
+
+	err := p.target.Stream.CloseSend()
+	if err != nil {
+		p.fail(err)
+		return
+	}
+
+	reply := p.target.NewReply()
+	err = p.target.Stream.RecvMsg(reply)
+	if err != nil {
+		p.fail(err)
+		return
+	}
+	p.result.Reply = reply
+}
+
+func (p *fanOutWorker) fail(err error) {
+	p.sess.Errorf("FanOut: backend '%s' failed: %v", p.target.Name, err)
+	p.result.Err = err
+	close(p.done)
+}
+
+func (p *fanOutWorker) failed() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// FanOut returns an Action forwarding each incoming frame to several backend
+// streams concurrently, and replying the merged final responses once the
+// stream ends
+func FanOut(cfg FanOutConfig) Action {
+	return func(sess *Session) error {
+		ctx, cancel := context.WithCancel(sess.Ctx)
+		defer cancel()
+
+		targets, err := cfg.Open(ctx, sess)
+		if err != nil {
+			sess.Errorf("FanOut: fail to open backend streams: %v", err)
+			return err
+		}
+
+		var wg sync.WaitGroup
+		workers := make([]*fanOutWorker, len(targets))
+		for i, target := range targets {
+			workers[i] = &fanOutWorker{
+				sess:   sess,
+				target: target,
+				frames: make(chan []byte, cfg.BufferSize),
+				done:   make(chan struct{}),
+				result: FanOutResult{Name: target.Name},
+			}
+			wg.Add(1)
+			go workers[i].run(&wg)
+		}
+
+		// firstError applies the policy to the failed workers
+		firstError := func() error {
+			var err error
+			n := 0
+			for _, w := range workers {
+				if w.failed() {
+					n++
+					if err == nil {
+						err = w.result.Err
+					}
+				}
+			}
+			if cfg.Policy == FanOutDegraded && n < len(workers) {
+				return nil
+			}
+
+			return err
+		}
+
+		err = StreamForeach(sess, func(data []byte) error {
+			for _, w := range workers {
+				select {
+				case w.frames <- data:
+				case <-w.done:
+				}
+			}
+
+			return firstError()
+		})
+		if err != nil {
+			// abort the backend streams
+			cancel()
+		}
+		for _, w := range workers {
+			close(w.frames)
+		}
+		wg.Wait()
+
+		if err != nil {
+			return err
+		}
+
+		err = firstError()
+		if err != nil {
+			return err
+		}
+
+		results := make([]*FanOutResult, len(workers))
+		for i, w := range workers {
+			results[i] = &w.result
+		}
+
+		merge := cfg.Merge
+		if merge == nil {
+			merge = MergeByName
+		}
+		data, err := merge(sess, results)
+		if err != nil {
+			sess.Errorf("FanOut: fail to merge responses: %v", err)
+			return err
+		}
+
+		return SendWsResult(sess, data)
+	}
+}
@@ -0,0 +1,152 @@
+package framework_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"tinker/mock/pb/hello"
+	"tinker/pkg/framework"
+	"tinker/pkg/framework/frameworktest"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// recordTargets opens the Record stream of every connection of the session,
+// the frames are sent as points named after the backend
+func recordTargets(ctx context.Context, sess *framework.Session) ([]*framework.FanOutTarget, error) {
+	ret := make([]*framework.FanOutTarget, len(sess.GrpcConns))
+	for i, conn := range sess.GrpcConns {
+		stream, err := hello.NewStreamServiceClient(conn).Record(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		name := fmt.Sprintf("b%d", i)
+		ret[i] = &framework.FanOutTarget{
+			Name:   name,
+			Stream: stream,
+			Transform: func(data []byte) (interface{}, error) {
+				if string(data) == "bad" {
+					return nil, errors.New("bad frame")
+				}
+				return &hello.StreamRequest{Pt: &hello.StreamPoint{Name: name, Value: data}}, nil
+			},
+			NewReply: func() interface{} { return new(hello.StreamResponse) },
+		}
+	}
+
+	return ret, nil
+}
+
+// serveFanOut serves FanOut of cfg to the Record streams of servers
+func serveFanOut(t *testing.T, cfg framework.FanOutConfig, servers ...*frameworktest.HelloServer) *frameworktest.WsClient {
+	t.Helper()
+
+	conns := make([]*grpc.ClientConn, len(servers))
+	for i, srv := range servers {
+		conns[i] = frameworktest.HelloConn(t, srv)
+	}
+	cfg.Open = recordTargets
+	cfg.BufferSize = 4
+
+	return frameworktest.ServeWs(t, frameworktest.WsHandler(conns, framework.FanOut(cfg)), nil)
+}
+
+func TestFanOutMerge(t *testing.T) {
+	servers := []*frameworktest.HelloServer{new(frameworktest.HelloServer), new(frameworktest.HelloServer), new(frameworktest.HelloServer)}
+	client := serveFanOut(t, framework.FanOutConfig{}, servers...)
+	client.Send([]byte("hello"))
+	client.Send([]byte("world"))
+	client.SendEOS()
+
+	var result map[string]struct {
+		Pt struct{ Name string }
+	}
+	client.ExpectSuccess().Decode(t, &result)
+	client.ExpectClosed(websocket.CloseNormalClosure)
+
+	if len(result) != 3 {
+		t.Fatalf("expect the replies of 3 backends, got %v", result)
+	}
+	for i, srv := range servers {
+		name := fmt.Sprintf("b%d", i)
+		if result[name].Pt.Name != "gRPC Stream Server: Record" {
+			t.Fatalf("unexpected reply of %s: %+v", name, result[name])
+		}
+
+		// each backend gets every frame through its own transform
+		received := srv.Received()
+		if len(received) != 2 || received[0].GetPt().GetName() != name || string(received[1].GetPt().GetValue()) != "world" {
+			t.Fatalf("unexpected requests of %s: %v", name, received)
+		}
+	}
+}
+
+func TestFanOutCustomMerge(t *testing.T) {
+	merge := func(sess *framework.Session, results []*framework.FanOutResult) (interface{}, error) {
+		var names []string
+		for _, result := range results {
+			names = append(names, result.Name)
+		}
+		return names, nil
+	}
+	client := serveFanOut(t, framework.FanOutConfig{Merge: merge}, new(frameworktest.HelloServer), new(frameworktest.HelloServer))
+	client.SendEOS()
+
+	var names []string
+	client.ExpectSuccess().Decode(t, &names)
+	if len(names) != 2 || names[0] != "b0" || names[1] != "b1" {
+		t.Fatalf("expect the results in the order of the targets, got %v", names)
+	}
+}
+
+func TestFanOutDegraded(t *testing.T) {
+	failed := &frameworktest.HelloServer{Err: status.Error(codes.Unavailable, "down")}
+	client := serveFanOut(t, framework.FanOutConfig{Policy: framework.FanOutDegraded}, new(frameworktest.HelloServer), failed)
+	client.Send([]byte("hello"))
+	client.SendEOS()
+
+	var result map[string]map[string]interface{}
+	client.ExpectSuccess().Decode(t, &result)
+	if result["b0"]["pt"] == nil {
+		t.Fatalf("expect the reply of b0, got %v", result)
+	}
+	if msg, _ := result["b1"]["error"].(string); msg == "" {
+		t.Fatalf("expect the error of b1, got %v", result)
+	}
+}
+
+func TestFanOutDegradedAllFailed(t *testing.T) {
+	failed := &frameworktest.HelloServer{Err: status.Error(codes.Unavailable, "down")}
+	client := serveFanOut(t, framework.FanOutConfig{Policy: framework.FanOutDegraded}, failed, failed)
+	client.Send([]byte("hello"))
+	client.SendEOS()
+
+	client.Expect(framework.TypeError)
+}
+
+func TestFanOutAbortAll(t *testing.T) {
+	failed := &frameworktest.HelloServer{Err: status.Error(codes.Unavailable, "down")}
+	client := serveFanOut(t, framework.FanOutConfig{Policy: framework.FanOutAbortAll}, new(frameworktest.HelloServer), failed)
+	client.Send([]byte("hello"))
+	client.SendEOS()
+
+	client.Expect(framework.TypeError)
+}
+
+func TestFanOutTransformError(t *testing.T) {
+	srv := new(frameworktest.HelloServer)
+	client := serveFanOut(t, framework.FanOutConfig{Policy: framework.FanOutAbortAll}, srv)
+	client.Send([]byte("bad"))
+	client.SendEOS()
+
+	client.Expect(framework.TypeError)
+	if n := len(srv.Received()); n != 0 {
+		t.Fatalf("expect no request forwarded, got %d", n)
+	}
+}
@@ -0,0 +1,220 @@
+package framework
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// EnvFaultInjection must be set to 1 for WithFaultInjection to inject any fault,
+// a guard against enabling it in production by mistake
+const EnvFaultInjection = "TINKER_FAULT_INJECTION"
+
+// FaultInjectionAllowed tells whether EnvFaultInjection is set
+func FaultInjectionAllowed() bool {
+	return os.Getenv(EnvFaultInjection) == "1"
+}
+
+// Fault injection request headers, honored when FaultConfig.Headers is set.
+// They override the config for the session:
+//   - X-Tinker-Fault-Latency: delay before the session is handled, e.g. "500ms"
+//   - X-Tinker-Fault-Abort: abort with this http status or WsError code
+//   - X-Tinker-Fault-Drop: rate of websocket data frames dropped, e.g. "0.1"
+//   - X-Tinker-Fault-Close-After: close the connection after that many frames
+const (
+	HeaderFaultLatency    = "X-Tinker-Fault-Latency"
+	HeaderFaultAbort      = "X-Tinker-Fault-Abort"
+	HeaderFaultDrop       = "X-Tinker-Fault-Drop"
+	HeaderFaultCloseAfter = "X-Tinker-Fault-Close-After"
+)
+
+// FaultConfig configures WithFaultInjection
+type FaultConfig struct {
+	// Headers lets clients inject faults with the X-Tinker-Fault-* headers,
+	// never set it in production
+	Headers bool
+
+	// Latency is waited before the session is handled
+	Latency time.Duration
+	// AbortRate of the sessions, 0 to 1, fail with AbortHttpCode for http
+	// sessions or AbortWsCode for websocket sessions
+	AbortRate     float64
+	AbortHttpCode int
+	AbortWsCode   int
+	// DropRate of the websocket data frames read, 0 to 1, are dropped
+	DropRate float64
+	// CloseAfter closes the connection without close handshake after that many
+	// websocket data frames were read. An http session is closed without response
+	CloseAfter int
+}
+
+var DefaultFaultConfig = FaultConfig{
+	AbortHttpCode: http.StatusServiceUnavailable,
+	AbortWsCode:   CodeServerError,
+}
+
+// Enabled tells whether the config injects any fault
+func (p *FaultConfig) Enabled() bool {
+	return p.Headers || p.Latency > 0 || p.AbortRate > 0 || p.DropRate > 0 || p.CloseAfter > 0
+}
+
+// faultState is the fault injection of a websocket session, applied by readFrame
+// to the data frames, control frames are left alone
+type faultState struct {
+	dropRate   float64
+	closeAfter int
+	frames     int
+}
+
+// frame tells whether a frame read must be dropped, or closes the connection
+func (p *faultState) frame(sess *Session, frame *Frame) (bool, error) {
+	if frame.Kind != FrameData {
+		return false, nil
+	}
+
+	p.frames++
+	if p.closeAfter > 0 && p.frames > p.closeAfter {
+		sess.Warningf("WithFaultInjection: close connection after %d frames", p.closeAfter)
+		sess.WsConn.UnderlyingConn().Close()
+		return false, fmt.Errorf("fault injection: connection closed after %d frames", p.closeAfter)
+	}
+
+	if rand.Float64() < p.dropRate {
+		sess.Infof("WithFaultInjection: drop frame of %d bytes", len(frame.Data))
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// fromHeaders overrides cfg with the fault injection headers
+func (p FaultConfig) fromHeaders(header http.Header) (FaultConfig, error) {
+	if v := header.Get(HeaderFaultLatency); v != "" {
+		latency, err := time.ParseDuration(v)
+		if err != nil {
+			return p, fmt.Errorf("invalid %s: %v", HeaderFaultLatency, err)
+		}
+		p.Latency = latency
+	}
+
+	if v := header.Get(HeaderFaultAbort); v != "" {
+		code, err := strconv.Atoi(v)
+		if err != nil {
+			return p, fmt.Errorf("invalid %s: %v", HeaderFaultAbort, err)
+		}
+		p.AbortRate = 1
+		p.AbortHttpCode = code
+		p.AbortWsCode = code
+	}
+
+	if v := header.Get(HeaderFaultDrop); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return p, fmt.Errorf("invalid %s: %v", HeaderFaultDrop, err)
+		}
+		p.DropRate = rate
+	}
+
+	if v := header.Get(HeaderFaultCloseAfter); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return p, fmt.Errorf("invalid %s: %v", HeaderFaultCloseAfter, err)
+		}
+		p.CloseAfter = n
+	}
+
+	return p, nil
+}
+
+// WithFaultInjection returns a Wrapper injecting the faults of cfg, and of the
+// request headers if cfg.Headers is set, to chaos test clients.
+// It should be installed after WithWebsocket and the error reply wrappers so
+// that aborts are replied like any other error. Grpc calls get the latency and
+// aborts, their headers are read from the metadata. Nothing is injected unless
+// EnvFaultInjection is set
+func WithFaultInjection(cfg FaultConfig) Wrapper {
+	if !FaultInjectionAllowed() {
+		glog.Warningf("WithFaultInjection: disabled, set %s=1 to inject faults", EnvFaultInjection)
+		return func(sess *Session, action Action) error {
+			return action(sess)
+		}
+	}
+
+	return func(sess *Session, action Action) error {
+		cfg := cfg
+		var err error
+		if cfg.Headers {
+			header := grpcHeader(sess)
+			if sess.Request != nil {
+				header = sess.Request.Header
+			}
+			cfg, err = cfg.fromHeaders(header)
+			if err != nil {
+				sess.Errorf("WithFaultInjection: %v", err)
+				if sess.WsConn != nil {
+					return WsErrorClient.WithMessage(err.Error())
+				}
+				return HttpErrorBadRequest.WithMessage(err.Error())
+			}
+		}
+
+		if cfg.Latency > 0 {
+			sess.Infof("WithFaultInjection: delay session by %s", cfg.Latency)
+			time.Sleep(cfg.Latency)
+		}
+
+		if cfg.AbortRate > 0 && rand.Float64() < cfg.AbortRate {
+			sess.Warningf("WithFaultInjection: abort session")
+			if sess.WsConn != nil {
+				code := cfg.AbortWsCode
+				if code == 0 {
+					code = DefaultFaultConfig.AbortWsCode
+				}
+				return NewWsError(code, "fault injection")
+			}
+
+			code := cfg.AbortHttpCode
+			if code == 0 {
+				code = DefaultFaultConfig.AbortHttpCode
+			}
+			return NewHttpError(code, "fault injection")
+		}
+
+		if sess.WsConn == nil {
+			if cfg.CloseAfter > 0 && sess.ResponseWriter != nil {
+				return closeHttp(sess)
+			}
+			return action(sess)
+		}
+
+		if cfg.DropRate > 0 || cfg.CloseAfter > 0 {
+			sess.fault = &faultState{
+				dropRate:   cfg.DropRate,
+				closeAfter: cfg.CloseAfter,
+			}
+		}
+
+		return action(sess)
+	}
+}
+
+// closeHttp closes the connection of an http session without response
+func closeHttp(sess *Session) error {
+	hijacker, ok := sess.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return fmt.Errorf("expected http.ResponseWriter to be an http.Hijacker")
+	}
+
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		return err
+	}
+	sess.Warningf("WithFaultInjection: close connection without response")
+
+	return conn.Close()
+}
@@ -0,0 +1,51 @@
+package framework
+
+import (
+	"os"
+	"testing"
+)
+
+func TestFaultInjectionGate(t *testing.T) {
+	cfg := DefaultFaultConfig
+	cfg.AbortRate = 1
+	action := func(sess *Session) error { return nil }
+
+	os.Unsetenv(EnvFaultInjection)
+	if err := WithFaultInjection(cfg)(new(Session), action); err != nil {
+		t.Fatalf("expect no fault without %s, got %v", EnvFaultInjection, err)
+	}
+
+	os.Setenv(EnvFaultInjection, "1")
+	defer os.Unsetenv(EnvFaultInjection)
+	err := WithFaultInjection(cfg)(new(Session), action)
+	if herr, ok := err.(*HttpError); !ok || herr.StatusCode != cfg.AbortHttpCode {
+		t.Fatalf("expect http error %d, got %v", cfg.AbortHttpCode, err)
+	}
+}
+
+func TestFaultStateSkipsControlFrames(t *testing.T) {
+	conn, _ := wsPair(t)
+	sess := &Session{WsConn: conn}
+	fault := &faultState{closeAfter: 2}
+
+	frames := []struct {
+		frame *Frame
+		close bool
+	}{
+		{&Frame{Kind: FrameControl, Control: &ControlMessage{Type: "ping"}}, false},
+		{&Frame{Kind: FrameData, Data: []byte("1")}, false},
+		{&Frame{Kind: FrameControl, Control: &ControlMessage{Type: "ping"}}, false},
+		{&Frame{Kind: FrameData, Data: []byte("2")}, false},
+		{&Frame{Kind: FrameControl, Control: &ControlMessage{Type: "ping"}}, false},
+		{&Frame{Kind: FrameData, Data: []byte("3")}, true},
+	}
+	for i, f := range frames {
+		drop, err := fault.frame(sess, f.frame)
+		if drop {
+			t.Fatalf("frame %d dropped", i)
+		}
+		if (err != nil) != f.close {
+			t.Fatalf("frame %d: expect close %v, got %v", i, f.close, err)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package frameworktest_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"tinker/pkg/framework"
+	"tinker/pkg/framework/frameworktest"
+)
+
+func echo(sess *framework.Session) error {
+	return framework.SendHttpResult(sess, map[string]string{
+		"saying": sess.Request.URL.Query().Get("saying"),
+	})
+}
+
+func ExampleServeHttp() {
+	handler := frameworktest.HttpHandler(nil, echo)
+
+	rec := frameworktest.ServeHttp(handler, httptest.NewRequest(http.MethodGet, "/?saying=hi", nil))
+	fmt.Println(rec.Code, rec.Body.String())
+	// Output: 200 {"saying":"hi"}
+}
+
+func ExampleNewSession() {
+	sess, rec := frameworktest.NewSession().
+		Request(http.MethodGet, "/?saying=hello", nil).
+		Build()
+
+	err := echo(sess)
+	fmt.Println(err, rec.Code, rec.Body.String())
+	// Output: <nil> 200 {"saying":"hello"}
+}
@@ -0,0 +1,50 @@
+package frameworktest
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// grpc messages are limited to 16m, the services of tinker send up to 7m
+const maxMsgSize = 16 * 1024 * 1024
+
+// GrpcConn serves the services registered by register on an in-memory
+// listener and returns a connection to it. The server and the connection are
+// closed at the end of the test
+func GrpcConn(t testing.TB, register func(*grpc.Server)) *grpc.ClientConn {
+	t.Helper()
+
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer(grpc.MaxRecvMsgSize(maxMsgSize), grpc.MaxSendMsgSize(maxMsgSize))
+	register(srv)
+	go srv.Serve(lis)
+
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxMsgSize), grpc.MaxCallSendMsgSize(maxMsgSize)),
+	)
+	if err != nil {
+		srv.Stop()
+		t.Fatalf("GrpcConn: fail to dial: %v", err)
+	}
+
+	t.Cleanup(func() {
+		conn.Close()
+		srv.Stop()
+	})
+
+	return conn
+}
+
+// HelloConn returns a connection to srv, see GrpcConn
+func HelloConn(t testing.TB, srv *HelloServer) *grpc.ClientConn {
+	t.Helper()
+	return GrpcConn(t, srv.Register)
+}
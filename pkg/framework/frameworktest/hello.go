@@ -0,0 +1,139 @@
+package frameworktest
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	hello "tinker/mock/pb/hello"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// HelloServer implements the hello services like mock/server, without
+// payloads by default, and keeps the stream requests it receives
+type HelloServer struct {
+	hello.UnimplementedGreetingServer
+	hello.UnimplementedStreamServiceServer
+
+	// ListMessages is the number of messages sent by List
+	ListMessages int
+	// PayloadSize of the messages sent by List and Route
+	PayloadSize int
+	// Err fails every call when set
+	Err error
+	// Trailer is sent by every call
+	Trailer metadata.MD
+
+	mu       sync.Mutex
+	received []*hello.StreamRequest
+}
+
+// Register registers the Greeting and StreamService services
+func (p *HelloServer) Register(srv *grpc.Server) {
+	hello.RegisterGreetingServer(srv, p)
+	hello.RegisterStreamServiceServer(srv, p)
+}
+
+// Received returns the requests received by the streams
+func (p *HelloServer) Received() []*hello.StreamRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]*hello.StreamRequest(nil), p.received...)
+}
+
+func (p *HelloServer) receive(r *hello.StreamRequest) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.received = append(p.received, r)
+}
+
+func (p *HelloServer) Greet(ctx context.Context, request *hello.GreetRequest) (*hello.GreetResponse, error) {
+	grpc.SetTrailer(ctx, p.Trailer)
+	if p.Err != nil {
+		return nil, p.Err
+	}
+
+	return &hello.GreetResponse{
+		Acking: "Hi " + hello.Name_name[int32(request.GetPerson())],
+		Name:   hello.Name_Robot,
+		Time:   timestamppb.Now(),
+	}, nil
+}
+
+func (p *HelloServer) List(r *hello.StreamRequest, stream hello.StreamService_ListServer) error {
+	stream.SetTrailer(p.Trailer)
+	if p.Err != nil {
+		return p.Err
+	}
+
+	for n := 0; n < p.ListMessages; n++ {
+		err := stream.Send(&hello.StreamResponse{
+			Pt: &hello.StreamPoint{
+				Name:  r.GetPt().GetName(),
+				Value: make([]byte, p.PayloadSize),
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *HelloServer) Record(stream hello.StreamService_RecordServer) error {
+	stream.SetTrailer(p.Trailer)
+	if p.Err != nil {
+		return p.Err
+	}
+
+	for {
+		r, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&hello.StreamResponse{
+				Pt: &hello.StreamPoint{
+					Name: "gRPC Stream Server: Record",
+				},
+			})
+		}
+		if err != nil {
+			return err
+		}
+
+		p.receive(r)
+	}
+}
+
+// Route answers each request
+func (p *HelloServer) Route(stream hello.StreamService_RouteServer) error {
+	stream.SetTrailer(p.Trailer)
+	if p.Err != nil {
+		return p.Err
+	}
+
+	for {
+		r, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		p.receive(r)
+
+		err = stream.Send(&hello.StreamResponse{
+			Pt: &hello.StreamPoint{
+				Name:  r.GetPt().GetName(),
+				Value: make([]byte, p.PayloadSize),
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
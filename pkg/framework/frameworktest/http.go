@@ -0,0 +1,46 @@
+package frameworktest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tinker/pkg/framework"
+)
+
+// ServeHttp serves req with handler and returns the recorded response
+func ServeHttp(handler http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	return rec
+}
+
+// ExpectHttp fails the test unless the response has status, and decodes the
+// JSON body into v if not nil
+func ExpectHttp(t testing.TB, rec *httptest.ResponseRecorder, status int, v interface{}) {
+	t.Helper()
+
+	if rec.Code != status {
+		t.Fatalf("ExpectHttp: expect status %d, got %d: %s", status, rec.Code, rec.Body.String())
+	}
+	if v == nil {
+		return
+	}
+
+	err := json.Unmarshal(rec.Body.Bytes(), v)
+	if err != nil {
+		t.Fatalf("ExpectHttp: fail to decode body %s: %v", rec.Body.String(), err)
+	}
+}
+
+// ExpectHttpError returns the message of an error response with status
+func ExpectHttpError(t testing.TB, rec *httptest.ResponseRecorder, status int) string {
+	t.Helper()
+
+	ret := new(framework.HttpError)
+	ExpectHttp(t, rec, status, ret)
+
+	return ret.Message
+}
@@ -0,0 +1,134 @@
+// Package frameworktest provides utilities to unit test Handlers, Actions and
+// Wrappers without listener, real websocket or grpc server, e.g.
+//
+//	func TestRecord(t *testing.T) {
+//		conn := frameworktest.HelloConn(t, new(frameworktest.HelloServer))
+//		ws := apiws.NewWebsocket()
+//		handler := frameworktest.WsHandler([]*grpc.ClientConn{conn}, ws.CreateClient, ws.SendStream, ws.ReceveResult)
+//
+//		client := frameworktest.ServeWs(t, handler, nil)
+//		client.Send([]byte("hello"))
+//		client.SendEOS()
+//		client.ExpectSuccess()
+//		client.ExpectClosed(websocket.CloseNormalClosure)
+//	}
+package frameworktest
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"tinker/pkg/framework"
+
+	"google.golang.org/grpc"
+)
+
+// SessionBuilder builds a Session to call Actions and Wrappers directly
+type SessionBuilder struct {
+	name      string
+	requestID string
+	req       *http.Request
+	conns     []*grpc.ClientConn
+	keys      map[string]interface{}
+}
+
+// NewSession returns a builder of a "GET /" session named "test"
+func NewSession() *SessionBuilder {
+	return &SessionBuilder{
+		name: "test",
+		req:  httptest.NewRequest(http.MethodGet, "/", nil),
+		keys: make(map[string]interface{}),
+	}
+}
+
+func (p *SessionBuilder) Name(name string) *SessionBuilder {
+	p.name = name
+	return p
+}
+
+func (p *SessionBuilder) RequestID(requestID string) *SessionBuilder {
+	p.requestID = requestID
+	return p
+}
+
+// Request replaces the request of the session, headers set before are kept
+func (p *SessionBuilder) Request(method, target string, body []byte) *SessionBuilder {
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	req.Header = p.req.Header
+	p.req = req
+	return p
+}
+
+func (p *SessionBuilder) Header(key, value string) *SessionBuilder {
+	p.req.Header.Set(key, value)
+	return p
+}
+
+// Grpc sets Session.GrpcConns, see GrpcConn and HelloConn
+func (p *SessionBuilder) Grpc(conns ...*grpc.ClientConn) *SessionBuilder {
+	p.conns = append(p.conns, conns...)
+	return p
+}
+
+// Set sets a Session key
+func (p *SessionBuilder) Set(key string, value interface{}) *SessionBuilder {
+	p.keys[key] = value
+	return p
+}
+
+// Build returns the session and the recorder of its http response
+func (p *SessionBuilder) Build() (*framework.Session, *httptest.ResponseRecorder) {
+	rec := httptest.NewRecorder()
+	sess := &framework.Session{
+		Name:           p.name,
+		ResponseWriter: rec,
+		Request:        p.req,
+		GrpcConns:      p.conns,
+		Ctx:            context.Background(),
+		RequestID:      p.requestID,
+		StartTime:      time.Now().UTC(),
+	}
+	for k, v := range p.keys {
+		sess.Set(k, v)
+	}
+
+	return sess, rec
+}
+
+// WithConns returns a Wrapper setting Session.GrpcConns to conns, it replaces
+// framework.WithGrpc in tests. The connections are not closed
+func WithConns(conns []*grpc.ClientConn) framework.Wrapper {
+	return func(sess *framework.Session, action framework.Action) error {
+		sess.GrpcConns = append(sess.GrpcConns, conns...)
+		return action(sess)
+	}
+}
+
+func newHandler(name string) *framework.Handler {
+	return &framework.Handler{
+		Name:    name,
+		OnError: framework.LogError,
+		OnPanic: framework.LogPanic,
+	}
+}
+
+// HttpHandler returns a Handler like framework.DefaultHttpHandler, with conns
+// instead of grpc addresses
+func HttpHandler(conns []*grpc.ClientConn, actions ...framework.Action) *framework.Handler {
+	ret := newHandler("test")
+	ret.Use(framework.WithRequestID(), framework.WithReplyHttpError(), WithConns(conns))
+	ret.Add(actions...)
+	return ret
+}
+
+// WsHandler returns a Handler like framework.DefaultWsHandler, with conns
+// instead of grpc addresses
+func WsHandler(conns []*grpc.ClientConn, actions ...framework.Action) *framework.Handler {
+	ret := newHandler("test")
+	ret.Use(framework.WithRequestID(), framework.WithWebsocket(), framework.WithReplyWsError(), WithConns(conns))
+	ret.Add(actions...)
+	return ret
+}
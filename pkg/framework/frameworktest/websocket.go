@@ -0,0 +1,271 @@
+package frameworktest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"tinker/pkg/framework"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// ReadTimeout bounds the wait for a websocket message
+var ReadTimeout = 10 * time.Second
+
+// WsResponse is a framework.WsResponse with its data left encoded
+type WsResponse struct {
+	Type      string          `json:"type"`
+	RequestID string          `json:"request_id"`
+	Data      json.RawMessage `json:"data"`
+	Source    string          `json:"source,omitempty"`
+	Seq       int64           `json:"seq,omitempty"`
+}
+
+// Decode decodes the data of the response into v
+func (p *WsResponse) Decode(t testing.TB, v interface{}) {
+	t.Helper()
+
+	err := json.Unmarshal(p.Data, v)
+	if err != nil {
+		t.Fatalf("WsResponse: fail to decode data %s: %v", p.Data, err)
+	}
+}
+
+// WsClient is the client side of an in-process websocket session
+type WsClient struct {
+	Conn *websocket.Conn
+
+	t testing.TB
+}
+
+// ServeWs serves handler on an in-process server and opens a websocket to it
+// with header. The server and the websocket are closed at the end of the test.
+// With the header "Sec-WebSocket-Protocol: tinker.proto.v1" the client speaks
+// framework.ProtoWsCodec, its responses are then decoded into WsResponse with
+// the payload in protojson
+func ServeWs(t testing.TB, handler http.Handler, header http.Header) *WsClient {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		srv.Close()
+		t.Fatalf("ServeWs: fail to dial %s: %v", url, err)
+	}
+
+	t.Cleanup(func() {
+		conn.Close()
+		srv.Close()
+	})
+
+	return &WsClient{Conn: conn, t: t}
+}
+
+func (p *WsClient) write(messageType int, data []byte) {
+	p.t.Helper()
+
+	err := p.Conn.WriteMessage(messageType, data)
+	if err != nil {
+		p.t.Fatalf("WsClient: fail to write: %v", err)
+	}
+}
+
+func (p *WsClient) proto() bool {
+	return p.Conn.Subprotocol() == framework.SubprotocolProto
+}
+
+// SendEnvelope sends an envelope of framework.ProtoWsCodec
+func (p *WsClient) SendEnvelope(envelope *framework.WsEnvelope) {
+	p.t.Helper()
+
+	data, err := envelope.Marshal()
+	if err != nil {
+		p.t.Fatalf("WsClient: fail to encode envelope: %v", err)
+	}
+	p.write(websocket.BinaryMessage, data)
+}
+
+// Send sends a binary frame, or a data envelope
+func (p *WsClient) Send(data []byte) {
+	p.t.Helper()
+
+	if p.proto() {
+		payload, err := anypb.New(wrapperspb.Bytes(data))
+		if err != nil {
+			p.t.Fatalf("WsClient: fail to pack data: %v", err)
+		}
+		p.SendEnvelope(&framework.WsEnvelope{Type: framework.TypeData, Payload: payload})
+		return
+	}
+
+	p.write(websocket.BinaryMessage, data)
+}
+
+// SendText sends a text frame
+func (p *WsClient) SendText(data string) {
+	p.t.Helper()
+	p.write(websocket.TextMessage, []byte(data))
+}
+
+// SendEOS ends the stream with framework.EOS, or an end envelope
+func (p *WsClient) SendEOS() {
+	p.t.Helper()
+
+	if p.proto() {
+		p.SendEnvelope(&framework.WsEnvelope{Type: framework.ControlEnd})
+		return
+	}
+
+	p.write(websocket.BinaryMessage, framework.EOS)
+}
+
+// SendControl sends a control message of the JSON control framing, or an
+// envelope with params as a google.protobuf.Value
+func (p *WsClient) SendControl(msgType string, params interface{}) {
+	p.t.Helper()
+
+	if p.proto() {
+		envelope := &framework.WsEnvelope{Type: msgType}
+		if params != nil {
+			js, err := json.Marshal(params)
+			if err != nil {
+				p.t.Fatalf("WsClient: fail to encode control params: %v", err)
+			}
+			value := new(structpb.Value)
+			err = protojson.Unmarshal(js, value)
+			if err != nil {
+				p.t.Fatalf("WsClient: fail to encode control params: %v", err)
+			}
+			envelope.Payload, err = anypb.New(value)
+			if err != nil {
+				p.t.Fatalf("WsClient: fail to pack control params: %v", err)
+			}
+		}
+		p.SendEnvelope(envelope)
+		return
+	}
+
+	data, err := json.Marshal(map[string]interface{}{"type": msgType, "params": params})
+	if err != nil {
+		p.t.Fatalf("WsClient: fail to encode control message: %v", err)
+	}
+	p.write(websocket.TextMessage, data)
+}
+
+// Close sends a normal close frame
+func (p *WsClient) Close() {
+	p.t.Helper()
+
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	err := p.Conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(ReadTimeout))
+	if err != nil {
+		p.t.Fatalf("WsClient: fail to close: %v", err)
+	}
+}
+
+// Read returns the next response
+func (p *WsClient) Read() *WsResponse {
+	p.t.Helper()
+
+	p.Conn.SetReadDeadline(time.Now().Add(ReadTimeout))
+	_, data, err := p.Conn.ReadMessage()
+	if err != nil {
+		p.t.Fatalf("WsClient: fail to read: %v", err)
+	}
+
+	if p.proto() {
+		return p.decodeEnvelope(data)
+	}
+
+	ret := new(WsResponse)
+	err = json.Unmarshal(data, ret)
+	if err != nil {
+		p.t.Fatalf("WsClient: invalid response %s: %v", data, err)
+	}
+
+	return ret
+}
+
+func (p *WsClient) decodeEnvelope(data []byte) *WsResponse {
+	p.t.Helper()
+
+	envelope, err := framework.UnmarshalWsEnvelope(data)
+	if err != nil {
+		p.t.Fatalf("WsClient: invalid envelope: %v", err)
+	}
+
+	ret := &WsResponse{
+		Type:      envelope.Type,
+		RequestID: envelope.RequestID,
+		Data:      json.RawMessage("null"),
+		Source:    envelope.Source,
+		Seq:       envelope.Sequence,
+	}
+	if envelope.Payload != nil {
+		msg, err := envelope.Payload.UnmarshalNew()
+		if err != nil {
+			p.t.Fatalf("WsClient: invalid envelope payload: %v", err)
+		}
+		ret.Data, err = protojson.Marshal(msg)
+		if err != nil {
+			p.t.Fatalf("WsClient: invalid envelope payload: %v", err)
+		}
+	}
+
+	return ret
+}
+
+// Expect returns the next response, failing the test unless it has type
+func (p *WsClient) Expect(msgType string) *WsResponse {
+	p.t.Helper()
+
+	ret := p.Read()
+	if ret.Type != msgType {
+		p.t.Fatalf("WsClient: expect %s response, got %s: %s", msgType, ret.Type, ret.Data)
+	}
+
+	return ret
+}
+
+// ExpectSuccess returns the next response, failing the test unless it succeeds
+func (p *WsClient) ExpectSuccess() *WsResponse {
+	p.t.Helper()
+	return p.Expect(framework.TypeSuccess)
+}
+
+// ExpectError returns the next error, failing the test unless it has code
+func (p *WsClient) ExpectError(code int) *framework.WsError {
+	p.t.Helper()
+
+	ret := new(framework.WsError)
+	p.Expect(framework.TypeError).Decode(p.t, ret)
+	if ret.Code != code {
+		p.t.Fatalf("WsClient: expect error %d, got %d: %s", code, ret.Code, ret.Message)
+	}
+
+	return ret
+}
+
+// ExpectClosed fails the test unless the server closes the websocket with
+// code, without sending other messages before
+func (p *WsClient) ExpectClosed(code int) {
+	p.t.Helper()
+
+	p.Conn.SetReadDeadline(time.Now().Add(ReadTimeout))
+	_, data, err := p.Conn.ReadMessage()
+	if err == nil {
+		p.t.Fatalf("WsClient: expect close %d, got message %s", code, data)
+	}
+	if !websocket.IsCloseError(err, code) {
+		p.t.Fatalf("WsClient: expect close %d, got %v", code, err)
+	}
+}
@@ -1,17 +1,21 @@
 package framework
 
 import (
+	"fmt"
+	"sync"
 	"time"
 
 	"google.golang.org/grpc"
 )
 
-// WithGrpc returns a Wrapper creating and releasing grpc connection.
+// WithGrpc returns a Wrapper creating and releasing grpc connection with
+// DefaultBackendCredentials.
 // The grpc connection is set in Session.GrpcConns
 func WithGrpc(targets []string) Wrapper {
 	return func(sess *Session, action Action) error {
 		for _, target := range targets {
-			grpcConn, err := grpc.Dial(target, grpc.WithInsecure(), grpc.WithBlock(), grpc.WithTimeout(time.Second*10))
+			creds := DefaultBackendCredentials.DialOption(target)
+			grpcConn, err := grpc.Dial(target, creds, grpc.WithBlock(), grpc.WithTimeout(time.Second*10))
 			if err != nil {
 				sess.Errorf("WithGrpc: fail to dial grpc endpoint '%s': %s", target, err.Error())
 				return err
@@ -28,3 +32,137 @@ func WithGrpc(targets []string) Wrapper {
 		return action(sess)
 	}
 }
+
+// GrpcPool shares one grpc connection per target between sessions.
+// Connections are dialed on first use without blocking, calls wait for the
+// connection to be ready
+type GrpcPool struct {
+	// Credentials of the targets, DefaultBackendCredentials by default
+	Credentials *BackendCredentials
+
+	opts []grpc.DialOption
+
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
+
+// NewGrpcPool returns a pool dialing with the credentials of each target and opts
+func NewGrpcPool(opts ...grpc.DialOption) *GrpcPool {
+	return &GrpcPool{
+		Credentials: DefaultBackendCredentials,
+		opts:        opts,
+		conns:       make(map[string]*grpc.ClientConn),
+	}
+}
+
+// Get returns the connection of target
+func (p *GrpcPool) Get(target string) (*grpc.ClientConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if conn, ok := p.conns[target]; ok {
+		return conn, nil
+	}
+
+	opts := append([]grpc.DialOption{p.Credentials.DialOption(target)}, p.opts...)
+	conn, err := grpc.Dial(target, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("fail to dial grpc endpoint '%s': %v", target, err)
+	}
+	p.conns[target] = conn
+
+	return conn, nil
+}
+
+// Close closes the connections of the pool
+func (p *GrpcPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var ret error
+	for target, conn := range p.conns {
+		if err := conn.Close(); err != nil && ret == nil {
+			ret = err
+		}
+		delete(p.conns, target)
+	}
+
+	return ret
+}
+
+// GrpcPoolDrainTimeout is the time left to the calls in flight on the
+// connections removed by GrpcPool.Retain before they are closed
+var GrpcPoolDrainTimeout = time.Minute
+
+// Retain removes the connections of the targets not in targets from the pool,
+// they are closed after GrpcPoolDrainTimeout. It returns the number removed
+func (p *GrpcPool) Retain(targets ...string) int {
+	keep := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		keep[target] = true
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var removed []*grpc.ClientConn
+	for target, conn := range p.conns {
+		if !keep[target] {
+			removed = append(removed, conn)
+			delete(p.conns, target)
+		}
+	}
+	if len(removed) > 0 {
+		time.AfterFunc(GrpcPoolDrainTimeout, func() {
+			for _, conn := range removed {
+				conn.Close()
+			}
+		})
+	}
+
+	return len(removed)
+}
+
+// WithGrpcPool returns a Wrapper setting the pooled connections of targets in
+// Session.GrpcConns, they are not closed with the session
+func WithGrpcPool(pool *GrpcPool, targets []string) Wrapper {
+	return func(sess *Session, action Action) error {
+		for _, target := range targets {
+			conn, err := pool.Get(target)
+			if err != nil {
+				sess.Errorf("WithGrpcPool: %v", err)
+				return err
+			}
+			sess.GrpcConns = append(sess.GrpcConns, conn)
+		}
+
+		return action(sess)
+	}
+}
+
+// rawCodec passes messages already encoded, so that calls can be proxied
+// without their types. It keeps the name of the proto codec on the wire
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	data, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("rawCodec: expect *[]byte, got %T", v)
+	}
+
+	return *data, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	dst, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("rawCodec: expect *[]byte, got %T", v)
+	}
+	*dst = append((*dst)[:0], data...)
+
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
@@ -0,0 +1,274 @@
+package framework
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// NewGrpcServer returns a grpc server serving every method with handler.
+// Messages are not decoded, actions receive and send them as *[]byte through
+// Session.GrpcStream
+func NewGrpcServer(handler *Handler, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.ForceServerCodec(rawCodec{}), grpc.UnknownServiceHandler(handler.ServeGrpc))
+	return grpc.NewServer(opts...)
+}
+
+// ServeGrpc serves a grpc call with the wrappers and actions of the handler,
+// it is a grpc.StreamHandler, see NewGrpcServer.
+// The session has no Request nor ResponseWriter, the call is in
+// Session.GrpcStream and its metadata in the incoming context Session.Ctx.
+// Errors are returned to the client as grpc status, HttpErrors with the grpc
+// code of their http status
+func (p *Handler) ServeGrpc(srv interface{}, stream grpc.ServerStream) (err error) {
+	sess := new(Session)
+	sess.Name = p.Name
+	sess.Ctx = stream.Context()
+	sess.GrpcStream = stream
+	sess.GrpcMethod, _ = grpc.MethodFromServerStream(stream)
+	sess.StartTime = time.Now().UTC()
+	sess.initKeys()
+
+	defer func() {
+		latency := time.Since(sess.StartTime).Seconds()
+		sess.Infof("requestId:%s method %s latency is %f", sess.RequestID, sess.GrpcMethod, latency)
+
+		if perr := recover(); perr != nil {
+			debug.PrintStack()
+			p.OnPanic(sess, perr)
+			err = status.Error(codes.Internal, "internal server error")
+			return
+		}
+
+		if err != nil {
+			p.OnError(sess, err)
+			err = errorStatus(err).Err()
+		}
+	}()
+
+	return p.mainAction()(sess)
+}
+
+// GrpcProxy forwards grpc calls of any method, unary or streaming, to a
+// backend group. Messages are passed as is, so the services need not be known
+type GrpcProxy struct {
+	// MaxMessageSize bounds the messages received from the backends
+	MaxMessageSize int
+
+	pool *GrpcPool
+	next uint64
+
+	mu       sync.RWMutex
+	backends map[string][]string
+}
+
+// NewGrpcProxy returns a proxy of the backend groups, see SetBackends
+func NewGrpcProxy(pool *GrpcPool, backends map[string][]string) *GrpcProxy {
+	return &GrpcProxy{
+		MaxMessageSize: 8 * 1024 * 1024,
+		pool:           pool,
+		backends:       backends,
+	}
+}
+
+// SetBackends replaces the backend groups, the calls in flight keep their
+// backend. backends maps the full name of a service to the targets of its
+// backend group, "*" to those of the other services. Calls are spread round
+// robin
+func (p *GrpcProxy) SetBackends(backends map[string][]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.backends = backends
+}
+
+// Handler returns the Handler of the proxy, to be served by NewGrpcServer.
+// Wrappers added to it apply to every call like to http sessions
+func (p *GrpcProxy) Handler() *Handler {
+	ret := &Handler{
+		Name:    "grpcProxy",
+		OnError: LogError,
+		OnPanic: LogPanic,
+	}
+	ret.Use(WithRequestID())
+	ret.Add(p.Forward)
+
+	return ret
+}
+
+// target picks the backend of the service of method, e.g. "/hello.Greeting/Greet"
+func (p *GrpcProxy) target(method string) (string, bool) {
+	service := strings.TrimPrefix(method, "/")
+	if i := strings.LastIndexByte(service, '/'); i >= 0 {
+		service = service[:i]
+	}
+
+	p.mu.RLock()
+	targets, ok := p.backends[service]
+	if !ok {
+		targets = p.backends["*"]
+	}
+	p.mu.RUnlock()
+	if len(targets) == 0 {
+		return "", false
+	}
+
+	n := atomic.AddUint64(&p.next, 1)
+	return targets[n%uint64(len(targets))], true
+}
+
+// Forward forwards the call of the session to its backend, messages of both
+// directions are copied until the backend ends the call
+func (p *GrpcProxy) Forward(sess *Session) error {
+	target, ok := p.target(sess.GrpcMethod)
+	if !ok {
+		return status.Errorf(codes.Unimplemented, "no backend for method %s", sess.GrpcMethod)
+	}
+
+	conn, err := p.pool.Get(target)
+	if err != nil {
+		sess.Errorf("Forward: %v", err)
+		return status.Error(codes.Unavailable, err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(sess.Ctx)
+	defer cancel()
+	ctx = metadata.NewOutgoingContext(ctx, forwardMetadata(sess))
+
+	sess.Infof("Forward: proxy %s to '%s'", sess.GrpcMethod, target)
+	desc := &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}
+	backend, err := conn.NewStream(ctx, desc, sess.GrpcMethod,
+		grpc.ForceCodec(rawCodec{}), grpc.MaxCallRecvMsgSize(p.MaxMessageSize))
+	if err != nil {
+		return err
+	}
+
+	// client to backend, a failure of the backend is reported by RecvMsg below.
+	// It is cancelled and waited for once the backend ends the call
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		forwardClient(ctx, sess, backend, cancel)
+	}()
+	defer func() {
+		cancel()
+		<-forwarded
+	}()
+
+	// backend to client
+	header, err := backend.Header()
+	if err == nil && len(header) > 0 {
+		err = sess.GrpcStream.SendHeader(header)
+		if err != nil {
+			return err
+		}
+	}
+	for {
+		var msg []byte
+		err = backend.RecvMsg(&msg)
+		if err != nil {
+			break
+		}
+
+		err = sess.GrpcStream.SendMsg(&msg)
+		if err != nil {
+			sess.Errorf("Forward: fail to send to client: %v", err)
+			return err
+		}
+	}
+	sess.GrpcStream.SetTrailer(backend.Trailer())
+
+	if err != io.EOF {
+		return err
+	}
+
+	return nil
+}
+
+// grpcHeader returns the metadata of the grpc call of the session as headers
+func grpcHeader(sess *Session) http.Header {
+	header := http.Header{}
+	if sess.Ctx == nil {
+		return header
+	}
+
+	md, _ := metadata.FromIncomingContext(sess.Ctx)
+	for key, values := range md {
+		if strings.HasPrefix(key, ":") {
+			continue
+		}
+		header[http.CanonicalHeaderKey(key)] = values
+	}
+
+	return header
+}
+
+// forwardClient copies the messages of the client of the session to backend
+// until the client closes its side or ctx is done
+func forwardClient(ctx context.Context, sess *Session, backend grpc.ClientStream, cancel context.CancelFunc) {
+	// RecvMsg can't be cancelled, the reader only touches the client stream and
+	// ends with the call
+	msgs := make(chan []byte)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			var msg []byte
+			err := sess.GrpcStream.RecvMsg(&msg)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			select {
+			case msgs <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-errs:
+			if err == io.EOF {
+				backend.CloseSend()
+				return
+			}
+			sess.Warningf("Forward: fail to receive from client: %v", err)
+			cancel()
+			return
+		case msg := <-msgs:
+			if backend.SendMsg(&msg) != nil {
+				return
+			}
+		}
+	}
+}
+
+// forwardMetadata returns the metadata of the call of the session, with its
+// request ID
+func forwardMetadata(sess *Session) metadata.MD {
+	md, _ := metadata.FromIncomingContext(sess.Ctx)
+	ret := metadata.MD{}
+	for key, values := range md {
+		if strings.HasPrefix(key, ":") || key == "content-type" || key == "user-agent" || strings.HasPrefix(key, "grpc-") {
+			continue
+		}
+		ret[key] = values
+	}
+	ret.Set("x-request-id", sess.RequestID)
+
+	return ret
+}
@@ -0,0 +1,257 @@
+package framework_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tinker/mock/pb/hello"
+	"tinker/pkg/framework"
+	"tinker/pkg/framework/frameworktest"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// serveProxy serves handler, the handler of a proxy, on an in-memory listener
+// and returns a client of it
+func serveProxy(t *testing.T, handler *framework.Handler) *grpc.ClientConn {
+	t.Helper()
+
+	lis := bufconn.Listen(1024 * 1024)
+	server := framework.NewGrpcServer(handler)
+	go server.Serve(lis)
+
+	conn, err := grpc.Dial("bufconn", grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	if err != nil {
+		t.Fatalf("fail to dial: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		server.Stop()
+	})
+
+	return conn
+}
+
+// proxyHandler returns the handler of a proxy of srv
+func proxyHandler(t *testing.T, srv *frameworktest.HelloServer) *framework.Handler {
+	t.Helper()
+
+	proxy := framework.NewGrpcProxy(helloPool(t, srv), map[string][]string{"*": {"bufconn"}})
+	return proxy.Handler()
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestGrpcProxyUnary(t *testing.T) {
+	srv := &frameworktest.HelloServer{Trailer: metadata.Pairs("x-trailer", "unary")}
+	conn := serveProxy(t, proxyHandler(t, srv))
+
+	var trailer metadata.MD
+	resp, err := hello.NewGreetingClient(conn).Greet(testContext(t), &hello.GreetRequest{Person: hello.Name_Bob}, grpc.Trailer(&trailer))
+	if err != nil {
+		t.Fatalf("Greet: %v", err)
+	}
+	if resp.Acking != "Hi Bob" || resp.Name != hello.Name_Robot {
+		t.Fatalf("unexpected response %v", resp)
+	}
+	if got := trailer.Get("x-trailer"); len(got) != 1 || got[0] != "unary" {
+		t.Fatalf("expect trailer x-trailer: unary, got %v", trailer)
+	}
+}
+
+func TestGrpcProxyClientStreaming(t *testing.T) {
+	srv := &frameworktest.HelloServer{Trailer: metadata.Pairs("x-trailer", "record")}
+	conn := serveProxy(t, proxyHandler(t, srv))
+
+	stream, err := hello.NewStreamServiceClient(conn).Record(testContext(t))
+	if err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		err := stream.Send(&hello.StreamRequest{Pt: &hello.StreamPoint{Name: name}})
+		if err != nil {
+			t.Fatalf("send %s: %v", name, err)
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("CloseAndRecv: %v", err)
+	}
+
+	if resp.GetPt().GetName() != "gRPC Stream Server: Record" {
+		t.Fatalf("unexpected response %v", resp)
+	}
+	if received := srv.Received(); len(received) != 3 || received[2].GetPt().GetName() != "c" {
+		t.Fatalf("expect the 3 requests forwarded, got %v", received)
+	}
+	if got := stream.Trailer().Get("x-trailer"); len(got) != 1 || got[0] != "record" {
+		t.Fatalf("expect trailer x-trailer: record, got %v", stream.Trailer())
+	}
+}
+
+func TestGrpcProxyBidi(t *testing.T) {
+	srv := &frameworktest.HelloServer{Trailer: metadata.Pairs("x-trailer", "route")}
+	conn := serveProxy(t, proxyHandler(t, srv))
+
+	stream, err := hello.NewStreamServiceClient(conn).Route(testContext(t))
+	if err != nil {
+		t.Fatalf("Route: %v", err)
+	}
+	// each request is answered before the next one is sent
+	for _, name := range []string{"a", "b", "c"} {
+		err := stream.Send(&hello.StreamRequest{Pt: &hello.StreamPoint{Name: name}})
+		if err != nil {
+			t.Fatalf("send %s: %v", name, err)
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("receive %s: %v", name, err)
+		}
+		if resp.GetPt().GetName() != name {
+			t.Fatalf("expect answer to %s, got %v", name, resp)
+		}
+	}
+	stream.CloseSend()
+
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Fatalf("expect EOF, got %v", err)
+	}
+	if got := stream.Trailer().Get("x-trailer"); len(got) != 1 || got[0] != "route" {
+		t.Fatalf("expect trailer x-trailer: route, got %v", stream.Trailer())
+	}
+}
+
+func TestGrpcProxyStatus(t *testing.T) {
+	srv := &frameworktest.HelloServer{
+		Err:     status.Error(codes.NotFound, "no such person"),
+		Trailer: metadata.Pairs("x-trailer", "error"),
+	}
+	conn := serveProxy(t, proxyHandler(t, srv))
+
+	var trailer metadata.MD
+	_, err := hello.NewGreetingClient(conn).Greet(testContext(t), &hello.GreetRequest{}, grpc.Trailer(&trailer))
+	if st := status.Convert(err); st.Code() != codes.NotFound || st.Message() != "no such person" {
+		t.Fatalf("expect the backend status, got %v", err)
+	}
+	if got := trailer.Get("x-trailer"); len(got) != 1 || got[0] != "error" {
+		t.Fatalf("expect trailer x-trailer: error, got %v", trailer)
+	}
+
+	// the backend ends the call while the client still sends
+	stream, err := hello.NewStreamServiceClient(conn).Route(testContext(t))
+	if err != nil {
+		t.Fatalf("Route: %v", err)
+	}
+	stream.Send(&hello.StreamRequest{})
+	if _, err := stream.Recv(); status.Code(err) != codes.NotFound {
+		t.Fatalf("expect the backend status, got %v", err)
+	}
+}
+
+func TestGrpcProxyNoBackend(t *testing.T) {
+	proxy := framework.NewGrpcProxy(framework.NewGrpcPool(), nil)
+	conn := serveProxy(t, proxy.Handler())
+
+	_, err := hello.NewGreetingClient(conn).Greet(testContext(t), &hello.GreetRequest{})
+	if status.Code(err) != codes.Unimplemented {
+		t.Fatalf("expect Unimplemented, got %v", err)
+	}
+}
+
+func TestGrpcProxyRecording(t *testing.T) {
+	dir := t.TempDir()
+	handler := proxyHandler(t, new(frameworktest.HelloServer))
+	handler.UseFirst(framework.WithRecording(dir))
+	conn := serveProxy(t, handler)
+
+	ctx := metadata.AppendToOutgoingContext(testContext(t), "authorization", "secret")
+	_, err := hello.NewGreetingClient(conn).Greet(ctx, &hello.GreetRequest{})
+	if err != nil {
+		t.Fatalf("Greet: %v", err)
+	}
+
+	// the recording is closed before the status is sent
+	files, _ := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if len(files) != 1 {
+		t.Fatalf("expect one recording, got %v", files)
+	}
+	file, err := os.Open(files[0])
+	if err != nil {
+		t.Fatalf("fail to open recording: %v", err)
+	}
+	defer file.Close()
+	events, err := framework.ReadRecording(file)
+	if err != nil {
+		t.Fatalf("fail to read recording: %v", err)
+	}
+
+	if len(events) != 2 || events[0].Kind != framework.RecordSession || events[1].Kind != framework.RecordEnd {
+		t.Fatalf("expect session and end events, got %+v", events)
+	}
+	if events[0].URL != "/hello.Greeting/Greet" || events[0].Header.Get("Authorization") != framework.RedactedValue {
+		t.Fatalf("unexpected session event %+v", events[0])
+	}
+}
+
+func TestGrpcProxyFaultInjection(t *testing.T) {
+	os.Setenv(framework.EnvFaultInjection, "1")
+	defer os.Unsetenv(framework.EnvFaultInjection)
+
+	handler := proxyHandler(t, new(frameworktest.HelloServer))
+	handler.Use(framework.WithFaultInjection(framework.FaultConfig{Headers: true}))
+	conn := serveProxy(t, handler)
+
+	ctx := metadata.AppendToOutgoingContext(testContext(t), framework.HeaderFaultAbort, "503")
+	_, err := hello.NewGreetingClient(conn).Greet(ctx, &hello.GreetRequest{})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("expect Unavailable, got %v", err)
+	}
+
+	_, err = hello.NewGreetingClient(conn).Greet(testContext(t), &hello.GreetRequest{})
+	if err != nil {
+		t.Fatalf("expect no fault without header, got %v", err)
+	}
+}
+
+func TestGrpcPoolRetain(t *testing.T) {
+	timeout := framework.GrpcPoolDrainTimeout
+	framework.GrpcPoolDrainTimeout = 0
+	defer func() { framework.GrpcPoolDrainTimeout = timeout }()
+
+	pool := helloPool(t, new(frameworktest.HelloServer))
+	kept, _ := pool.Get("kept")
+	removed, _ := pool.Get("removed")
+
+	if n := pool.Retain("kept", "other"); n != 1 {
+		t.Fatalf("expect 1 connection removed, got %d", n)
+	}
+	if conn, _ := pool.Get("kept"); conn != kept {
+		t.Fatalf("expect the connection of kept target reused")
+	}
+	if conn, _ := pool.Get("removed"); conn == removed {
+		t.Fatalf("expect a new connection for the removed target")
+	}
+
+	for i := 0; i < 100 && removed.GetState() != connectivity.Shutdown; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if state := removed.GetState(); state != connectivity.Shutdown {
+		t.Fatalf("expect the removed connection closed, got %s", state)
+	}
+}
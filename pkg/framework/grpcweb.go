@@ -0,0 +1,718 @@
+package framework
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Content types of grpc-web and of the Connect protocol, see GrpcBridge.
+// A "+proto" or "+json" suffix picks the encoding of the messages, except for
+// Connect unary calls which use ContentTypeProto or ContentTypeJSON
+const (
+	ContentTypeGrpcWeb      = "application/grpc-web"
+	ContentTypeGrpcWebText  = "application/grpc-web-text"
+	ContentTypeConnectProto = "application/connect+proto"
+	ContentTypeConnectJSON  = "application/connect+json"
+	ContentTypeProto        = "application/proto"
+)
+
+// flags of the length prefixed messages of grpc-web and Connect streams
+const (
+	flagCompressed = 0x01
+	flagEndStream  = 0x02
+	flagTrailer    = 0x80
+)
+
+type webProtocol int
+
+const (
+	protocolGrpcWeb webProtocol = iota
+	protocolGrpcWebText
+	protocolConnectUnary
+	protocolConnectStream
+)
+
+// webCall is the protocol of a grpc-web or Connect request
+type webCall struct {
+	protocol    webProtocol
+	contentType string
+	// json tells whether the messages are protojson
+	json bool
+}
+
+// parseWebCall returns the call of the Content-Type of req, nil if it isn't
+// grpc-web or Connect
+func parseWebCall(req *http.Request) *webCall {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return nil
+	}
+
+	switch mediaType {
+	case ContentTypeProto:
+		return &webCall{protocol: protocolConnectUnary, contentType: mediaType}
+	case ContentTypeJSON:
+		return &webCall{protocol: protocolConnectUnary, contentType: mediaType, json: true}
+	}
+
+	base, codec := mediaType, "proto"
+	if i := strings.IndexByte(mediaType, '+'); i >= 0 {
+		base, codec = mediaType[:i], mediaType[i+1:]
+	}
+	if codec != "proto" && codec != "json" {
+		return nil
+	}
+
+	ret := &webCall{contentType: mediaType, json: codec == "json"}
+	switch {
+	case base == ContentTypeGrpcWeb:
+		ret.protocol = protocolGrpcWeb
+	case base == ContentTypeGrpcWebText:
+		ret.protocol = protocolGrpcWebText
+	case base == "application/connect" && base != mediaType:
+		ret.protocol = protocolConnectStream
+	default:
+		return nil
+	}
+
+	return ret
+}
+
+// GrpcBridge proxies grpc-web (binary and text) and Connect calls to grpc
+// backends over pooled connections, so that browsers can call them.
+// Unary and server streaming methods are supported. Messages are passed as
+// is, so the backend services need not be known, except for JSON messages
+// which need the service registered in protoregistry.GlobalFiles, e.g. by
+// importing its generated package
+type GrpcBridge struct {
+	// Backends maps the full name of a service to the target serving it,
+	// "*" to the target of the other services
+	Backends map[string]string
+	// MaxMessageSize bounds the request and response messages
+	MaxMessageSize int64
+
+	pool *GrpcPool
+}
+
+func NewGrpcBridge(pool *GrpcPool, backends map[string]string) *GrpcBridge {
+	return &GrpcBridge{
+		Backends:       backends,
+		MaxMessageSize: 8 * 1024 * 1024,
+		pool:           pool,
+	}
+}
+
+// Handler returns the Handler of the bridge, it must be routed for POST
+// "/{service}/{method}"
+func (p *GrpcBridge) Handler() *Handler {
+	ret := &Handler{
+		Name:    "grpcBridge",
+		OnError: LogError,
+		OnPanic: LogPanic,
+	}
+	ret.Use(WithRequestID(), WithReplyGrpcWebError())
+	ret.Add(p.Proxy)
+
+	return ret
+}
+
+// Proxy calls the backend method of the path parameters "service" and
+// "method" and streams its response
+func (p *GrpcBridge) Proxy(sess *Session) error {
+	req := sess.Request
+	call := parseWebCall(req)
+	if call == nil {
+		return HttpErrorUnsupportedMediaType
+	}
+
+	service, method := sess.Param("service"), sess.Param("method")
+	target, ok := p.Backends[service]
+	if !ok {
+		target, ok = p.Backends["*"]
+	}
+	if !ok {
+		return status.Errorf(codes.Unimplemented, "unknown service %s", service)
+	}
+
+	desc := lookupMethod(service, method)
+	if desc != nil && desc.IsStreamingClient() {
+		return status.Errorf(codes.Unimplemented, "client streaming method %s/%s is not supported", service, method)
+	}
+	if desc != nil && desc.IsStreamingServer() && call.protocol == protocolConnectUnary {
+		return status.Errorf(codes.Unimplemented, "server streaming method %s/%s needs the connect streaming protocol", service, method)
+	}
+	if desc == nil && call.json {
+		return status.Errorf(codes.Unimplemented, "unknown method %s/%s, JSON messages need its registered service", service, method)
+	}
+
+	data, err := readWebMessage(req, call, p.MaxMessageSize)
+	if err != nil {
+		return err
+	}
+	if call.json {
+		data, err = jsonToProto(data, desc.Input())
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid request message: %v", err)
+		}
+	}
+
+	ctx, cancel, err := webContext(sess.Ctx, req)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	md := outgoingMetadata(req.Header)
+	md.Set("x-request-id", sess.RequestID)
+	ctx = metadata.NewOutgoingContext(ctx, md)
+
+	conn, err := p.pool.Get(target)
+	if err != nil {
+		sess.Errorf("GrpcBridge: %v", err)
+		return status.Error(codes.Unavailable, err.Error())
+	}
+
+	sess.Infof("GrpcBridge: proxy /%s/%s to '%s'", service, method, target)
+	stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/"+service+"/"+method,
+		grpc.ForceCodec(rawCodec{}), grpc.MaxCallRecvMsgSize(int(p.MaxMessageSize)))
+	if err != nil {
+		return err
+	}
+	err = stream.SendMsg(&data)
+	if err == nil {
+		err = stream.CloseSend()
+	}
+	if err == io.EOF {
+		// the backend already ended the call, RecvMsg returns its status
+		err = nil
+	}
+
+	w := &webWriter{sess: sess, call: call, desc: desc}
+	var header metadata.MD
+	if err == nil {
+		header, err = stream.Header()
+	}
+	for err == nil {
+		var msg []byte
+		err = stream.RecvMsg(&msg)
+		if err != nil {
+			break
+		}
+
+		err = w.message(header, msg)
+		if err != nil {
+			// the client is gone, nothing else can be written
+			sess.Errorf("GrpcBridge: fail to write response: %v", err)
+			return nil
+		}
+	}
+
+	st := status.New(codes.OK, "")
+	if err != io.EOF {
+		st = status.Convert(err)
+		sess.Warningf("GrpcBridge: /%s/%s failed: %v", service, method, err)
+	}
+
+	err = w.end(header, st, stream.Trailer())
+	if err != nil {
+		sess.Errorf("GrpcBridge: fail to write response: %v", err)
+	}
+
+	return nil
+}
+
+// WithReplyGrpcWebError returns a Wrapper replying errors in the protocol of
+// the grpc-web or Connect call, see GrpcBridge. HttpErrors are replied with
+// the grpc code of their status
+func WithReplyGrpcWebError() Wrapper {
+	return func(sess *Session, action Action) error {
+		err := action(sess)
+		if err == nil {
+			return nil
+		}
+
+		call := parseWebCall(sess.Request)
+		if call == nil {
+			return SendHttp(sess, HttpErrorUnsupportedMediaType.StatusCode, HttpErrorUnsupportedMediaType)
+		}
+
+		w := &webWriter{sess: sess, call: call}
+		return w.end(nil, errorStatus(err), nil)
+	}
+}
+
+// errorStatus converts the error of an action to a grpc status
+func errorStatus(err error) *status.Status {
+	if st, ok := status.FromError(err); ok {
+		return st
+	}
+
+	if httpErr, ok := err.(*HttpError); ok {
+		return status.New(httpStatusCode(httpErr.StatusCode), httpErr.Message)
+	}
+
+	if st := status.FromContextError(err); st.Code() != codes.Unknown {
+		return st
+	}
+
+	return status.New(codes.Internal, "internal server error")
+}
+
+func httpStatusCode(httpCode int) codes.Code {
+	switch httpCode {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	case http.StatusNotImplemented, http.StatusUnsupportedMediaType, http.StatusMethodNotAllowed:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable, http.StatusBadGateway:
+		return codes.Unavailable
+	default:
+		return codes.Internal
+	}
+}
+
+// lookupMethod returns the descriptor of a registered method, nil if unknown
+func lookupMethod(service, method string) protoreflect.MethodDescriptor {
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil
+	}
+
+	return sd.Methods().ByName(protoreflect.Name(method))
+}
+
+func jsonToProto(data []byte, desc protoreflect.MessageDescriptor) ([]byte, error) {
+	msg := dynamicpb.NewMessage(desc)
+	err := protojson.Unmarshal(data, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return proto.Marshal(msg)
+}
+
+func protoToJSON(data []byte, desc protoreflect.MessageDescriptor) ([]byte, error) {
+	msg := dynamicpb.NewMessage(desc)
+	err := proto.Unmarshal(data, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return protojson.Marshal(msg)
+}
+
+// readWebMessage reads the request message of call. Compressed messages are
+// not supported
+func readWebMessage(req *http.Request, call *webCall, maxSize int64) ([]byte, error) {
+	if encoding := req.Header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return nil, status.Errorf(codes.Unimplemented, "unsupported content encoding %s", encoding)
+	}
+
+	var body io.Reader = io.LimitReader(req.Body, maxSize+5+1)
+	if call.protocol == protocolGrpcWebText {
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "fail to read request: %v", err)
+	}
+
+	if call.protocol == protocolConnectUnary {
+		if int64(len(data)) > maxSize {
+			return nil, status.Errorf(codes.ResourceExhausted, "request message exceeds the limit of %d bytes", maxSize)
+		}
+		return data, nil
+	}
+
+	if len(data) < 5 {
+		return nil, status.Error(codes.InvalidArgument, "request message is not length prefixed")
+	}
+	if data[0]&flagCompressed != 0 {
+		return nil, status.Error(codes.Unimplemented, "compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(data[1:5])
+	if int64(size) > maxSize {
+		return nil, status.Errorf(codes.ResourceExhausted, "request message exceeds the limit of %d bytes", maxSize)
+	}
+	if uint32(len(data)-5) != size {
+		return nil, status.Error(codes.InvalidArgument, "expect one request message")
+	}
+
+	return data[5:], nil
+}
+
+// webContext returns the context of the call with the timeout of its
+// grpc-timeout or Connect-Timeout-Ms header
+func webContext(ctx context.Context, req *http.Request) (context.Context, context.CancelFunc, error) {
+	var timeout time.Duration
+	if v := req.Header.Get("Connect-Timeout-Ms"); v != "" {
+		ms, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || ms < 0 {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "invalid Connect-Timeout-Ms %s", v)
+		}
+		timeout = time.Duration(ms) * time.Millisecond
+	} else if v := req.Header.Get("Grpc-Timeout"); v != "" {
+		d, err := parseGrpcTimeout(v)
+		if err != nil {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "invalid grpc-timeout %s", v)
+		}
+		timeout = d
+	}
+
+	if timeout > 0 {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		return ctx, cancel, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	return ctx, cancel, nil
+}
+
+// parseGrpcTimeout parses a grpc-timeout like "100m" or "5S"
+func parseGrpcTimeout(v string) (time.Duration, error) {
+	if len(v) < 2 {
+		return 0, fmt.Errorf("invalid timeout")
+	}
+
+	n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid timeout")
+	}
+
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+	unit, ok := units[v[len(v)-1]]
+	if !ok {
+		return 0, fmt.Errorf("invalid timeout unit")
+	}
+
+	return time.Duration(n) * unit, nil
+}
+
+// outgoingMetadata forwards the request headers but those of http and of the
+// protocols. Values of "-bin" headers are base64
+func outgoingMetadata(header http.Header) metadata.MD {
+	ret := metadata.MD{}
+	for key, values := range header {
+		key = strings.ToLower(key)
+		switch key {
+		case "content-type", "content-length", "content-encoding", "accept", "accept-encoding",
+			"connection", "keep-alive", "te", "trailer", "transfer-encoding", "upgrade", "host", "user-agent":
+			continue
+		}
+		if strings.HasPrefix(key, "grpc-") || strings.HasPrefix(key, "connect-") || strings.HasPrefix(key, "x-grpc-web") || strings.HasPrefix(key, "sec-") {
+			continue
+		}
+
+		for _, v := range values {
+			if strings.HasSuffix(key, "-bin") {
+				data, err := base64.StdEncoding.DecodeString(v)
+				if err != nil {
+					data, err = base64.RawStdEncoding.DecodeString(v)
+				}
+				if err != nil {
+					continue
+				}
+				v = string(data)
+			}
+			ret.Append(key, v)
+		}
+	}
+
+	return ret
+}
+
+// setMetadata sets the metadata of the backend as http headers, under prefix
+func setMetadata(header http.Header, md metadata.MD, prefix string) {
+	for key, values := range md {
+		if key == "content-type" || strings.HasPrefix(key, "grpc-") || strings.HasPrefix(key, ":") {
+			continue
+		}
+		for _, v := range values {
+			if strings.HasSuffix(key, "-bin") {
+				v = base64.StdEncoding.EncodeToString([]byte(v))
+			}
+			header.Add(prefix+key, v)
+		}
+	}
+}
+
+// webWriter writes the response of a webCall
+type webWriter struct {
+	sess *Session
+	call *webCall
+	desc protoreflect.MethodDescriptor
+
+	started bool
+	// responses of a Connect unary call, sent by end
+	responses [][]byte
+}
+
+// start sends the response headers of a streaming call
+func (p *webWriter) start(header metadata.MD) error {
+	if p.started {
+		return nil
+	}
+	p.started = true
+
+	setMetadata(p.sess.ResponseWriter.Header(), header, "")
+	return SendHttpBinary(p.sess, http.StatusOK, p.call.contentType, nil)
+}
+
+// frame sends a length prefixed message of a streaming call
+func (p *webWriter) frame(flags byte, data []byte) error {
+	frame := make([]byte, 5+len(data))
+	frame[0] = flags
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(data)))
+	copy(frame[5:], data)
+
+	if p.call.protocol == protocolGrpcWebText {
+		frame = []byte(base64.StdEncoding.EncodeToString(frame))
+	}
+
+	return SendHttpChunk(p.sess, frame)
+}
+
+func (p *webWriter) message(header metadata.MD, data []byte) error {
+	if p.call.json {
+		var err error
+		data, err = protoToJSON(data, p.desc.Output())
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.call.protocol == protocolConnectUnary {
+		p.responses = append(p.responses, data)
+		return nil
+	}
+
+	err := p.start(header)
+	if err != nil {
+		return err
+	}
+
+	return p.frame(0, data)
+}
+
+// end ends the response with the status of the call
+func (p *webWriter) end(header metadata.MD, st *status.Status, trailer metadata.MD) error {
+	switch p.call.protocol {
+	case protocolConnectUnary:
+		return p.endConnectUnary(header, st, trailer)
+	case protocolConnectStream:
+		return p.endConnectStream(header, st, trailer)
+	default:
+		return p.endGrpcWeb(header, st, trailer)
+	}
+}
+
+// endGrpcWeb sends the trailers in a frame flagged 0x80, always in the body so
+// that clients read them the same way whether there were messages or not
+func (p *webWriter) endGrpcWeb(header metadata.MD, st *status.Status, trailer metadata.MD) error {
+	err := p.start(header)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "grpc-status: %d\r\n", st.Code())
+	if st.Message() != "" {
+		fmt.Fprintf(&b, "grpc-message: %s\r\n", encodeGrpcMessage(st.Message()))
+	}
+	if len(st.Proto().GetDetails()) > 0 {
+		details, err := proto.Marshal(st.Proto())
+		if err == nil {
+			fmt.Fprintf(&b, "grpc-status-details-bin: %s\r\n", base64.RawStdEncoding.EncodeToString(details))
+		}
+	}
+	fields := http.Header{}
+	setMetadata(fields, trailer, "")
+	for key, values := range fields {
+		for _, v := range values {
+			fmt.Fprintf(&b, "%s: %s\r\n", strings.ToLower(key), v)
+		}
+	}
+
+	return p.frame(flagTrailer, []byte(b.String()))
+}
+
+// connectError is the error of the Connect protocol
+type connectError struct {
+	Code    string          `json:"code"`
+	Message string          `json:"message,omitempty"`
+	Details []connectDetail `json:"details,omitempty"`
+}
+
+type connectDetail struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+func newConnectError(st *status.Status) *connectError {
+	ret := &connectError{
+		Code:    connectCode(st.Code()),
+		Message: st.Message(),
+	}
+	for _, detail := range st.Proto().GetDetails() {
+		ret.Details = append(ret.Details, connectDetail{
+			Type:  strings.TrimPrefix(detail.GetTypeUrl(), "type.googleapis.com/"),
+			Value: base64.RawStdEncoding.EncodeToString(detail.GetValue()),
+		})
+	}
+
+	return ret
+}
+
+func (p *webWriter) endConnectStream(header metadata.MD, st *status.Status, trailer metadata.MD) error {
+	err := p.start(header)
+	if err != nil {
+		return err
+	}
+
+	end := struct {
+		Error    *connectError       `json:"error,omitempty"`
+		Metadata map[string][]string `json:"metadata,omitempty"`
+	}{}
+	if st.Code() != codes.OK {
+		end.Error = newConnectError(st)
+	}
+	if len(trailer) > 0 {
+		fields := http.Header{}
+		setMetadata(fields, trailer, "")
+		end.Metadata = fields
+	}
+
+	data, err := json.Marshal(&end)
+	if err != nil {
+		return err
+	}
+
+	return p.frame(flagEndStream, data)
+}
+
+func (p *webWriter) endConnectUnary(header metadata.MD, st *status.Status, trailer metadata.MD) error {
+	if st.Code() == codes.OK && len(p.responses) != 1 {
+		st = status.Newf(codes.Unimplemented, "unary call got %d responses", len(p.responses))
+	}
+
+	rw := p.sess.ResponseWriter
+	setMetadata(rw.Header(), header, "")
+	setMetadata(rw.Header(), trailer, "Trailer-")
+
+	if st.Code() == codes.OK {
+		return SendHttpBinary(p.sess, http.StatusOK, p.call.contentType, p.responses[0])
+	}
+
+	data, err := json.Marshal(newConnectError(st))
+	if err != nil {
+		return err
+	}
+
+	return SendHttpBinary(p.sess, connectHttpStatus(st.Code()), ContentTypeJSON, data)
+}
+
+// connectCode returns the Connect name of code, e.g. "invalid_argument"
+func connectCode(code codes.Code) string {
+	if code == codes.Canceled {
+		return "canceled"
+	}
+
+	var b strings.Builder
+	for i, r := range code.String() {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// connectHttpStatus returns the http status of the Connect errors of code
+func connectHttpStatus(code codes.Code) int {
+	switch code {
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// encodeGrpcMessage percent encodes the grpc-message trailer
+func encodeGrpcMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
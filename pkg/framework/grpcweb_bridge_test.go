@@ -0,0 +1,241 @@
+package framework_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tinker/mock/pb/hello"
+	"tinker/pkg/framework"
+	"tinker/pkg/framework/frameworktest"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+// helloPool returns a pool whose connections, whatever their target, are to
+// srv served on an in-memory listener
+func helloPool(t *testing.T, srv *frameworktest.HelloServer) *framework.GrpcPool {
+	t.Helper()
+
+	lis := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	srv.Register(server)
+	go server.Serve(lis)
+
+	pool := framework.NewGrpcPool(grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	t.Cleanup(func() {
+		pool.Close()
+		server.Stop()
+	})
+
+	return pool
+}
+
+type webFrame struct {
+	flags byte
+	data  string
+}
+
+func encodeWebFrame(flags byte, data []byte) string {
+	frame := make([]byte, 5+len(data))
+	frame[0] = flags
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(data)))
+	copy(frame[5:], data)
+
+	return string(frame)
+}
+
+func decodeWebFrames(t *testing.T, body []byte) []webFrame {
+	t.Helper()
+
+	var ret []webFrame
+	for len(body) > 0 {
+		if len(body) < 5 {
+			t.Fatalf("truncated frame %q", body)
+		}
+		size := int(binary.BigEndian.Uint32(body[1:5]))
+		if len(body) < 5+size {
+			t.Fatalf("truncated frame %q", body)
+		}
+		ret = append(ret, webFrame{flags: body[0], data: string(body[5 : 5+size])})
+		body = body[5+size:]
+	}
+
+	return ret
+}
+
+// decodeWebText decodes a grpc-web-text body, whose frames are encoded one by one
+func decodeWebText(t *testing.T, body string) []byte {
+	t.Helper()
+
+	var ret []byte
+	for i := 0; i+4 <= len(body); i += 4 {
+		data, err := base64.StdEncoding.DecodeString(body[i : i+4])
+		if err != nil {
+			t.Fatalf("invalid grpc-web-text body: %v", err)
+		}
+		ret = append(ret, data...)
+	}
+
+	return ret
+}
+
+func serveBridge(t *testing.T, srv *frameworktest.HelloServer, method, contentType, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	bridge := framework.NewGrpcBridge(helloPool(t, srv), map[string]string{"*": "bufconn"})
+	router := framework.NewRouter()
+	router.Handle(http.MethodPost, "/{service}/{method}", bridge.Handler())
+
+	req := httptest.NewRequest(http.MethodPost, method, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	return frameworktest.ServeHttp(router, req)
+}
+
+func greetRequest(t *testing.T, person hello.Name) []byte {
+	t.Helper()
+
+	data, err := proto.Marshal(&hello.GreetRequest{Saying: "hi", Person: person})
+	if err != nil {
+		t.Fatalf("proto.Marshal: %v", err)
+	}
+
+	return data
+}
+
+func expectGreet(t *testing.T, data string, acking string) {
+	t.Helper()
+
+	resp := new(hello.GreetResponse)
+	err := proto.Unmarshal([]byte(data), resp)
+	if err != nil || resp.Acking != acking {
+		t.Fatalf("expect acking %s, got %v, %v", acking, resp, err)
+	}
+}
+
+func TestGrpcBridgeGrpcWeb(t *testing.T) {
+	body := encodeWebFrame(0, greetRequest(t, hello.Name_Bob))
+
+	for _, text := range []bool{false, true} {
+		contentType := framework.ContentTypeGrpcWeb
+		if text {
+			contentType = framework.ContentTypeGrpcWebText
+			body = base64.StdEncoding.EncodeToString([]byte(body))
+		}
+		t.Run(contentType, func(t *testing.T) {
+			rec := serveBridge(t, new(frameworktest.HelloServer), "/hello.Greeting/Greet", contentType, body)
+			if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != contentType {
+				t.Fatalf("unexpected response %d %s", rec.Code, rec.Header().Get("Content-Type"))
+			}
+
+			data := rec.Body.Bytes()
+			if text {
+				data = decodeWebText(t, rec.Body.String())
+			}
+			frames := decodeWebFrames(t, data)
+			if len(frames) != 2 || frames[0].flags != 0 || frames[1].flags != 0x80 {
+				t.Fatalf("expect a message and a trailer frame, got %v", frames)
+			}
+			expectGreet(t, frames[0].data, "Hi Bob")
+			if !strings.Contains(frames[1].data, "grpc-status: 0\r\n") {
+				t.Fatalf("unexpected trailer %q", frames[1].data)
+			}
+		})
+	}
+}
+
+func TestGrpcBridgeConnect(t *testing.T) {
+	t.Run("unary json", func(t *testing.T) {
+		rec := serveBridge(t, new(frameworktest.HelloServer), "/hello.Greeting/Greet", framework.ContentTypeJSON, `{"Saying":"hi","Person":"Bob"}`)
+
+		var resp map[string]interface{}
+		frameworktest.ExpectHttp(t, rec, http.StatusOK, &resp)
+		if resp["Acking"] != "Hi Bob" {
+			t.Fatalf("unexpected response %v", resp)
+		}
+	})
+
+	t.Run("unary proto", func(t *testing.T) {
+		rec := serveBridge(t, new(frameworktest.HelloServer), "/hello.Greeting/Greet", framework.ContentTypeProto, string(greetRequest(t, hello.Name_Joe)))
+		frameworktest.ExpectHttp(t, rec, http.StatusOK, nil)
+		expectGreet(t, rec.Body.String(), "Hi Joe")
+	})
+
+	t.Run("server streaming", func(t *testing.T) {
+		srv := &frameworktest.HelloServer{ListMessages: 3}
+		req, _ := proto.Marshal(&hello.StreamRequest{Pt: &hello.StreamPoint{Name: "list"}})
+		rec := serveBridge(t, srv, "/hello.StreamService/List", framework.ContentTypeConnectProto, encodeWebFrame(0, req))
+		frameworktest.ExpectHttp(t, rec, http.StatusOK, nil)
+
+		frames := decodeWebFrames(t, rec.Body.Bytes())
+		if len(frames) != 4 {
+			t.Fatalf("expect 3 messages and the end of stream, got %d frames", len(frames))
+		}
+		for _, frame := range frames[:3] {
+			resp := new(hello.StreamResponse)
+			if err := proto.Unmarshal([]byte(frame.data), resp); err != nil || resp.GetPt().GetName() != "list" {
+				t.Fatalf("unexpected message %v, %v", resp, err)
+			}
+		}
+		if frames[3].flags != 0x02 || frames[3].data != "{}" {
+			t.Fatalf("unexpected end of stream %v", frames[3])
+		}
+	})
+}
+
+func TestGrpcBridgeStatus(t *testing.T) {
+	srv := &frameworktest.HelloServer{Err: status.Error(codes.PermissionDenied, "denied")}
+
+	t.Run("grpc-web", func(t *testing.T) {
+		rec := serveBridge(t, srv, "/hello.Greeting/Greet", framework.ContentTypeGrpcWeb, encodeWebFrame(0, greetRequest(t, hello.Name_Bob)))
+		frames := decodeWebFrames(t, rec.Body.Bytes())
+		if len(frames) != 1 || frames[0].flags != 0x80 {
+			t.Fatalf("expect a trailer frame, got %v", frames)
+		}
+		if !strings.Contains(frames[0].data, "grpc-status: 7\r\n") || !strings.Contains(frames[0].data, "grpc-message: denied\r\n") {
+			t.Fatalf("unexpected trailer %q", frames[0].data)
+		}
+	})
+
+	t.Run("connect unary", func(t *testing.T) {
+		rec := serveBridge(t, srv, "/hello.Greeting/Greet", framework.ContentTypeJSON, `{"Person":"Bob"}`)
+
+		var resp struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		}
+		frameworktest.ExpectHttp(t, rec, http.StatusForbidden, &resp)
+		if resp.Code != "permission_denied" || resp.Message != "denied" {
+			t.Fatalf("unexpected error %+v", resp)
+		}
+	})
+
+	t.Run("connect stream", func(t *testing.T) {
+		rec := serveBridge(t, srv, "/hello.StreamService/List", framework.ContentTypeConnectJSON, encodeWebFrame(0, []byte("{}")))
+		frames := decodeWebFrames(t, rec.Body.Bytes())
+		if len(frames) != 1 || frames[0].flags != 0x02 {
+			t.Fatalf("expect the end of stream, got %v", frames)
+		}
+
+		var end struct {
+			Error struct {
+				Code string `json:"code"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(frames[0].data), &end); err != nil || end.Error.Code != "permission_denied" {
+			t.Fatalf("unexpected end of stream %s", frames[0].data)
+		}
+	})
+}
@@ -0,0 +1,177 @@
+package framework
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseWebCall(t *testing.T) {
+	tests := []struct {
+		contentType string
+		protocol    webProtocol
+		json        bool
+		invalid     bool
+	}{
+		{contentType: "application/grpc-web", protocol: protocolGrpcWeb},
+		{contentType: "application/grpc-web+proto", protocol: protocolGrpcWeb},
+		{contentType: "application/grpc-web+json", protocol: protocolGrpcWeb, json: true},
+		{contentType: "application/grpc-web-text", protocol: protocolGrpcWebText},
+		{contentType: "application/grpc-web-text+proto; charset=utf-8", protocol: protocolGrpcWebText},
+		{contentType: "application/proto", protocol: protocolConnectUnary},
+		{contentType: "application/json", protocol: protocolConnectUnary, json: true},
+		{contentType: "application/connect+proto", protocol: protocolConnectStream},
+		{contentType: "application/connect+json", protocol: protocolConnectStream, json: true},
+		{contentType: "application/connect", invalid: true},
+		{contentType: "application/grpc-web+thrift", invalid: true},
+		{contentType: "application/grpc", invalid: true},
+		{contentType: "text/plain", invalid: true},
+		{contentType: "", invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.Header.Set("Content-Type", tt.contentType)
+
+			call := parseWebCall(req)
+			if tt.invalid {
+				if call != nil {
+					t.Fatalf("expect no call, got %+v", call)
+				}
+				return
+			}
+			if call == nil || call.protocol != tt.protocol || call.json != tt.json {
+				t.Fatalf("expect protocol %d json %v, got %+v", tt.protocol, tt.json, call)
+			}
+		})
+	}
+}
+
+// webFrame returns a length prefixed message
+func webFrame(flags byte, data string) string {
+	frame := make([]byte, 5+len(data))
+	frame[0] = flags
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(data)))
+	copy(frame[5:], data)
+
+	return string(frame)
+}
+
+func TestReadWebMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol webProtocol
+		body     string
+		encoding string
+		expect   string
+		code     codes.Code
+	}{
+		{name: "grpc-web", protocol: protocolGrpcWeb, body: webFrame(0, "hello"), expect: "hello"},
+		{name: "grpc-web empty", protocol: protocolGrpcWeb, body: webFrame(0, ""), expect: ""},
+		{name: "grpc-web at limit", protocol: protocolGrpcWeb, body: webFrame(0, "0123456789"), expect: "0123456789"},
+		{name: "grpc-web over limit", protocol: protocolGrpcWeb, body: webFrame(0, "0123456789a"), code: codes.ResourceExhausted},
+		{name: "grpc-web not prefixed", protocol: protocolGrpcWeb, body: "abc", code: codes.InvalidArgument},
+		{name: "grpc-web truncated", protocol: protocolGrpcWeb, body: webFrame(0, "hello")[:7], code: codes.InvalidArgument},
+		{name: "grpc-web two messages", protocol: protocolGrpcWeb, body: webFrame(0, "a") + webFrame(0, "b"), code: codes.InvalidArgument},
+		{name: "grpc-web compressed", protocol: protocolGrpcWeb, body: webFrame(flagCompressed, "hello"), code: codes.Unimplemented},
+		{name: "content encoding", protocol: protocolGrpcWeb, body: webFrame(0, "hello"), encoding: "gzip", code: codes.Unimplemented},
+		{name: "identity encoding", protocol: protocolGrpcWeb, body: webFrame(0, "hello"), encoding: "identity", expect: "hello"},
+		{name: "grpc-web-text", protocol: protocolGrpcWebText, body: base64.StdEncoding.EncodeToString([]byte(webFrame(0, "hello"))), expect: "hello"},
+		{name: "grpc-web-text over limit", protocol: protocolGrpcWebText, body: base64.StdEncoding.EncodeToString([]byte(webFrame(0, strings.Repeat("a", 11)))), code: codes.ResourceExhausted},
+		{name: "grpc-web-text invalid base64", protocol: protocolGrpcWebText, body: "!!!!", code: codes.InvalidArgument},
+		{name: "connect unary", protocol: protocolConnectUnary, body: "hello", expect: "hello"},
+		{name: "connect unary over limit", protocol: protocolConnectUnary, body: strings.Repeat("a", 11), code: codes.ResourceExhausted},
+		{name: "connect stream", protocol: protocolConnectStream, body: webFrame(0, "hello"), expect: "hello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+
+			data, err := readWebMessage(req, &webCall{protocol: tt.protocol}, 10)
+			if status.Code(err) != tt.code {
+				t.Fatalf("expect code %s, got %v", tt.code, err)
+			}
+			if err == nil && string(data) != tt.expect {
+				t.Fatalf("expect %q, got %q", tt.expect, data)
+			}
+		})
+	}
+}
+
+func TestParseGrpcTimeout(t *testing.T) {
+	tests := []struct {
+		value   string
+		expect  time.Duration
+		invalid bool
+	}{
+		{value: "1H", expect: time.Hour},
+		{value: "2M", expect: 2 * time.Minute},
+		{value: "5S", expect: 5 * time.Second},
+		{value: "100m", expect: 100 * time.Millisecond},
+		{value: "10u", expect: 10 * time.Microsecond},
+		{value: "7n", expect: 7 * time.Nanosecond},
+		{value: "0m", expect: 0},
+		{value: "S", invalid: true},
+		{value: "", invalid: true},
+		{value: "10", invalid: true},
+		{value: "10s", invalid: true},
+		{value: "-1S", invalid: true},
+		{value: "1.5S", invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			d, err := parseGrpcTimeout(tt.value)
+			if (err != nil) != tt.invalid {
+				t.Fatalf("expect invalid %v, got %v", tt.invalid, err)
+			}
+			if err == nil && d != tt.expect {
+				t.Fatalf("expect %s, got %s", tt.expect, d)
+			}
+		})
+	}
+}
+
+func TestConnectCodes(t *testing.T) {
+	tests := []struct {
+		code   codes.Code
+		name   string
+		status int
+	}{
+		{codes.Canceled, "canceled", 499},
+		{codes.Unknown, "unknown", http.StatusInternalServerError},
+		{codes.InvalidArgument, "invalid_argument", http.StatusBadRequest},
+		{codes.DeadlineExceeded, "deadline_exceeded", http.StatusGatewayTimeout},
+		{codes.NotFound, "not_found", http.StatusNotFound},
+		{codes.AlreadyExists, "already_exists", http.StatusConflict},
+		{codes.PermissionDenied, "permission_denied", http.StatusForbidden},
+		{codes.ResourceExhausted, "resource_exhausted", http.StatusTooManyRequests},
+		{codes.FailedPrecondition, "failed_precondition", http.StatusBadRequest},
+		{codes.Aborted, "aborted", http.StatusConflict},
+		{codes.OutOfRange, "out_of_range", http.StatusBadRequest},
+		{codes.Unimplemented, "unimplemented", http.StatusNotImplemented},
+		{codes.Internal, "internal", http.StatusInternalServerError},
+		{codes.Unavailable, "unavailable", http.StatusServiceUnavailable},
+		{codes.DataLoss, "data_loss", http.StatusInternalServerError},
+		{codes.Unauthenticated, "unauthenticated", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if name := connectCode(tt.code); name != tt.name {
+				t.Fatalf("expect %s, got %s", tt.name, name)
+			}
+			if code := connectHttpStatus(tt.code); code != tt.status {
+				t.Fatalf("expect status %d, got %d", tt.status, code)
+			}
+		})
+	}
+}
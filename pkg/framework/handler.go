@@ -10,8 +10,10 @@ import (
 type Handler struct {
 	Name string
 
-	wrappers []Wrapper
+	wrappers []namedWrapper
 	actions  []Action
+	// main is the actions wrapped by the wrappers, built when they change
+	main Action
 
 	OnError func(*Session, error)
 	OnPanic func(*Session, interface{})
@@ -25,16 +27,37 @@ func LogPanic(sess *Session, pani interface{}) {
 	sess.Errorf("Recover from panic: %v", pani)
 }
 
+// Use adds wrappers inside the current ones, they are named after their
+// function, see Describe
 func (p *Handler) Use(wrappers ...Wrapper) {
-	p.wrappers = append(p.wrappers, wrappers...)
+	p.wrappers = append(p.wrappers, nameWrappers(wrappers)...)
+	p.build()
 }
 
+// UseFirst adds wrappers outside the current ones
 func (p *Handler) UseFirst(wrappers ...Wrapper) {
-	p.wrappers = append(wrappers, p.wrappers...)
+	p.wrappers = append(nameWrappers(wrappers), p.wrappers...)
+	p.build()
 }
 
 func (p *Handler) Add(actions ...Action) {
 	p.actions = append(p.actions, actions...)
+	p.build()
+}
+
+// build wraps the actions once instead of on every session
+func (p *Handler) build() {
+	p.main = Seq(p.actions...).WithWrappers(unnameWrappers(p.wrappers)...)
+}
+
+// mainAction returns the wrapped actions, a Handler without wrappers nor
+// actions does nothing
+func (p *Handler) mainAction() Action {
+	if p.main == nil {
+		return Seq()
+	}
+
+	return p.main
 }
 
 func DefaultWsHandler(name string, grpcAddr []string) *Handler {
@@ -76,6 +99,7 @@ func (p *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	sess.ResponseWriter = rw
 	sess.Request = req
 	sess.StartTime = time.Now().UTC()
+	sess.initKeys()
 
 	defer func() {
 		latency := time.Since(sess.StartTime).Seconds()
@@ -96,8 +120,7 @@ func (p *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	fin := make(chan int)
 	ticker := time.NewTicker(10 * time.Minute)
 	go func() {
-		mainAction := Seq(p.actions...).WithWrappers(p.wrappers...)
-		err = mainAction(sess)
+		err = p.mainAction()(sess)
 		fin <- 1
 	}()
 
@@ -2,15 +2,19 @@ package framework
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/rs/xid"
+	"google.golang.org/grpc/metadata"
 )
 
 type HttpError struct {
 	StatusCode int    `json:"-"`
 	Message    string `json:"message"`
+	// Fields lists the invalid fields of a bad request, see Bind
+	Fields []FieldError `json:"fields,omitempty"`
 }
 
 func (p *HttpError) Error() string {
@@ -24,9 +28,23 @@ func (p *HttpError) WithMessage(msg string) *HttpError {
 	}
 }
 
+func (p *HttpError) WithFields(fields []FieldError) *HttpError {
+	return &HttpError{
+		StatusCode: p.StatusCode,
+		Message:    p.Message,
+		Fields:     fields,
+	}
+}
+
 var (
-	HttpErrorBadRequest = NewHttpError(http.StatusBadRequest, "invalid request")
-	HttpErrorServer     = NewHttpError(http.StatusInternalServerError, "internal server error")
+	HttpErrorBadRequest           = NewHttpError(http.StatusBadRequest, "invalid request")
+	HttpErrorNotFound             = NewHttpError(http.StatusNotFound, "not found")
+	HttpErrorMethodNotAllowed     = NewHttpError(http.StatusMethodNotAllowed, "method not allowed")
+	HttpErrorBodyTooLarge         = NewHttpError(http.StatusRequestEntityTooLarge, "request body too large")
+	HttpErrorUnsupportedMediaType = NewHttpError(http.StatusUnsupportedMediaType, "unsupported media type")
+	HttpErrorTooManyRequests      = NewHttpError(http.StatusTooManyRequests, "too many requests")
+	HttpErrorServer               = NewHttpError(http.StatusInternalServerError, "internal server error")
+	HttpErrorTimeout              = NewHttpError(http.StatusGatewayTimeout, "timeout")
 )
 
 func NewHttpError(code int, msg string) *HttpError {
@@ -36,6 +54,10 @@ func NewHttpError(code int, msg string) *HttpError {
 	}
 }
 
+// ErrNoHttpRequest is returned by the http helpers on a session without http
+// request, e.g. a grpc call served by ServeGrpc
+var ErrNoHttpRequest = errors.New("no http request")
+
 func SendHttpError(sess *Session, httpCode int, msg string) error {
 	httpErr := HttpError{
 		Message: msg,
@@ -48,22 +70,43 @@ func SendHttpResult(sess *Session, result interface{}) error {
 	return SendHttp(sess, http.StatusOK, result)
 }
 
+// SendHttp encodes data with the codec negotiated from the Accept header of
+// the request, see NegotiateCodec. Data the codec can't encode, e.g. an
+// HttpError for a protobuf client, is sent as JSON
 func SendHttp(sess *Session, httpCode int, data interface{}) error {
-	bytes, err := json.Marshal(data)
+	codec := NegotiateCodec(sess.Request)
+	bytes, err := codec.Marshal(data)
+	if err != nil && codec.ContentType() != ContentTypeJSON {
+		codec = JSONCodec{}
+		bytes, err = codec.Marshal(data)
+	}
 	if err != nil {
 		return err
 	}
 
-	return SendHttpBinary(sess, httpCode, "applicatoin/json", bytes)
+	return SendHttpBinary(sess, httpCode, codec.ContentType(), bytes)
 }
 
 func SendHttpBinary(sess *Session, httpCode int, contentType string, data []byte) error {
 	rw := sess.ResponseWriter
+	if rw == nil {
+		return ErrNoHttpRequest
+	}
 	header := rw.Header()
 	header["Content-Type"] = []string{contentType}
 
 	rw.WriteHeader(httpCode)
 
+	if sess.recorder != nil {
+		sess.recorder.record(&RecordEvent{
+			Kind:   RecordOut,
+			Type:   "http",
+			Status: httpCode,
+			Header: sess.recorder.redact(header),
+			Data:   data,
+		})
+	}
+
 	_, err := rw.Write(data)
 	if err != nil {
 		return err
@@ -74,12 +117,19 @@ func SendHttpBinary(sess *Session, httpCode int, contentType string, data []byte
 
 func SendHttpChunk(sess *Session, data []byte) error {
 	rw := sess.ResponseWriter
+	if rw == nil {
+		return ErrNoHttpRequest
+	}
 
 	flusher, ok := rw.(http.Flusher)
 	if !ok {
 		return fmt.Errorf("expected http.ResponseWriter to be an http.Flusher")
 	}
 
+	if sess.recorder != nil {
+		sess.recorder.record(&RecordEvent{Kind: RecordOut, Type: "chunk", Data: data})
+	}
+
 	_, err := rw.Write(data)
 	if err != nil {
 		return err
@@ -98,10 +148,19 @@ func RequestReadJSON(req *http.Request, obj interface{}) error {
 	return decoder.Decode(obj)
 }
 
+// WithRequestID returns a Wrapper setting Session.RequestID from the
+// X-Request-ID header, or the x-request-id metadata of a grpc session
 func WithRequestID() Wrapper {
 	return func(sess *Session, action Action) error {
-		req := sess.Request
-		reqID := req.Header.Get("X-Request-ID")
+		var reqID string
+		if req := sess.Request; req != nil {
+			reqID = req.Header.Get("X-Request-ID")
+		} else if sess.Ctx != nil {
+			md, _ := metadata.FromIncomingContext(sess.Ctx)
+			if values := md.Get("x-request-id"); len(values) > 0 {
+				reqID = values[0]
+			}
+		}
 		if reqID == "" {
 			reqID = xid.New().String()
 		}
@@ -116,7 +175,7 @@ func WithReplyHttpError() Wrapper {
 		err := action(sess)
 		if err != nil {
 			if httpError, ok := err.(*HttpError); ok {
-				return SendHttpError(sess, httpError.StatusCode, httpError.Message)
+				return SendHttp(sess, httpError.StatusCode, httpError)
 			}
 
 			return SendHttpError(sess, HttpErrorServer.StatusCode, HttpErrorServer.Message)
@@ -0,0 +1,240 @@
+package framework
+
+import (
+	"errors"
+	"expvar"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OverflowPolicy decides what StreamPipeline does when its buffer is full
+type OverflowPolicy int
+
+const (
+	// OverflowBlock stops reading the websocket until the sender catches up
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest buffered frame
+	OverflowDropOldest
+	// OverflowFail aborts the stream with WsErrorBackpressure
+	OverflowFail
+)
+
+// QueryBackpressure is the query parameter of the websocket request by which
+// a client asks for TypeBackpressure messages, e.g. /websocket?backpressure=1
+const QueryBackpressure = "backpressure"
+
+// PipelineConfig configures the buffer between the websocket reader and the sender
+type PipelineConfig struct {
+	BufferSize int
+	Overflow   OverflowPolicy
+
+	// the client is sent a backpressure "on" message when the buffer depth
+	// reaches HighWatermark, and "off" once it drains to LowWatermark, if it
+	// asked for them with QueryBackpressure. 0 HighWatermark disables the signal
+	HighWatermark int
+	LowWatermark  int
+}
+
+var DefaultPipelineConfig = PipelineConfig{
+	BufferSize:    32,
+	Overflow:      OverflowBlock,
+	HighWatermark: 24,
+	LowWatermark:  8,
+}
+
+// PipelineStats are the flow-control metrics of one StreamPipeline
+type PipelineStats struct {
+	Received int64         `json:"received"`
+	Sent     int64         `json:"sent"`
+	Dropped  int64         `json:"dropped"`
+	MaxDepth int64         `json:"max_depth"`
+	Blocked  time.Duration `json:"blocked"` // time the reader waited on a full buffer
+	Signals  int64         `json:"signals"` // backpressure "on" messages sent to the client
+}
+
+// Backpressure is the data of a TypeBackpressure message
+type Backpressure struct {
+	State string `json:"state"` // "on" or "off"
+	Depth int    `json:"depth"`
+}
+
+var ErrPipelineStopped = errors.New("pipeline stopped")
+
+// process wide pipeline metrics, exported on /debug/vars
+var pipelineMetrics = expvar.NewMap("framework_pipeline")
+
+type pipeline struct {
+	sess *Session
+	cfg  PipelineConfig
+	buf  chan []byte
+	stop chan struct{}
+
+	stats PipelineStats
+	// optIn tells whether the client asked for backpressure messages
+	optIn bool
+
+	mu       sync.Mutex
+	signaled bool
+}
+
+// StreamPipeline reads the websocket stream in a goroutine and hands the
+// frames to send through a bounded buffer, so a slow sender (e.g. a grpc
+// stream) doesn't stall reading and a fast client is told to slow down.
+// When send fails the reader exits on the next frame, the session must not
+// read the websocket afterwards; closeWebsocket waits for it
+func StreamPipeline(sess *Session, cfg PipelineConfig, send func(data []byte) error) (*PipelineStats, error) {
+	p := &pipeline{
+		sess: sess,
+		cfg:  cfg,
+		buf:  make(chan []byte, cfg.BufferSize),
+		stop: make(chan struct{}),
+	}
+	if sess.Request != nil {
+		p.optIn, _ = strconv.ParseBool(sess.Request.URL.Query().Get(QueryBackpressure))
+	}
+
+	readErr := make(chan error, 1)
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		err := StreamForeach(sess, p.push)
+		close(p.buf)
+		readErr <- err
+	}()
+
+	var err error
+	for data := range p.buf {
+		p.signal(len(p.buf))
+
+		err = send(data)
+		if err != nil {
+			break
+		}
+		atomic.AddInt64(&p.stats.Sent, 1)
+	}
+
+	if err != nil {
+		// the reader exits on the next frame, a read deadline would break the
+		// connection and the close handshake with it
+		close(p.stop)
+		sess.ws.reader = readerDone
+	} else {
+		err = <-readErr
+	}
+
+	stats := p.snapshot()
+	p.record(&stats)
+	return &stats, err
+}
+
+func (p *pipeline) push(data []byte) error {
+	select {
+	case <-p.stop:
+		return ErrPipelineStopped
+	default:
+	}
+	atomic.AddInt64(&p.stats.Received, 1)
+
+	switch p.cfg.Overflow {
+	case OverflowDropOldest:
+		for {
+			select {
+			case p.buf <- data:
+				p.signal(len(p.buf))
+				return nil
+			default:
+			}
+
+			select {
+			case <-p.buf:
+				atomic.AddInt64(&p.stats.Dropped, 1)
+			default:
+			}
+		}
+	case OverflowFail:
+		select {
+		case p.buf <- data:
+		default:
+			p.sess.Errorf("StreamPipeline: buffer full")
+			return WsErrorBackpressure
+		}
+	default:
+		select {
+		case p.buf <- data:
+		default:
+			start := time.Now()
+			select {
+			case p.buf <- data:
+			case <-p.stop:
+				return ErrPipelineStopped
+			}
+			atomic.AddInt64((*int64)(&p.stats.Blocked), int64(time.Since(start)))
+		}
+	}
+
+	p.signal(len(p.buf))
+	return nil
+}
+
+// signal tracks the buffer depth and tells the client to slow down or resume
+func (p *pipeline) signal(depth int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if int64(depth) > p.stats.MaxDepth {
+		p.stats.MaxDepth = int64(depth)
+	}
+
+	if p.cfg.HighWatermark <= 0 || !p.optIn {
+		return
+	}
+
+	var state string
+	if !p.signaled && depth >= p.cfg.HighWatermark {
+		state = "on"
+		p.stats.Signals++
+	} else if p.signaled && depth <= p.cfg.LowWatermark {
+		state = "off"
+	} else {
+		return
+	}
+	p.signaled = !p.signaled
+
+	err := sendWs(p.sess, &WsResponse{
+		Type:      TypeBackpressure,
+		RequestID: p.sess.RequestID,
+		Data:      &Backpressure{State: state, Depth: depth},
+	})
+	if err != nil {
+		p.sess.Warningf("StreamPipeline: fail to send backpressure signal: %v", err)
+	}
+}
+
+// snapshot returns a copy of the stats, the reader may still update them
+func (p *pipeline) snapshot() PipelineStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return PipelineStats{
+		Received: atomic.LoadInt64(&p.stats.Received),
+		Sent:     atomic.LoadInt64(&p.stats.Sent),
+		Dropped:  atomic.LoadInt64(&p.stats.Dropped),
+		MaxDepth: p.stats.MaxDepth,
+		Blocked:  time.Duration(atomic.LoadInt64((*int64)(&p.stats.Blocked))),
+		Signals:  p.stats.Signals,
+	}
+}
+
+func (p *pipeline) record(stats *PipelineStats) {
+	pipelineMetrics.Add("sessions", 1)
+	pipelineMetrics.Add("received", stats.Received)
+	pipelineMetrics.Add("sent", stats.Sent)
+	pipelineMetrics.Add("dropped", stats.Dropped)
+	pipelineMetrics.Add("blocked_ms", stats.Blocked.Milliseconds())
+	pipelineMetrics.Add("signals", stats.Signals)
+
+	p.sess.Infof("StreamPipeline: received %d, sent %d, dropped %d, max depth %d, blocked %s, signals %d",
+		stats.Received, stats.Sent, stats.Dropped, stats.MaxDepth, stats.Blocked, stats.Signals)
+}
@@ -0,0 +1,84 @@
+package framework
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestStreamPipelineSendFailureCloses(t *testing.T) {
+	conn := dialWs(t, DefaultWsConfig, func(sess *Session) error {
+		_, err := StreamPipeline(sess, DefaultPipelineConfig, func([]byte) error {
+			return errors.New("backend gone")
+		})
+		return err
+	})
+
+	conn.WriteMessage(websocket.BinaryMessage, []byte("data"))
+
+	var resp WsResponse
+	if err := conn.ReadJSON(&resp); err != nil || resp.Type != TypeError {
+		t.Fatalf("expect error, got %+v, %v", resp, err)
+	}
+
+	// the close handshake completes, the reader of the pipeline is gone
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Fatalf("expect normal close, got %v", err)
+	}
+}
+
+func TestStreamPipelineBackpressureOptIn(t *testing.T) {
+	cases := []struct {
+		query  string
+		expect bool
+	}{
+		{"", false},
+		{QueryBackpressure + "=1", true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			cfg := PipelineConfig{BufferSize: 4, Overflow: OverflowBlock, HighWatermark: 2, LowWatermark: 0}
+			release := make(chan struct{})
+			conn := dialWsQuery(t, DefaultWsConfig, c.query, func(sess *Session) error {
+				stats, err := StreamPipeline(sess, cfg, func([]byte) error {
+					<-release
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+				return SendWsResult(sess, stats)
+			})
+
+			for i := 0; i < 4; i++ {
+				conn.WriteMessage(websocket.BinaryMessage, []byte("data"))
+			}
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			conn.WriteMessage(websocket.BinaryMessage, EOS)
+
+			signaled := false
+			for {
+				var resp WsResponse
+				if err := conn.ReadJSON(&resp); err != nil {
+					t.Fatalf("fail to read: %v", err)
+				}
+				if resp.Type == TypeBackpressure {
+					signaled = true
+					continue
+				}
+				if resp.Type != TypeSuccess {
+					t.Fatalf("expect success, got %+v", resp)
+				}
+				break
+			}
+			if signaled != c.expect {
+				t.Fatalf("expect backpressure messages %v, got %v", c.expect, signaled)
+			}
+		})
+	}
+}
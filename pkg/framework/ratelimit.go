@@ -0,0 +1,83 @@
+package framework
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+// RateLimit configures a RateLimiter, 0 RPS disables it
+type RateLimit struct {
+	// RPS is the rate of sessions allowed per second
+	RPS float64 `json:"rps"`
+	// Burst is the number of sessions allowed at once above the rate, at least 1
+	Burst int `json:"burst"`
+}
+
+// Enabled tells whether the config limits the rate
+func (p *RateLimit) Enabled() bool {
+	return p.RPS > 0
+}
+
+// process wide rate limit metrics by handler name, exported on /debug/vars
+var rateLimitMetrics = expvar.NewMap("framework_ratelimit")
+
+// RateLimiter is a token bucket shared by the sessions of a handler
+type RateLimiter struct {
+	cfg RateLimit
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(cfg RateLimit) *RateLimiter {
+	if cfg.Burst < 1 {
+		cfg.Burst = 1
+	}
+
+	return &RateLimiter{
+		cfg:    cfg,
+		tokens: float64(cfg.Burst),
+		last:   time.Now(),
+	}
+}
+
+// Allow takes a token, it tells false when the bucket is empty
+func (p *RateLimiter) Allow() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	p.tokens += now.Sub(p.last).Seconds() * p.cfg.RPS
+	if p.tokens > float64(p.cfg.Burst) {
+		p.tokens = float64(p.cfg.Burst)
+	}
+	p.last = now
+
+	if p.tokens < 1 {
+		return false
+	}
+	p.tokens--
+
+	return true
+}
+
+// WithRateLimit returns a Wrapper rejecting the sessions above the rate of
+// limiter, with HttpErrorTooManyRequests or WsErrorRateLimited.
+// Like WithFaultInjection, it should be installed after WithWebsocket and the
+// error reply wrappers
+func WithRateLimit(limiter *RateLimiter) Wrapper {
+	return func(sess *Session, action Action) error {
+		if !limiter.Allow() {
+			rateLimitMetrics.Add(sess.Name, 1)
+			sess.Warningf("WithRateLimit: reject session above %g rps", limiter.cfg.RPS)
+			if sess.WsConn != nil {
+				return WsErrorRateLimited
+			}
+			return HttpErrorTooManyRequests
+		}
+
+		return action(sess)
+	}
+}
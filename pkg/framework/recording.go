@@ -0,0 +1,338 @@
+package framework
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/xid"
+)
+
+// Recording format
+//
+// A recording is a JSON Lines file, one RecordEvent per line:
+//   - the first event is "session": handler name, method, url, request headers
+//     and whether the session is a websocket or a grpc call
+//   - "in" events are inbound data: websocket frames (type "text", "binary" or
+//     "close") or chunks of the http request body (type "body")
+//   - "out" events are outbound data: websocket messages (type "text" or
+//     "binary"), http responses (type "http", with status and headers) or
+//     http chunks (type "chunk")
+//   - the last event is "end", carrying the request id and the error if any
+//
+// Offset is the time since the session started in microseconds, Data is base64
+// encoded as usual for []byte in JSON.
+const (
+	RecordSession = "session"
+	RecordIn      = "in"
+	RecordOut     = "out"
+	RecordEnd     = "end"
+)
+
+type RecordEvent struct {
+	Kind   string      `json:"kind"`
+	Offset int64       `json:"offset_us"`
+	Type   string      `json:"type,omitempty"`
+	Data   []byte      `json:"data,omitempty"`
+	Status int         `json:"status,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+
+	// session
+	Name      string     `json:"name,omitempty"`
+	Time      *time.Time `json:"time,omitempty"`
+	Method    string     `json:"method,omitempty"`
+	URL       string     `json:"url,omitempty"`
+	Websocket bool       `json:"websocket,omitempty"`
+	// Grpc is set for the calls of the grpc listener, URL is then the method
+	Grpc bool `json:"grpc,omitempty"`
+
+	// end
+	RequestID string `json:"request_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// RecordingConfig configures WithRecordingConfig
+type RecordingConfig struct {
+	// Dir receives the recordings
+	Dir string
+	// AllowHeaders are sensitive headers recorded in clear, see SensitiveHeader
+	AllowHeaders []string
+	// RedactQuery are query parameters redacted from the url on top of the
+	// sensitive ones, see SensitiveQuery
+	RedactQuery []string
+}
+
+// RedactedValue replaces the values of the sensitive headers and query
+// parameters in recordings
+const RedactedValue = "REDACTED"
+
+// sensitiveHeaders are redacted from recordings, as are the headers whose name
+// contains one of sensitiveWords
+var (
+	sensitiveHeaders = map[string]bool{
+		"Authorization":       true,
+		"Proxy-Authorization": true,
+		"Cookie":              true,
+		"Set-Cookie":          true,
+	}
+	sensitiveWords = []string{"token", "secret", "password", "api-key", "apikey", "session", "auth"}
+)
+
+// SensitiveHeader tells whether the header may carry credentials, its values
+// are then redacted from recordings unless allowed by RecordingConfig
+func SensitiveHeader(name string) bool {
+	name = http.CanonicalHeaderKey(name)
+	if sensitiveHeaders[name] {
+		return true
+	}
+
+	return sensitiveName(name)
+}
+
+// SensitiveQuery tells whether the query parameter may carry credentials, e.g.
+// token or access_token, its values are then redacted from recordings
+func SensitiveQuery(name string) bool {
+	return sensitiveName(strings.ReplaceAll(name, "_", "-"))
+}
+
+func sensitiveName(name string) bool {
+	lower := strings.ToLower(name)
+	for _, word := range sensitiveWords {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type recorder struct {
+	allow       map[string]bool
+	redactQuery map[string]bool
+
+	mu    sync.Mutex
+	start time.Time
+	file  *os.File
+	buf   *bufio.Writer
+	enc   *json.Encoder
+	err   error
+}
+
+func (p *recorder) record(event *RecordEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return
+	}
+	event.Offset = time.Since(p.start).Microseconds()
+	p.err = p.enc.Encode(event)
+}
+
+// redactURL returns the request uri of u with the sensitive query values redacted
+func (p *recorder) redactURL(u *url.URL) string {
+	query := u.Query()
+	redacted := false
+	for name := range query {
+		if SensitiveQuery(name) || p.redactQuery[strings.ToLower(name)] {
+			query[name] = []string{RedactedValue}
+			redacted = true
+		}
+	}
+	if !redacted {
+		return u.RequestURI()
+	}
+
+	ret := *u
+	ret.RawQuery = query.Encode()
+	return ret.RequestURI()
+}
+
+// redact returns a copy of header with the sensitive values redacted
+func (p *recorder) redact(header http.Header) http.Header {
+	ret := make(http.Header, len(header))
+	for name, values := range header {
+		if SensitiveHeader(name) && !p.allow[http.CanonicalHeaderKey(name)] {
+			values = []string{RedactedValue}
+		}
+		ret[name] = values
+	}
+
+	return ret
+}
+
+func (p *recorder) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	err := p.err
+	if ferr := p.buf.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := p.file.Close(); err == nil {
+		err = cerr
+	}
+	// drop events of goroutines outliving the session
+	p.err = os.ErrClosed
+
+	return err
+}
+
+func messageTypeName(messageType int) string {
+	switch messageType {
+	case websocket.TextMessage:
+		return "text"
+	case websocket.BinaryMessage:
+		return "binary"
+	case websocket.CloseMessage:
+		return "close"
+	default:
+		return fmt.Sprintf("%d", messageType)
+	}
+}
+
+func recordFrame(sess *Session, kind string, messageType int, data []byte) {
+	if sess.recorder == nil {
+		return
+	}
+
+	sess.recorder.record(&RecordEvent{
+		Kind: kind,
+		Type: messageTypeName(messageType),
+		Data: data,
+	})
+}
+
+// recordingBody records the http request body as it is read
+type recordingBody struct {
+	io.ReadCloser
+	recorder *recorder
+}
+
+func (p *recordingBody) Read(b []byte) (int, error) {
+	n, err := p.ReadCloser.Read(b)
+	if n > 0 {
+		data := make([]byte, n)
+		copy(data, b[:n])
+		p.recorder.record(&RecordEvent{Kind: RecordIn, Type: "body", Data: data})
+	}
+
+	return n, err
+}
+
+// WithRecording returns a Wrapper recording the session to a new file in dir,
+// see RecordEvent for the format. Sensitive headers are redacted, grpc calls
+// are recorded without their messages.
+// It should be the most outside wrapper so that error replies are recorded too
+func WithRecording(dir string) Wrapper {
+	return WithRecordingConfig(RecordingConfig{Dir: dir})
+}
+
+// WithRecordingConfig returns a Wrapper recording the session, see WithRecording
+func WithRecordingConfig(cfg RecordingConfig) Wrapper {
+	allow := make(map[string]bool, len(cfg.AllowHeaders))
+	for _, name := range cfg.AllowHeaders {
+		allow[http.CanonicalHeaderKey(name)] = true
+	}
+	redactQuery := make(map[string]bool, len(cfg.RedactQuery))
+	for _, name := range cfg.RedactQuery {
+		redactQuery[strings.ToLower(name)] = true
+	}
+
+	return func(sess *Session, action Action) error {
+		start := time.Now()
+		name := fmt.Sprintf("%s-%s-%s.jsonl", start.UTC().Format("20060102T150405.000"), sess.Name, xid.New().String())
+
+		file, err := os.Create(filepath.Join(cfg.Dir, name))
+		if err != nil {
+			sess.Errorf("WithRecording: fail to create recording: %v", err)
+			return action(sess)
+		}
+
+		buf := bufio.NewWriter(file)
+		rec := &recorder{
+			allow:       allow,
+			redactQuery: redactQuery,
+			start:       start,
+			file:        file,
+			buf:         buf,
+			enc:         json.NewEncoder(buf),
+		}
+
+		startUTC := start.UTC()
+		event := &RecordEvent{
+			Kind: RecordSession,
+			Name: sess.Name,
+			Time: &startUTC,
+		}
+		if req := sess.Request; req != nil {
+			event.Method = req.Method
+			event.URL = rec.redactURL(req.URL)
+			event.Header = rec.redact(req.Header)
+			event.Websocket = websocket.IsWebSocketUpgrade(req)
+			if req.Body != nil {
+				req.Body = &recordingBody{ReadCloser: req.Body, recorder: rec}
+			}
+		} else {
+			// grpc call, its messages are not recorded
+			event.Method = http.MethodPost
+			event.URL = sess.GrpcMethod
+			event.Grpc = true
+			event.Header = rec.redact(grpcHeader(sess))
+		}
+		rec.record(event)
+		sess.recorder = rec
+
+		err = action(sess)
+
+		end := &RecordEvent{
+			Kind:      RecordEnd,
+			RequestID: sess.RequestID,
+		}
+		if err != nil {
+			end.Error = err.Error()
+		}
+		rec.record(end)
+
+		if cerr := rec.close(); cerr != nil {
+			sess.Errorf("WithRecording: fail to write recording: %v", cerr)
+		} else {
+			sess.Infof("WithRecording: session recorded to %s", name)
+		}
+
+		return err
+	}
+}
+
+// ReadRecording reads the events of a recording
+func ReadRecording(r io.Reader) ([]*RecordEvent, error) {
+	var ret []*RecordEvent
+
+	decoder := json.NewDecoder(r)
+	for {
+		event := new(RecordEvent)
+		err := decoder.Decode(event)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, event)
+	}
+
+	if len(ret) == 0 || ret[0].Kind != RecordSession {
+		return nil, fmt.Errorf("invalid recording: missing session event")
+	}
+
+	return ret, nil
+}
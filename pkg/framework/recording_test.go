@@ -0,0 +1,64 @@
+package framework
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordingRedactsHeaders(t *testing.T) {
+	dir := t.TempDir()
+	handler := &Handler{Name: "test", OnError: LogError, OnPanic: LogPanic}
+	handler.Use(WithRecordingConfig(RecordingConfig{Dir: dir, AllowHeaders: []string{"x-tenant-token"}, RedactQuery: []string{"sig"}}), WithReplyHttpError())
+	handler.Add(func(sess *Session) error {
+		sess.ResponseWriter.Header().Set("Set-Cookie", "sid=secret")
+		return SendHttpResult(sess, "ok")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/?access_token=secret&Token=secret&sig=secret&page=2", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Cookie", "sid=secret")
+	req.Header.Set("X-Api-Key", "secret")
+	req.Header.Set("X-Tenant-Token", "tenant")
+	req.Header.Set("Accept", "application/json")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if len(files) != 1 {
+		t.Fatalf("expect 1 recording, got %v", files)
+	}
+	file, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	events, err := ReadRecording(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if url := events[0].URL; url != "/?Token=REDACTED&access_token=REDACTED&page=2&sig=REDACTED" {
+		t.Errorf("unexpected url %s", url)
+	}
+
+	header := events[0].Header
+	for name, want := range map[string]string{
+		"Authorization":  RedactedValue,
+		"Cookie":         RedactedValue,
+		"X-Api-Key":      RedactedValue,
+		"X-Tenant-Token": "tenant",
+		"Accept":         "application/json",
+	} {
+		if got := header.Get(name); got != want {
+			t.Errorf("header %s: expect %q, got %q", name, want, got)
+		}
+	}
+
+	for _, event := range events {
+		if event.Type == "http" && event.Header.Get("Set-Cookie") != RedactedValue {
+			t.Errorf("response Set-Cookie not redacted: %v", event.Header)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package framework
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Router dispatches requests to Handlers by method and path.
+// Paths are matched by a radix tree, a "{name}" segment matches any non empty
+// segment and is available to the actions with Session.Param. Static segments
+// take precedence over parameters, e.g. "/v1/greet/all" over "/v1/greet/{person}".
+// A trailing slash is part of the path, "/httpcase/" doesn't match "/httpcase".
+// Unknown paths are answered by NotFound, known paths with another method by
+// MethodNotAllowed
+type Router struct {
+	NotFound         http.Handler
+	MethodNotAllowed http.Handler
+
+	root   node
+	routes []Route
+}
+
+// Route is an entry of the route table
+type Route struct {
+	Method  string
+	Pattern string
+	// Name of the Handler, empty for other http.Handlers
+	Name string
+	// Handler is nil for other http.Handlers
+	Handler *Handler
+}
+
+type node struct {
+	prefix   string
+	children []*node
+	// param is the "{name}" child, it has no prefix
+	param *node
+	name  string
+	// handlers by method of the route ending at this node
+	handlers map[string]http.Handler
+}
+
+type paramsKey struct{}
+
+type allowKey struct{}
+
+func NewRouter() *Router {
+	return &Router{
+		NotFound:         errorHandler("notFound", HttpErrorNotFound),
+		MethodNotAllowed: errorHandler("methodNotAllowed", HttpErrorMethodNotAllowed),
+	}
+}
+
+// errorHandler replies err through WithReplyHttpError
+func errorHandler(name string, err *HttpError) *Handler {
+	ret := &Handler{
+		Name:    name,
+		OnError: LogError,
+		OnPanic: LogPanic,
+	}
+	ret.Use(WithRequestID(), WithReplyHttpError())
+	ret.Add(func(sess *Session) error {
+		if allow, ok := sess.Request.Context().Value(allowKey{}).(string); ok {
+			sess.ResponseWriter.Header().Set("Allow", allow)
+		}
+		return err
+	})
+
+	return ret
+}
+
+// Handle registers handler for method and pattern
+func (p *Router) Handle(method, pattern string, handler *Handler) {
+	p.handle(method, pattern, handler, handler)
+}
+
+// Mount registers a plain http.Handler for method and pattern, e.g. expvar.Handler()
+func (p *Router) Mount(method, pattern string, handler http.Handler) {
+	p.handle(method, pattern, nil, handler)
+}
+
+func (p *Router) handle(method, pattern string, h *Handler, handler http.Handler) {
+	err := checkPattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+
+	n := p.root.insert(pattern)
+	if n.handlers == nil {
+		n.handlers = make(map[string]http.Handler)
+	}
+	if _, ok := n.handlers[method]; ok {
+		panic(fmt.Sprintf("router: duplicated route %s %s", method, pattern))
+	}
+	n.handlers[method] = handler
+
+	route := Route{Method: method, Pattern: pattern, Handler: h}
+	if h != nil {
+		route.Name = h.Name
+	}
+	p.routes = append(p.routes, route)
+}
+
+// Group returns a group of routes under prefix
+func (p *Router) Group(prefix string) *RouteGroup {
+	return &RouteGroup{router: p, prefix: prefix}
+}
+
+// Routes returns the route table sorted by pattern and method
+func (p *Router) Routes() []Route {
+	ret := append([]Route(nil), p.routes...)
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Pattern != ret[j].Pattern {
+			return ret[i].Pattern < ret[j].Pattern
+		}
+		return ret[i].Method < ret[j].Method
+	})
+
+	return ret
+}
+
+// PipelinesHandler renders the pipeline of each route, see Handler.Describe
+func (p *Router) PipelinesHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, route := range p.Routes() {
+			fmt.Fprintf(rw, "%-6s %s\n", route.Method, route.Pattern)
+			if route.Handler != nil {
+				fmt.Fprintf(rw, "       %s: %s\n", route.Name, route.Handler.Describe())
+			}
+		}
+	})
+}
+
+func (p *Router) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	params := make(map[string]string)
+	n := p.root.lookup(req.URL.Path, params)
+	if n == nil {
+		p.NotFound.ServeHTTP(rw, req)
+		return
+	}
+
+	handler, ok := n.handlers[req.Method]
+	if !ok && req.Method == http.MethodHead {
+		handler, ok = n.handlers[http.MethodGet]
+	}
+	if !ok {
+		allow := make([]string, 0, len(n.handlers))
+		for method := range n.handlers {
+			allow = append(allow, method)
+		}
+		sort.Strings(allow)
+		ctx := context.WithValue(req.Context(), allowKey{}, strings.Join(allow, ", "))
+		p.MethodNotAllowed.ServeHTTP(rw, req.WithContext(ctx))
+		return
+	}
+
+	if len(params) > 0 {
+		req = req.WithContext(context.WithValue(req.Context(), paramsKey{}, params))
+	}
+	handler.ServeHTTP(rw, req)
+}
+
+// checkPattern checks that pattern is absolute and its parameters are whole
+// named segments
+func checkPattern(pattern string) error {
+	if !strings.HasPrefix(pattern, "/") {
+		return fmt.Errorf("router: pattern '%s' must begin with '/'", pattern)
+	}
+
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '{':
+			end := strings.IndexByte(pattern[i:], '}')
+			if pattern[i-1] != '/' || end < 0 {
+				return fmt.Errorf("router: invalid parameter in pattern '%s'", pattern)
+			}
+			end += i
+			if end == i+1 || strings.ContainsAny(pattern[i+1:end], "{/") {
+				return fmt.Errorf("router: invalid parameter in pattern '%s'", pattern)
+			}
+			if end+1 < len(pattern) && pattern[end+1] != '/' {
+				return fmt.Errorf("router: parameter must be a whole segment in pattern '%s'", pattern)
+			}
+			i = end
+		case '}':
+			return fmt.Errorf("router: invalid parameter in pattern '%s'", pattern)
+		}
+	}
+
+	return nil
+}
+
+// insert returns the node of path, creating it if needed
+func (p *node) insert(path string) *node {
+	if path == "" {
+		return p
+	}
+
+	if path[0] == '{' {
+		end := strings.IndexByte(path, '}')
+		name := path[1:end]
+		if p.param == nil {
+			p.param = &node{name: name}
+		} else if p.param.name != name {
+			panic(fmt.Sprintf("router: parameter {%s} conflicts with {%s}", name, p.param.name))
+		}
+		return p.param.insert(path[end+1:])
+	}
+
+	static := path
+	if i := strings.IndexByte(path, '{'); i >= 0 {
+		static = path[:i]
+	}
+
+	for _, child := range p.children {
+		n := commonPrefix(child.prefix, static)
+		if n == 0 {
+			continue
+		}
+
+		if n < len(child.prefix) {
+			// split the child at the common prefix
+			*child = node{
+				prefix: child.prefix[:n],
+				children: []*node{{
+					prefix:   child.prefix[n:],
+					children: child.children,
+					param:    child.param,
+					handlers: child.handlers,
+				}},
+			}
+		}
+		return child.insert(path[n:])
+	}
+
+	child := &node{prefix: static}
+	p.children = append(p.children, child)
+	return child.insert(path[len(static):])
+}
+
+func commonPrefix(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+
+	return n
+}
+
+// lookup returns the node with handlers matching path, static children first
+func (p *node) lookup(path string, params map[string]string) *node {
+	if path == "" {
+		if p.handlers != nil {
+			return p
+		}
+		return nil
+	}
+
+	for _, child := range p.children {
+		if strings.HasPrefix(path, child.prefix) {
+			if ret := child.lookup(path[len(child.prefix):], params); ret != nil {
+				return ret
+			}
+		}
+	}
+
+	if p.param != nil {
+		end := strings.IndexByte(path, '/')
+		if end < 0 {
+			end = len(path)
+		}
+		if end > 0 {
+			if ret := p.param.lookup(path[end:], params); ret != nil {
+				params[p.param.name] = path[:end]
+				return ret
+			}
+		}
+	}
+
+	return nil
+}
+
+// RouteGroup registers routes under a prefix, wrapped by the wrappers of the
+// group and of its parents
+type RouteGroup struct {
+	router   *Router
+	prefix   string
+	wrappers []namedWrapper
+}
+
+// Use adds wrappers to the routes registered afterwards, they run before the
+// wrappers of the handlers
+func (p *RouteGroup) Use(wrappers ...Wrapper) {
+	p.wrappers = append(p.wrappers, nameWrappers(wrappers)...)
+}
+
+// Group returns a sub group under prefix, inheriting the wrappers of p
+func (p *RouteGroup) Group(prefix string) *RouteGroup {
+	return &RouteGroup{
+		router:   p.router,
+		prefix:   p.prefix + prefix,
+		wrappers: append([]namedWrapper(nil), p.wrappers...),
+	}
+}
+
+// Handle registers a copy of handler wrapped by the group wrappers, so that
+// a handler can be shared by several groups
+func (p *RouteGroup) Handle(method, pattern string, handler *Handler) {
+	h := *handler
+	// the copy owns its slices, so that Use or Add on one copy or on handler
+	// don't write to the others
+	h.wrappers = append(append([]namedWrapper(nil), p.wrappers...), handler.wrappers...)
+	h.actions = append([]Action(nil), handler.actions...)
+	h.build()
+	p.router.Handle(method, p.prefix+pattern, &h)
+}
+
+// Mount registers a plain http.Handler under the group prefix, it is not
+// wrapped by the group wrappers
+func (p *RouteGroup) Mount(method, pattern string, handler http.Handler) {
+	p.router.Mount(method, p.prefix+pattern, handler)
+}
+
+// SwapHandler serves the last http.Handler stored, so that a route table is
+// replaced without restart. Requests in flight, e.g. websocket sessions, end
+// with the handler they started with
+type SwapHandler struct {
+	handler atomic.Value
+}
+
+// storedHandler keeps the concrete type of the atomic.Value the same
+type storedHandler struct {
+	http.Handler
+}
+
+func NewSwapHandler(handler http.Handler) *SwapHandler {
+	ret := new(SwapHandler)
+	ret.Store(handler)
+
+	return ret
+}
+
+// Store replaces the handler of the next requests
+func (p *SwapHandler) Store(handler http.Handler) {
+	p.handler.Store(storedHandler{handler})
+}
+
+// Load returns the current handler
+func (p *SwapHandler) Load() http.Handler {
+	return p.handler.Load().(storedHandler).Handler
+}
+
+func (p *SwapHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	p.Load().ServeHTTP(rw, req)
+}
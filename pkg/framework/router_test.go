@@ -0,0 +1,142 @@
+package framework
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// routeHandler replies its name and the {person} parameter
+func routeHandler(name string) *Handler {
+	ret := &Handler{Name: name, OnError: LogError, OnPanic: LogPanic}
+	ret.Use(WithReplyHttpError())
+	ret.Add(func(sess *Session) error {
+		fmt.Fprintf(sess.ResponseWriter, "%s %s", name, sess.Param("person"))
+		return nil
+	})
+
+	return ret
+}
+
+func TestRouter(t *testing.T) {
+	router := NewRouter()
+	router.Handle(http.MethodGet, "/httpcase", routeHandler("httpcase"))
+	router.Handle(http.MethodGet, "/v1/greet/{person}", routeHandler("greet"))
+	router.Handle(http.MethodGet, "/v1/greet/all", routeHandler("all"))
+	router.Handle(http.MethodPost, "/v1/greet/all", routeHandler("postAll"))
+	router.Handle(http.MethodGet, "/v1/greet/{person}/fruits", routeHandler("fruits"))
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		body   string
+		allow  string
+	}{
+		{method: "GET", path: "/httpcase", status: 200, body: "httpcase "},
+		{method: "HEAD", path: "/httpcase", status: 200},
+
+		// static segments take precedence over parameters
+		{method: "GET", path: "/v1/greet/all", status: 200, body: "all "},
+		{method: "POST", path: "/v1/greet/all", status: 200, body: "postAll "},
+		{method: "GET", path: "/v1/greet/Bob", status: 200, body: "greet Bob"},
+		{method: "GET", path: "/v1/greet/alice", status: 200, body: "greet alice"},
+		{method: "GET", path: "/v1/greet/allx", status: 200, body: "greet allx"},
+		{method: "GET", path: "/v1/greet/Bob/fruits", status: 200, body: "fruits Bob"},
+
+		// known paths with another method
+		{method: "POST", path: "/httpcase", status: 405, allow: "GET"},
+		{method: "DELETE", path: "/v1/greet/all", status: 405, allow: "GET, POST"},
+		{method: "POST", path: "/v1/greet/Bob", status: 405, allow: "GET"},
+
+		// a trailing slash is part of the path, parameters are not empty
+		{method: "GET", path: "/httpcase/", status: 404},
+		{method: "GET", path: "/v1/greet/", status: 404},
+		{method: "GET", path: "/v1/greet/Bob/", status: 404},
+		{method: "GET", path: "/v1/greet//fruits", status: 404},
+		{method: "GET", path: "/unknown", status: 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if rec.Code != tt.status {
+				t.Fatalf("expect status %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+			if tt.body != "" && rec.Body.String() != tt.body {
+				t.Fatalf("expect body %q, got %q", tt.body, rec.Body.String())
+			}
+			if allow := rec.Header().Get("Allow"); allow != tt.allow {
+				t.Fatalf("expect Allow %q, got %q", tt.allow, allow)
+			}
+		})
+	}
+}
+
+func TestRouterInvalidPatterns(t *testing.T) {
+	patterns := []string{
+		"httpcase",
+		"/v1/greet/{person",
+		"/v1/greet/{}",
+		"/v1/greet/x{person}",
+		"/v1/greet/{person}x",
+		"/v1/greet/person}",
+	}
+	for _, pattern := range patterns {
+		if err := checkPattern(pattern); err == nil {
+			t.Errorf("expect pattern '%s' to be invalid", pattern)
+		}
+	}
+
+	router := NewRouter()
+	router.Handle(http.MethodGet, "/v1/greet/{person}", routeHandler("greet"))
+	for _, pattern := range []string{"/v1/greet/{person}", "/v1/greet/{name}/fruits"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expect a panic registering '%s'", pattern)
+				}
+			}()
+			router.Handle(http.MethodGet, pattern, routeHandler("dup"))
+		}()
+	}
+}
+
+func TestRouteGroupSharedHandler(t *testing.T) {
+	handler := &Handler{Name: "shared", OnError: LogError, OnPanic: LogPanic}
+	// 3 actions leave room for another one in the slice
+	for _, word := range []string{"a", "b", "c"} {
+		word := word
+		handler.Add(func(sess *Session) error {
+			fmt.Fprint(sess.ResponseWriter, word)
+			return nil
+		})
+	}
+	router := NewRouter()
+	router.Group("/v1").Handle(http.MethodGet, "/shared", handler)
+	router.Group("/v2").Handle(http.MethodGet, "/shared", handler)
+
+	// each copy gets its own last action
+	for _, route := range router.Routes() {
+		prefix := route.Pattern[:3]
+		route.Handler.Add(func(sess *Session) error {
+			fmt.Fprint(sess.ResponseWriter, prefix)
+			return nil
+		})
+	}
+
+	for path, want := range map[string]string{"/v1/shared": "abc/v1", "/v2/shared": "abc/v2"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Body.String() != want {
+			t.Errorf("%s: expect %q, got %q", path, want, rec.Body.String())
+		}
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Body.String() != "abc" {
+		t.Errorf("expect the shared handler unchanged, got %q", rec.Body.String())
+	}
+}
@@ -2,6 +2,8 @@ package framework
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"net/http"
 	"time"
@@ -9,6 +11,8 @@ import (
 	"github.com/golang/glog"
 	"github.com/gorilla/websocket"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
 )
 
 type Session struct {
@@ -22,14 +26,84 @@ type Session struct {
 	RequestID string
 	StartTime time.Time
 
+	// GrpcStream and GrpcMethod are the call of a grpc session, see ServeGrpc
+	GrpcStream grpc.ServerStream
+	GrpcMethod string
+
 	keys map[string]interface{}
+
+	wsWriter *wsWriter
+	framing  StreamFraming
+	wsCodec  WsCodec
+	// ws is shared with the branches of the session, see Timeout
+	ws *wsState
+
+	maxFrameSize    int64
+	maxSessionBytes int64
+
+	recorder *recorder
+	fault    *faultState
 }
 
-func (p *Session) Set(key string, value interface{}) {
+// branch returns a copy of the session with ctx, sharing the keys and the
+// websocket state of the session
+func (p *Session) branch(ctx context.Context) *Session {
+	p.initKeys()
+	ret := *p
+	ret.Ctx = ctx
+	return &ret
+}
+
+// merge copies back the fields set on branch by its wrappers, e.g. the grpc
+// connections of WithGrpc or the websocket of WithWebsocket, but the context.
+// Unchanged fields are not written, so that a branch of a Parallel which
+// doesn't set any doesn't race with the others
+func (p *Session) merge(branch *Session) {
+	if branch.Request != p.Request {
+		p.Request = branch.Request
+	}
+	if branch.RequestID != p.RequestID {
+		p.RequestID = branch.RequestID
+	}
+	if !sameConns(branch.GrpcConns, p.GrpcConns) {
+		p.GrpcConns = branch.GrpcConns
+	}
+	// the websocket fields are set together with ws
+	if branch.ws != p.ws {
+		p.WsConn, p.wsWriter, p.ws = branch.WsConn, branch.wsWriter, branch.ws
+		p.framing, p.wsCodec = branch.framing, branch.wsCodec
+		p.maxFrameSize, p.maxSessionBytes = branch.maxFrameSize, branch.maxSessionBytes
+	}
+	if branch.recorder != p.recorder {
+		p.recorder = branch.recorder
+	}
+	if branch.fault != p.fault {
+		p.fault = branch.fault
+	}
+}
+
+func sameConns(a, b []*grpc.ClientConn) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// initKeys allocates the keys before they are shared, e.g. by branch
+func (p *Session) initKeys() {
 	if p.keys == nil {
 		p.keys = make(map[string]interface{})
 	}
+}
 
+func (p *Session) Set(key string, value interface{}) {
+	p.initKeys()
 	p.keys[key] = value
 }
 
@@ -47,6 +121,55 @@ func (p *Session) MustGet(key string) interface{} {
 	panic("Key '" + key + "' not found")
 }
 
+// Param returns the path parameter name of the route, see Router
+func (p *Session) Param(name string) string {
+	if p.Request == nil {
+		return ""
+	}
+
+	params, _ := p.Request.Context().Value(paramsKey{}).(map[string]string)
+	return params[name]
+}
+
+// Identity is the client identity verified by mTLS
+type Identity struct {
+	CommonName string
+	DNSNames   []string
+	URIs       []string
+
+	Certificate *x509.Certificate
+}
+
+// Identity returns the identity of the client certificate verified by mTLS,
+// of the http request or of the grpc call. It is nil without one
+func (p *Session) Identity() *Identity {
+	var state *tls.ConnectionState
+	if p.Request != nil {
+		state = p.Request.TLS
+	} else if p.Ctx != nil {
+		if pr, ok := peer.FromContext(p.Ctx); ok {
+			if info, ok := pr.AuthInfo.(credentials.TLSInfo); ok {
+				state = &info.State
+			}
+		}
+	}
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	cert := state.VerifiedChains[0][0]
+	ret := &Identity{
+		CommonName:  cert.Subject.CommonName,
+		DNSNames:    cert.DNSNames,
+		Certificate: cert,
+	}
+	for _, uri := range cert.URIs {
+		ret.URIs = append(ret.URIs, uri.String())
+	}
+
+	return ret
+}
+
 const LogPrefixFormat = "[%s]-[%s]:"
 
 func (p *Session) Info(args ...interface{}) {
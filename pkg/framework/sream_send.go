@@ -3,6 +3,10 @@ package framework
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/gorilla/websocket"
 )
 
 var EOS = []byte{0x45, 0x4f, 0x53}
@@ -11,35 +15,191 @@ func IsEOS(data []byte) bool {
 	return bytes.Equal(data, EOS)
 }
 
-func streamForeach(sess *Session, foreach func(data []byte) error, stopped func() bool) error {
+// FrameKind classifies an inbound websocket frame
+type FrameKind int
+
+const (
+	FrameData FrameKind = iota
+	FrameEnd
+	FrameControl
+)
+
+// Frame is an inbound websocket frame decoded by a StreamFraming
+type Frame struct {
+	Kind    FrameKind
+	Data    []byte
+	Control *ControlMessage
+}
+
+// StreamFraming decides which inbound websocket frames carry data and which
+// one ends the stream.
+// A close frame from the peer is passed to Decode as websocket.CloseMessage
+// with the close text as data; returning an error keeps it a read error.
+type StreamFraming interface {
+	Decode(messageType int, data []byte) (*Frame, error)
+}
+
+var (
+	// LegacyEOSFraming ends the stream with the binary frame "EOS", any other frame is data
+	LegacyEOSFraming StreamFraming = legacyEOSFraming{}
+	// JSONControlFraming carries data in binary frames and control messages
+	// in text frames, the stream ends with {"type":"end"}. See ControlProtocol
+	JSONControlFraming StreamFraming = NewControlProtocol()
+	// CloseFraming treats every frame as data and ends the stream with the
+	// peer's close frame. Results can still be sent after the peer's close frame,
+	// the connection is closed once the action is done
+	CloseFraming StreamFraming = closeFraming{}
+)
+
+type legacyEOSFraming struct{}
+
+func (legacyEOSFraming) Decode(messageType int, data []byte) (*Frame, error) {
+	if messageType == websocket.CloseMessage {
+		return nil, fmt.Errorf("stream closed before EOS")
+	}
+
+	if IsEOS(data) {
+		return &Frame{Kind: FrameEnd}, nil
+	}
+
+	return &Frame{Kind: FrameData, Data: data}, nil
+}
+
+type closeFraming struct{}
+
+func (closeFraming) Decode(messageType int, data []byte) (*Frame, error) {
+	if messageType == websocket.CloseMessage {
+		return &Frame{Kind: FrameEnd}, nil
+	}
+
+	return &Frame{Kind: FrameData, Data: data}, nil
+}
+
+func streamFraming(sess *Session) StreamFraming {
+	if sess.framing == nil {
+		return LegacyEOSFraming
+	}
+
+	return sess.framing
+}
+
+// readFrame reads the next frame, applying the fault injection of the session
+func readFrame(sess *Session) (*Frame, error) {
+	for {
+		frame, err := readNextFrame(sess)
+		if err != nil || sess.fault == nil {
+			return frame, err
+		}
+
+		drop, err := sess.fault.frame(sess, frame)
+		if err != nil {
+			return nil, err
+		}
+		if !drop {
+			return frame, nil
+		}
+	}
+}
+
+// readNextFrame reads the next frame from the websocket and decodes it with the
+// framing of the session.
+// A frame above the max frame size is refused by the read limit set by
+// WithWebsocketConfig before being read, the connection is closed with 1009
+func readNextFrame(sess *Session) (*Frame, error) {
+	framing := streamFraming(sess)
+
+	messageType, r, err := sess.WsConn.NextReader()
+	if err == websocket.ErrReadLimit {
+		return nil, frameTooLarge(sess)
+	}
+	if err != nil {
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+			recordFrame(sess, RecordIn, websocket.CloseMessage, nil)
+			frame, ferr := framing.Decode(websocket.CloseMessage, []byte(err.(*websocket.CloseError).Text))
+			if ferr == nil {
+				return frame, nil
+			}
+		}
+
+		return nil, err
+	}
+
+	// the read limit is not set without WithWebsocketConfig
+	maxFrameSize := sess.maxFrameSize
+	if maxFrameSize <= 0 {
+		maxFrameSize = DefaultWsConfig.MaxFrameSize
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxFrameSize+1))
+	if err == websocket.ErrReadLimit || (err == nil && int64(len(data)) > maxFrameSize) {
+		return nil, frameTooLarge(sess)
+	}
+	if err != nil {
+		return nil, err
+	}
+	recordFrame(sess, RecordIn, messageType, data)
+
+	sess.ws.readBytes += int64(len(data))
+	if sess.maxSessionBytes > 0 && sess.ws.readBytes > sess.maxSessionBytes {
+		sess.Errorf("readFrame: session exceeds max session bytes %d", sess.maxSessionBytes)
+		return nil, WsErrorSessionTooLarge
+	}
+
+	return framing.Decode(messageType, data)
+}
+
+// frameTooLarge closes the websocket of a session whose frame exceeds the max
+// frame size with 1009, without reading the rest of the frame
+func frameTooLarge(sess *Session) error {
+	sess.Errorf("readFrame: frame exceeds max frame size %d", sess.maxFrameSize)
+	closeWith(sess, websocket.CloseMessageTooBig, WsErrorFrameTooLarge.Message, true)
+	return WsErrorFrameTooLarge
+}
+
+func streamForeach(sess *Session, foreach func(data []byte) error, control func(*ControlMessage) error, stopped func() bool) error {
 	if stopped == nil {
 		stopped = func() bool { return false }
 	}
 	var err error
-	var frame []byte
+	var frame *Frame
 	for {
-		_, frame, err = sess.WsConn.ReadMessage()
+		frame, err = readFrame(sess)
+		if err == WsErrorFrameTooLarge || err == WsErrorSessionTooLarge {
+			break
+		}
 		if err != nil {
 			sess.Errorf("streamForeach: %v", err.Error())
 			err = fmt.Errorf("streamForeach: fail to read stream from client with error: %v", err.Error())
 			break
 		}
 
-		if IsEOS(frame) || stopped() {
+		if frame.Kind == FrameEnd || stopped() {
 			break
 		}
 
-		if len(frame) > 1024*1024*4 {
-			sess.Errorf("streamForeach: stream fragmentation overflow: actual(%d) vs max(%d)", len(frame), 1024*1024*4)
-			return fmt.Errorf("streamForeach: stream fragmentation overflow")
+		if frame.Kind == FrameControl {
+			err = handleControl(sess, frame.Control, control)
+			if err != nil {
+				break
+			}
+			continue
 		}
 
-		err = foreach(frame)
+		err = foreach(frame.Data)
+		if err != nil {
+			break
+		}
 	}
 
 	return err
 }
 
 func StreamForeach(sess *Session, foreach func(data []byte) error) error {
-	return streamForeach(sess, foreach, nil)
+	return streamForeach(sess, foreach, nil, nil)
+}
+
+// StreamForeachControl is StreamForeach passing control messages other than
+// ping and cancel to control
+func StreamForeachControl(sess *Session, foreach func(data []byte) error, control func(*ControlMessage) error) error {
+	return streamForeach(sess, foreach, control, nil)
 }
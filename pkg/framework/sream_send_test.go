@@ -0,0 +1,97 @@
+package framework
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestFrameSizeLimits(t *testing.T) {
+	cases := []struct {
+		name      string
+		size      int
+		closeCode int
+	}{
+		{"within limit", 1024, websocket.CloseNormalClosure},
+		{"above limit", 1025, websocket.CloseMessageTooBig},
+		{"far above limit", 1 << 20, websocket.CloseMessageTooBig},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := DefaultWsConfig
+			cfg.MaxFrameSize = 1024
+			conn := dialWs(t, cfg, func(sess *Session) error {
+				return StreamForeach(sess, func([]byte) error { return nil })
+			})
+
+			conn.WriteMessage(websocket.BinaryMessage, bytes.Repeat([]byte("x"), c.size))
+			conn.WriteMessage(websocket.BinaryMessage, EOS)
+
+			_, _, err := conn.ReadMessage()
+			if !websocket.IsCloseError(err, c.closeCode) {
+				t.Fatalf("expect close %d, got %v", c.closeCode, err)
+			}
+		})
+	}
+}
+
+func TestFrameSizeLimitEnvelope(t *testing.T) {
+	cfg := DefaultWsConfig
+	cfg.MaxFrameSize = 1024
+	srv := httptest.NewServer(testWsHandler(cfg, func(sess *Session) error {
+		return StreamForeach(sess, func([]byte) error { return nil })
+	}))
+	defer srv.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{SubprotocolProto}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("fail to dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// the envelope of data of the max frame size fits in the read limit
+	payload, _ := anypb.New(wrapperspb.Bytes(bytes.Repeat([]byte("x"), 1024)))
+	for _, envelope := range []*WsEnvelope{{Type: TypeData, Payload: payload}, {Type: "end"}} {
+		data, _ := envelope.Marshal()
+		conn.WriteMessage(websocket.BinaryMessage, data)
+	}
+
+	for {
+		_, _, err = conn.ReadMessage()
+		if err != nil {
+			break
+		}
+	}
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Fatalf("expect normal close, got %v", err)
+	}
+}
+
+func TestSessionSizeLimit(t *testing.T) {
+	cfg := DefaultWsConfig
+	cfg.MaxSessionBytes = 100
+	conn := dialWs(t, cfg, func(sess *Session) error {
+		return StreamForeach(sess, func([]byte) error { return nil })
+	})
+
+	for i := 0; i < 3; i++ {
+		conn.WriteMessage(websocket.BinaryMessage, bytes.Repeat([]byte("x"), 40))
+	}
+
+	var resp struct {
+		Data WsError `json:"data"`
+	}
+	err := conn.ReadJSON(&resp)
+	if err != nil || resp.Data.Code != CodeSessionTooLarge {
+		t.Fatalf("expect error %d, got %+v, %v", CodeSessionTooLarge, resp, err)
+	}
+}
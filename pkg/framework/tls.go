@@ -0,0 +1,246 @@
+package framework
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// TLSConfig configures the TLS of a listener or of a grpc backend
+type TLSConfig struct {
+	// CertFile and KeyFile are the certificate of the listener, or the client
+	// certificate of a backend (mTLS). They are reloaded when the files change
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// CAFile verifies the client certificates of a listener, or the server
+	// certificate of a backend instead of the system roots
+	CAFile string `json:"ca_file"`
+	// ClientAuth requires a client certificate verified by CAFile, otherwise
+	// a listener only verifies the client certificates sent
+	ClientAuth bool `json:"client_auth"`
+	// ServerName overrides the name verified in the certificate of a backend
+	ServerName string `json:"server_name"`
+}
+
+// Enabled tells whether a listener serves TLS
+func (p *TLSConfig) Enabled() bool {
+	return p.CertFile != "" && p.KeyFile != ""
+}
+
+// ServerConfig returns the tls.Config of a listener
+func (p *TLSConfig) ServerConfig() (*tls.Config, error) {
+	reloader, err := NewCertReloader(p.CertFile, p.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if p.CAFile != "" {
+		ret.ClientCAs, err = loadCertPool(p.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		ret.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	if p.ClientAuth {
+		if p.CAFile == "" {
+			return nil, fmt.Errorf("client auth needs a CA file")
+		}
+		ret.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return ret, nil
+}
+
+// ClientConfig returns the tls.Config dialing a backend
+func (p *TLSConfig) ClientConfig() (*tls.Config, error) {
+	ret := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: p.ServerName,
+	}
+
+	var err error
+	if p.CAFile != "" {
+		ret.RootCAs, err = loadCertPool(p.CAFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.CertFile != "" || p.KeyFile != "" {
+		reloader, err := NewCertReloader(p.CertFile, p.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		ret.GetClientCertificate = reloader.GetClientCertificate
+	}
+
+	return ret, nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := x509.NewCertPool()
+	if !ret.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in %s", file)
+	}
+
+	return ret, nil
+}
+
+// CertReloadInterval is the min interval between checks of the certificate
+// files of a CertReloader
+var CertReloadInterval = time.Second
+
+// CertReloader serves a certificate reloaded from its files when they change,
+// so that certificates are renewed without restart. A certificate failing to
+// load is logged and the previous one kept
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	ret := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	modTime, err := ret.modTimeOfFiles()
+	if err != nil {
+		return nil, err
+	}
+	err = ret.load(modTime)
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+func (p *CertReloader) modTimeOfFiles() (time.Time, error) {
+	var ret time.Time
+	for _, file := range []string{p.certFile, p.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return ret, err
+		}
+		if info.ModTime().After(ret) {
+			ret = info.ModTime()
+		}
+	}
+
+	return ret, nil
+}
+
+func (p *CertReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(p.certFile, p.keyFile)
+	if err != nil {
+		return fmt.Errorf("fail to load certificate %s: %v", p.certFile, err)
+	}
+
+	p.cert = &cert
+	p.modTime = modTime
+	return nil
+}
+
+// certificate returns the certificate, reloaded if its files changed
+func (p *CertReloader) certificate() *tls.Certificate {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(p.checked) < CertReloadInterval {
+		return p.cert
+	}
+	p.checked = now
+
+	modTime, err := p.modTimeOfFiles()
+	if err != nil {
+		glog.Errorf("CertReloader: %v", err)
+		return p.cert
+	}
+	if !modTime.After(p.modTime) {
+		return p.cert
+	}
+
+	err = p.load(modTime)
+	if err != nil {
+		glog.Errorf("CertReloader: %v", err)
+		return p.cert
+	}
+	glog.Infof("CertReloader: certificate %s reloaded", p.certFile)
+
+	return p.cert
+}
+
+func (p *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return p.certificate(), nil
+}
+
+func (p *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return p.certificate(), nil
+}
+
+// BackendCredentials holds the transport credentials of grpc backends by
+// target, backends without credentials are dialed insecure
+type BackendCredentials struct {
+	mu    sync.RWMutex
+	creds map[string]credentials.TransportCredentials
+}
+
+// DefaultBackendCredentials are the credentials of WithGrpc and of the
+// GrpcPools without their own
+var DefaultBackendCredentials = NewBackendCredentials()
+
+func NewBackendCredentials() *BackendCredentials {
+	return &BackendCredentials{
+		creds: make(map[string]credentials.TransportCredentials),
+	}
+}
+
+// Set dials target with TLS configured by cfg
+func (p *BackendCredentials) Set(target string, cfg TLSConfig) error {
+	tlsConfig, err := cfg.ClientConfig()
+	if err != nil {
+		return fmt.Errorf("invalid TLS of backend '%s': %v", target, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.creds[target] = credentials.NewTLS(tlsConfig)
+
+	return nil
+}
+
+// DialOption returns the credentials option of target
+func (p *BackendCredentials) DialOption(target string) grpc.DialOption {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if creds, ok := p.creds[target]; ok {
+		return grpc.WithTransportCredentials(creds)
+	}
+
+	return grpc.WithInsecure()
+}
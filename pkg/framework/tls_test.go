@@ -0,0 +1,260 @@
+package framework_test
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tinker/mock/pb/hello"
+	"tinker/pkg/certgen"
+	"tinker/pkg/framework"
+	"tinker/pkg/framework/frameworktest"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// generateCerts writes a CA, a server certificate of 127.0.0.1 and a client
+// certificate named client to a new directory
+func generateCerts(t *testing.T, client string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	err := certgen.Generate(certgen.Options{
+		Dir:        dir,
+		Hosts:      []string{"127.0.0.1", "localhost"},
+		ClientName: client,
+		Validity:   time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("fail to generate certificates: %v", err)
+	}
+
+	return dir
+}
+
+// serverTLS returns the config of a listener requiring client certificates
+func serverTLS(t *testing.T, dir string) *tls.Config {
+	t.Helper()
+
+	cfg := framework.TLSConfig{
+		CertFile:   filepath.Join(dir, certgen.ServerFile),
+		KeyFile:    filepath.Join(dir, certgen.ServerKeyFile),
+		CAFile:     filepath.Join(dir, certgen.CAFile),
+		ClientAuth: true,
+	}
+	ret, err := cfg.ServerConfig()
+	if err != nil {
+		t.Fatalf("ServerConfig: %v", err)
+	}
+
+	return ret
+}
+
+// clientTLS returns the TLSConfig of a client of the listeners of dir
+func clientTLS(dir string) framework.TLSConfig {
+	return framework.TLSConfig{
+		CertFile: filepath.Join(dir, certgen.ClientFile),
+		KeyFile:  filepath.Join(dir, certgen.ClientKeyFile),
+		CAFile:   filepath.Join(dir, certgen.CAFile),
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	interval := framework.CertReloadInterval
+	framework.CertReloadInterval = 0
+	defer func() { framework.CertReloadInterval = interval }()
+
+	dir := generateCerts(t, "client")
+	certFile := filepath.Join(dir, certgen.ServerFile)
+	keyFile := filepath.Join(dir, certgen.ServerKeyFile)
+	reloader, err := framework.NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertReloader: %v", err)
+	}
+	first, _ := reloader.GetCertificate(nil)
+
+	// renewed certificate
+	renewed := generateCerts(t, "client")
+	later := time.Now().Add(time.Minute)
+	for _, name := range []string{certgen.ServerFile, certgen.ServerKeyFile} {
+		data, err := ioutil.ReadFile(filepath.Join(renewed, name))
+		if err != nil {
+			t.Fatalf("fail to read %s: %v", name, err)
+		}
+		ioutil.WriteFile(filepath.Join(dir, name), data, 0600)
+		os.Chtimes(filepath.Join(dir, name), later, later)
+	}
+	second, _ := reloader.GetCertificate(nil)
+	if second == first || string(second.Certificate[0]) == string(first.Certificate[0]) {
+		t.Fatalf("expect the renewed certificate")
+	}
+
+	// a broken certificate keeps the previous one
+	later = later.Add(time.Minute)
+	ioutil.WriteFile(certFile, []byte("broken"), 0600)
+	os.Chtimes(certFile, later, later)
+	if cert, _ := reloader.GetCertificate(nil); cert != second {
+		t.Fatalf("expect the previous certificate kept")
+	}
+}
+
+func TestServerConfigNeedsCA(t *testing.T) {
+	dir := generateCerts(t, "client")
+	cfg := framework.TLSConfig{
+		CertFile:   filepath.Join(dir, certgen.ServerFile),
+		KeyFile:    filepath.Join(dir, certgen.ServerKeyFile),
+		ClientAuth: true,
+	}
+	if _, err := cfg.ServerConfig(); err == nil {
+		t.Fatalf("expect client auth without CA to fail")
+	}
+}
+
+func TestIdentityHttp(t *testing.T) {
+	dir := generateCerts(t, "alice")
+
+	identities := make(chan *framework.Identity, 1)
+	handler := frameworktest.HttpHandler(nil, func(sess *framework.Session) error {
+		identities <- sess.Identity()
+		return framework.SendHttpResult(sess, "ok")
+	})
+	srv := httptest.NewUnstartedServer(handler)
+	srv.TLS = serverTLS(t, dir)
+	srv.StartTLS()
+	defer srv.Close()
+
+	// with SNI the server picks the certificate of serverTLS over the one of httptest
+	cfg := clientTLS(dir)
+	cfg.ServerName = "localhost"
+	clientConfig, err := cfg.ClientConfig()
+	if err != nil {
+		t.Fatalf("ClientConfig: %v", err)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("fail to get: %v", err)
+	}
+	resp.Body.Close()
+
+	identity := <-identities
+	if identity == nil || identity.CommonName != "alice" {
+		t.Fatalf("expect identity alice, got %+v", identity)
+	}
+
+	// without client certificate
+	clientConfig.GetClientCertificate = nil
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+	if resp, err := client.Get(srv.URL); err == nil {
+		resp.Body.Close()
+		t.Fatalf("expect the handshake to fail without client certificate")
+	}
+}
+
+// serveGrpcTLS serves srv with mTLS on a local port and returns its address
+func serveGrpcTLS(t *testing.T, dir string, register func(*grpc.Server)) string {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("fail to listen: %v", err)
+	}
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(serverTLS(t, dir))))
+	register(server)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	return lis.Addr().String()
+}
+
+func TestIdentityGrpc(t *testing.T) {
+	dir := generateCerts(t, "bob")
+
+	identities := make(chan *framework.Identity, 1)
+	handler := &framework.Handler{Name: "test", OnError: framework.LogError, OnPanic: framework.LogPanic}
+	handler.Add(func(sess *framework.Session) error {
+		identities <- sess.Identity()
+
+		var msg []byte
+		if err := sess.GrpcStream.RecvMsg(&msg); err != nil {
+			return err
+		}
+		msg = nil
+		return sess.GrpcStream.SendMsg(&msg)
+	})
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("fail to listen: %v", err)
+	}
+	server := framework.NewGrpcServer(handler, grpc.Creds(credentials.NewTLS(serverTLS(t, dir))))
+	go server.Serve(lis)
+	defer server.Stop()
+
+	creds := framework.NewBackendCredentials()
+	if err := creds.Set(lis.Addr().String(), clientTLS(dir)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	pool := framework.NewGrpcPool()
+	pool.Credentials = creds
+	defer pool.Close()
+	conn, err := pool.Get(lis.Addr().String())
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	_, err = hello.NewGreetingClient(conn).Greet(testContext(t), &hello.GreetRequest{})
+	if err != nil {
+		t.Fatalf("Greet: %v", err)
+	}
+	identity := <-identities
+	if identity == nil || identity.CommonName != "bob" {
+		t.Fatalf("expect identity bob, got %+v", identity)
+	}
+}
+
+func TestBackendCredentials(t *testing.T) {
+	dir := generateCerts(t, "tinker")
+	target := serveGrpcTLS(t, dir, new(frameworktest.HelloServer).Register)
+
+	creds := framework.NewBackendCredentials()
+	if err := creds.Set(target, clientTLS(dir)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	pool := framework.NewGrpcPool()
+	pool.Credentials = creds
+	defer pool.Close()
+
+	conn, err := pool.Get(target)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	resp, err := hello.NewGreetingClient(conn).Greet(testContext(t), &hello.GreetRequest{Person: hello.Name_Bob})
+	if err != nil || resp.Acking != "Hi Bob" {
+		t.Fatalf("expect greeting over mTLS, got %v, %v", resp, err)
+	}
+
+	// the other targets are dialed insecure, which the backend refuses
+	insecure := framework.NewGrpcPool()
+	insecure.Credentials = framework.NewBackendCredentials()
+	defer insecure.Close()
+	conn, err = insecure.Get(target)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if _, err := hello.NewGreetingClient(conn).Greet(testContext(t), &hello.GreetRequest{}); err == nil {
+		t.Fatalf("expect an insecure call to fail")
+	}
+
+	// invalid TLS
+	err = creds.Set("other:443", framework.TLSConfig{CAFile: filepath.Join(dir, "missing.pem")})
+	if err == nil {
+		t.Fatalf("expect Set to fail without CA file")
+	}
+}
@@ -1,8 +1,10 @@
 package framework
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -11,22 +13,42 @@ import (
 const (
 	TypeSuccess = "success"
 	TypeError   = "error"
+	TypePong    = "pong"
+
+	// TypeBackpressure tells the client to slow down or resume, see StreamPipeline
+	TypeBackpressure = "backpressure"
+	// TypeSummary ends the messages of multiple sources, see FanIn
+	TypeSummary = "summary"
 )
 
 type WsResponse struct {
 	Type      string      `json:"type"`
 	RequestID string      `json:"request_id"`
 	Data      interface{} `json:"data"`
+
+	// Source and Seq tag messages multiplexed from several sources, see FanIn
+	Source string `json:"source,omitempty"`
+	Seq    int64  `json:"seq,omitempty"`
 }
 
 const (
-	CodeClientError = 2400
-	CodeServerError = 2500
+	CodeClientError     = 2400
+	CodeFrameTooLarge   = 2413
+	CodeSessionTooLarge = 2414
+	CodeBackpressure    = 2429
+	CodeRateLimited     = 2430
+	CodeServerError     = 2500
+	CodeTimeout         = 2504
 )
 
 var (
-	WsErrorClient = NewWsError(CodeClientError, "client error")
-	WsErrorServer = NewWsError(CodeServerError, "internal server error")
+	WsErrorClient          = NewWsError(CodeClientError, "client error")
+	WsErrorFrameTooLarge   = NewWsError(CodeFrameTooLarge, "frame too large")
+	WsErrorSessionTooLarge = NewWsError(CodeSessionTooLarge, "session too large")
+	WsErrorBackpressure    = NewWsError(CodeBackpressure, "stream buffer full, client sends too fast")
+	WsErrorRateLimited     = NewWsError(CodeRateLimited, "too many sessions")
+	WsErrorServer          = NewWsError(CodeServerError, "internal server error")
+	WsErrorTimeout         = NewWsError(CodeTimeout, "session timeout")
 )
 
 type WsError struct {
@@ -62,12 +84,7 @@ func SendWsError(sess *Session, code int, msg string) error {
 		},
 	}
 
-	err := sess.WsConn.WriteJSON(&resp)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return sendWs(sess, &resp)
 }
 
 func SendWsResult(sess *Session, result interface{}) error {
@@ -77,15 +94,114 @@ func SendWsResult(sess *Session, result interface{}) error {
 		Data:      result,
 	}
 
-	err := sess.WsConn.WriteJSON(&resp)
+	return sendWs(sess, &resp)
+}
+
+func sendWs(sess *Session, resp *WsResponse) error {
+	if sess.wsCodec != nil {
+		if resp.Source == "" {
+			resp.Seq = atomic.AddInt64(&sess.ws.seq, 1)
+		}
+		messageType, data, err := sess.wsCodec.Encode(resp)
+		if err != nil {
+			return err
+		}
+		return SendWsMessage(sess, messageType, data)
+	}
+
+	data, err := json.Marshal(resp)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return SendWsMessage(sess, websocket.TextMessage, data)
 }
 
+// SendWsMessage sends a raw message through the outbound queue of the session.
+// Sessions created without WithWebsocket write to Session.WsConn directly
+func SendWsMessage(sess *Session, messageType int, data []byte) error {
+	recordFrame(sess, RecordOut, messageType, data)
+
+	if sess.wsWriter == nil {
+		return sess.WsConn.WriteMessage(messageType, data)
+	}
+
+	return sess.wsWriter.send(wsMessage{messageType: messageType, data: data})
+}
+
+// WsConfig holds the per handler websocket settings
+type WsConfig struct {
+	// CloseTimeout is how long to wait for the peer's close frame after
+	// sending ours before the underlying connection is closed anyway
+	CloseTimeout time.Duration
+
+	// QueueSize is the capacity of the outbound message queue
+	QueueSize int
+	// WritePolicy decides what happens when the outbound queue is full
+	WritePolicy WsWritePolicy
+	// WriteTimeout is the write deadline of each outbound message
+	WriteTimeout time.Duration
+
+	// Framing decides how inbound frames are split into data and end of stream
+	Framing StreamFraming
+	// Codecs are offered to the clients as subprotocols. The codec of the
+	// subprotocol chosen by the client replaces the JSON responses and Framing
+	Codecs []WsCodec
+
+	// MaxFrameSize is the max size of an inbound frame, plus the envelope of a
+	// codec. Larger frames are refused by SetReadLimit without being read, the
+	// connection is closed with code 1009
+	MaxFrameSize int64
+	// MaxSessionBytes is the max total size of inbound frames of a session, 0 means no limit
+	MaxSessionBytes int64
+}
+
+var DefaultWsConfig = WsConfig{
+	CloseTimeout: 5 * time.Second,
+	QueueSize:    64,
+	WritePolicy:  WsWriteBlock,
+	WriteTimeout: 10 * time.Second,
+	Framing:      LegacyEOSFraming,
+	Codecs:       []WsCodec{ProtoWsCodec},
+	MaxFrameSize: 4 * 1024 * 1024,
+}
+
+// WithWebsocket returns a Wrapper upgrading the request to websocket with DefaultWsConfig
 func WithWebsocket() Wrapper {
+	return WithWebsocketConfig(DefaultWsConfig)
+}
+
+// wsEnvelopeOverhead is the room left to the envelope of a codec around the
+// data of a frame of WsConfig.MaxFrameSize
+const wsEnvelopeOverhead = 1024
+
+// withDefaults returns the config with its zero durations, sizes and framing
+// taken from DefaultWsConfig
+func (p WsConfig) withDefaults() WsConfig {
+	if p.CloseTimeout <= 0 {
+		p.CloseTimeout = DefaultWsConfig.CloseTimeout
+	}
+	if p.QueueSize <= 0 {
+		p.QueueSize = DefaultWsConfig.QueueSize
+	}
+	if p.WriteTimeout <= 0 {
+		p.WriteTimeout = DefaultWsConfig.WriteTimeout
+	}
+	if p.Framing == nil {
+		p.Framing = DefaultWsConfig.Framing
+	}
+	if p.MaxFrameSize <= 0 {
+		p.MaxFrameSize = DefaultWsConfig.MaxFrameSize
+	}
+
+	return p
+}
+
+// WithWebsocketConfig returns a Wrapper upgrading the request to websocket.
+// The websocket connection is set in Session.WsConn. The zero fields of cfg
+// are those of DefaultWsConfig, but Codecs and MaxSessionBytes
+func WithWebsocketConfig(cfg WsConfig) Wrapper {
+	cfg = cfg.withDefaults()
 	return func(sess *Session, action Action) error {
 		upgrader := &websocket.Upgrader{
 			CheckOrigin: func(req *http.Request) bool {
@@ -94,35 +210,121 @@ func WithWebsocket() Wrapper {
 				return true
 			},
 		}
+		for _, codec := range cfg.Codecs {
+			upgrader.Subprotocols = append(upgrader.Subprotocols, codec.Subprotocol())
+		}
 
 		wsConn, err := upgrader.Upgrade(sess.ResponseWriter, sess.Request, nil)
 		if err != nil {
 			sess.Errorf("WithWebsocket: failed to upgrade to websocket: %s", err.Error())
 			return err
 		}
+		// don't echo the peer's close frame right away: the close handshake is
+		// completed by closeWebsocket once the action is done, which lets actions
+		// reply after a close frame ending the stream (see CloseFraming)
+		wsConn.SetCloseHandler(func(code int, text string) error {
+			return nil
+		})
+
+		writer := newWsWriter(sess, wsConn, cfg)
 		defer func() {
-			// send close control message before close the underlying connection
-			derr := wsConn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(1000, "done"), time.Now().Add(time.Second*5))
-			if derr != nil {
-				sess.Warningf("Fail to send close message: %v", derr)
-			}
-			// Note(yiwei.gu): Thanks to the mysterious connection closing control
-			// by gorilla/websocket, we never know when the underlying connection
-			// is closed exactly. So we decided to add an extra delay(5s) before
-			// truly closing the connection. Hope they'll fix it one day :)
-			// refer to https://github.com/gorilla/websocket/pull/487
-			time.Sleep(time.Duration(5) * time.Second)
-			// close connection
-			wsConn.Close()
+			// flush pending messages before the close frame
+			writer.close()
+			closeWebsocket(sess, wsConn, cfg.CloseTimeout)
 			sess.Infof("WithWebsocket: websocket closed")
 		}()
 		sess.Infof("WithWebsocket: upgrade to websocket")
 		sess.WsConn = wsConn
+		sess.wsWriter = writer
+		sess.ws = new(wsState)
+		sess.framing = cfg.Framing
+		if codec := wsCodecFor(cfg.Codecs, wsConn.Subprotocol()); codec != nil {
+			sess.Infof("WithWebsocket: use subprotocol %s", codec.Subprotocol())
+			sess.framing = codec
+			sess.wsCodec = codec
+		}
+		sess.maxFrameSize = cfg.MaxFrameSize
+		if sess.wsCodec != nil {
+			sess.maxFrameSize += wsEnvelopeOverhead
+		}
+		wsConn.SetReadLimit(sess.maxFrameSize)
+		sess.maxSessionBytes = cfg.MaxSessionBytes
 
 		return action(sess)
 	}
 }
 
+// wsState is the websocket state of a session changed by its actions
+type wsState struct {
+	// seq numbers the responses encoded by wsCodec
+	seq int64
+	// close code and reason of closeWebsocket, see closeWith
+	closeCode   int
+	closeReason string
+	readBroken  bool
+	// reader is closed when the reader goroutine left by StreamPipeline
+	// exits, closeWebsocket waits for it before reading
+	reader    chan struct{}
+	readBytes int64
+}
+
+// closeWith sets the close code and reason of the session. readBroken tells
+// that the websocket can't be read anymore, e.g. after its read deadline, so
+// the peer's close frame isn't awaited
+func closeWith(sess *Session, code int, reason string, readBroken bool) {
+	sess.ws.closeCode = code
+	sess.ws.closeReason = reason
+	sess.ws.readBroken = readBroken
+}
+
+// closeWebsocket performs the close handshake: it sends a close frame, waits
+// up to timeout for the peer's close frame (or a read error) and then closes
+// the underlying connection.
+func closeWebsocket(sess *Session, wsConn *websocket.Conn, timeout time.Duration) {
+	defer wsConn.Close()
+
+	code, reason := websocket.CloseNormalClosure, "done"
+	if sess.ws.closeCode != 0 {
+		code, reason = sess.ws.closeCode, sess.ws.closeReason
+	}
+
+	deadline := time.Now().Add(timeout)
+	err := wsConn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
+	if err != nil && err != websocket.ErrCloseSent {
+		sess.Warningf("Fail to send close message: %v", err)
+		return
+	}
+	if sess.ws.readBroken {
+		return
+	}
+	if sess.ws.reader != nil {
+		// the reader exits on the next frame or the peer's close frame
+		select {
+		case <-sess.ws.reader:
+		case <-time.After(time.Until(deadline)):
+			sess.Warningf("WithWebsocket: close handshake not completed: reader still running")
+			return
+		}
+	}
+
+	// drain the connection until the peer's close frame arrives. NextReader
+	// discards data frames and returns a *websocket.CloseError once the close
+	// frame is read, or the deadline error if the peer never answers
+	err = wsConn.SetReadDeadline(deadline)
+	if err != nil {
+		return
+	}
+	for {
+		if _, _, err = wsConn.NextReader(); err != nil {
+			break
+		}
+	}
+
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived) {
+		sess.Warningf("WithWebsocket: close handshake not completed: %v", err)
+	}
+}
+
 func WithReplyWsError() Wrapper {
 	return func(sess *Session, action Action) error {
 		err := action(sess)
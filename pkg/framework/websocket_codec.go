@@ -0,0 +1,277 @@
+package framework
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// SubprotocolProto is the websocket subprotocol of ProtoWsCodec
+const SubprotocolProto = "tinker.proto.v1"
+
+// TypeData is the type of the envelopes carrying inbound data, see ProtoWsCodec
+const TypeData = "data"
+
+// WsCodec is a websocket encoding negotiated by subprotocol, see
+// WsConfig.Codecs. It decodes inbound frames as a StreamFraming and encodes
+// the responses of SendWsResult, SendWsError and the framework messages
+type WsCodec interface {
+	StreamFraming
+	Subprotocol() string
+	Encode(resp *WsResponse) (messageType int, data []byte, err error)
+}
+
+// ProtoWsCodec frames every message in a WsEnvelope of a binary frame.
+// Inbound envelopes of type "data" carry data, the payload value unwrapped
+// when it is a google.protobuf.BytesValue. The stream ends with type "end",
+// other types are control messages of the builtin ControlProtocol, their
+// payload (e.g. a google.protobuf.Struct) is the params in protojson.
+// Responses are packed in the payload as is for proto messages, as a
+// google.protobuf.Value of their JSON otherwise
+var ProtoWsCodec WsCodec = NewProtoCodec(NewControlProtocol())
+
+// WsEnvelope is the message of ProtoWsCodec:
+//
+//	message Envelope {
+//	  string type = 1;
+//	  string request_id = 2;
+//	  int64 sequence = 3;
+//	  google.protobuf.Any payload = 4;
+//	  string source = 5;
+//	}
+//
+// A frame carries one envelope prefixed by its varint length, like
+// writeDelimitedTo of the protobuf libraries. Sequence numbers the responses
+// of a session, or the messages of a source for FanIn
+type WsEnvelope struct {
+	Type      string
+	RequestID string
+	Sequence  int64
+	Payload   *anypb.Any
+	Source    string
+}
+
+// Marshal encodes the envelope with its length prefix
+func (p *WsEnvelope) Marshal() ([]byte, error) {
+	var body []byte
+	if p.Type != "" {
+		body = protowire.AppendTag(body, 1, protowire.BytesType)
+		body = protowire.AppendString(body, p.Type)
+	}
+	if p.RequestID != "" {
+		body = protowire.AppendTag(body, 2, protowire.BytesType)
+		body = protowire.AppendString(body, p.RequestID)
+	}
+	if p.Sequence != 0 {
+		body = protowire.AppendTag(body, 3, protowire.VarintType)
+		body = protowire.AppendVarint(body, uint64(p.Sequence))
+	}
+	if p.Payload != nil {
+		payload, err := proto.Marshal(p.Payload)
+		if err != nil {
+			return nil, err
+		}
+		body = protowire.AppendTag(body, 4, protowire.BytesType)
+		body = protowire.AppendBytes(body, payload)
+	}
+	if p.Source != "" {
+		body = protowire.AppendTag(body, 5, protowire.BytesType)
+		body = protowire.AppendString(body, p.Source)
+	}
+
+	ret := protowire.AppendVarint(make([]byte, 0, protowire.SizeVarint(uint64(len(body)))+len(body)), uint64(len(body)))
+	return append(ret, body...), nil
+}
+
+// UnmarshalWsEnvelope decodes a length prefixed envelope filling the frame
+func UnmarshalWsEnvelope(data []byte) (*WsEnvelope, error) {
+	size, n := protowire.ConsumeVarint(data)
+	if n < 0 {
+		return nil, fmt.Errorf("invalid envelope length: %v", protowire.ParseError(n))
+	}
+	body := data[n:]
+	if uint64(len(body)) != size {
+		return nil, fmt.Errorf("envelope length %d doesn't match the frame of %d bytes", size, len(body))
+	}
+
+	ret := new(WsEnvelope)
+	for len(body) > 0 {
+		num, typ, n := protowire.ConsumeTag(body)
+		if n < 0 {
+			return nil, fmt.Errorf("invalid envelope: %v", protowire.ParseError(n))
+		}
+		body = body[n:]
+
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			ret.Type, n = protowire.ConsumeString(body)
+		case num == 2 && typ == protowire.BytesType:
+			ret.RequestID, n = protowire.ConsumeString(body)
+		case num == 3 && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(body)
+			ret.Sequence = int64(v)
+		case num == 4 && typ == protowire.BytesType:
+			var v []byte
+			v, n = protowire.ConsumeBytes(body)
+			if n >= 0 {
+				ret.Payload = new(anypb.Any)
+				if err := proto.Unmarshal(v, ret.Payload); err != nil {
+					return nil, fmt.Errorf("invalid envelope payload: %v", err)
+				}
+			}
+		case num == 5 && typ == protowire.BytesType:
+			ret.Source, n = protowire.ConsumeString(body)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, body)
+		}
+		if n < 0 {
+			return nil, fmt.Errorf("invalid envelope field %d: %v", num, protowire.ParseError(n))
+		}
+		body = body[n:]
+	}
+
+	return ret, nil
+}
+
+// ProtoCodec is the WsCodec of WsEnvelope, see ProtoWsCodec
+type ProtoCodec struct {
+	control *ControlProtocol
+}
+
+// NewProtoCodec returns a ProtoCodec decoding control messages with control,
+// so that custom message types can be registered
+func NewProtoCodec(control *ControlProtocol) *ProtoCodec {
+	return &ProtoCodec{control: control}
+}
+
+func (p *ProtoCodec) Subprotocol() string {
+	return SubprotocolProto
+}
+
+func (p *ProtoCodec) Decode(messageType int, data []byte) (*Frame, error) {
+	switch messageType {
+	case websocket.BinaryMessage:
+	case websocket.TextMessage:
+		return nil, fmt.Errorf("unexpected text frame, expect envelopes in binary frames")
+	default:
+		return nil, fmt.Errorf("stream closed before end message")
+	}
+
+	envelope, err := UnmarshalWsEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if envelope.Type == TypeData {
+		data, err := payloadData(envelope.Payload)
+		if err != nil {
+			return nil, err
+		}
+		return &Frame{Kind: FrameData, Data: data}, nil
+	}
+
+	msg := &ControlMessage{Type: envelope.Type}
+	if envelope.Payload != nil {
+		params, err := envelope.Payload.UnmarshalNew()
+		if err != nil {
+			return nil, fmt.Errorf("invalid params of control message '%s': %v", msg.Type, err)
+		}
+		msg.Params, err = protojson.Marshal(params)
+		if err != nil {
+			return nil, fmt.Errorf("invalid params of control message '%s': %v", msg.Type, err)
+		}
+	}
+
+	msg, err = p.control.resolve(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return controlFrame(msg), nil
+}
+
+func (p *ProtoCodec) Encode(resp *WsResponse) (int, []byte, error) {
+	payload, err := packPayload(resp.Data)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	envelope := &WsEnvelope{
+		Type:      resp.Type,
+		RequestID: resp.RequestID,
+		Sequence:  resp.Seq,
+		Payload:   payload,
+		Source:    resp.Source,
+	}
+	data, err := envelope.Marshal()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return websocket.BinaryMessage, data, nil
+}
+
+// payloadData returns the data of a data envelope
+func payloadData(payload *anypb.Any) ([]byte, error) {
+	if payload == nil {
+		return nil, nil
+	}
+
+	if payload.MessageIs((*wrapperspb.BytesValue)(nil)) {
+		value := new(wrapperspb.BytesValue)
+		err := payload.UnmarshalTo(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data payload: %v", err)
+		}
+		return value.Value, nil
+	}
+
+	return payload.Value, nil
+}
+
+// packPayload packs the data of a response
+func packPayload(data interface{}) (*anypb.Any, error) {
+	switch v := data.(type) {
+	case nil:
+		return nil, nil
+	case proto.Message:
+		return anypb.New(v)
+	case []byte:
+		return anypb.New(wrapperspb.Bytes(v))
+	}
+
+	js, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	value := new(structpb.Value)
+	err = protojson.Unmarshal(js, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return anypb.New(value)
+}
+
+// wsCodecFor returns the codec of the subprotocol, nil if there is none
+func wsCodecFor(codecs []WsCodec, subprotocol string) WsCodec {
+	if subprotocol == "" {
+		return nil
+	}
+
+	for _, codec := range codecs {
+		if codec.Subprotocol() == subprotocol {
+			return codec
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,195 @@
+package framework_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"tinker/pkg/framework"
+	"tinker/pkg/framework/frameworktest"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestWsEnvelopeRoundTrip(t *testing.T) {
+	payload, err := anypb.New(wrapperspb.Bytes([]byte("data")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		envelope *framework.WsEnvelope
+	}{
+		{
+			name: "every field",
+			envelope: &framework.WsEnvelope{
+				Type:      framework.TypeSuccess,
+				RequestID: "id",
+				Sequence:  1 << 40,
+				Payload:   payload,
+				Source:    "b0",
+			},
+		},
+		{name: "nil payload", envelope: &framework.WsEnvelope{Type: framework.ControlEnd, Sequence: 3}},
+		{name: "empty", envelope: new(framework.WsEnvelope)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.envelope.Marshal()
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			got, err := framework.UnmarshalWsEnvelope(data)
+			if err != nil {
+				t.Fatalf("UnmarshalWsEnvelope: %v", err)
+			}
+
+			want := tt.envelope
+			if got.Type != want.Type || got.RequestID != want.RequestID || got.Sequence != want.Sequence || got.Source != want.Source {
+				t.Fatalf("expect %+v, got %+v", want, got)
+			}
+			if (want.Payload == nil) != (got.Payload == nil) || !proto.Equal(got.Payload, want.Payload) {
+				t.Fatalf("expect payload %v, got %v", want.Payload, got.Payload)
+			}
+		})
+	}
+}
+
+func TestUnmarshalWsEnvelopeInvalid(t *testing.T) {
+	valid, err := (&framework.WsEnvelope{Type: framework.TypeData, RequestID: "id"}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a length prefixed body
+	frame := func(body []byte) []byte {
+		return append(protowire.AppendVarint(nil, uint64(len(body))), body...)
+	}
+	field := func(num protowire.Number, value []byte) []byte {
+		return protowire.AppendBytes(protowire.AppendTag(nil, num, protowire.BytesType), value)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "truncated", data: valid[:len(valid)-1]},
+		{name: "trailing bytes", data: append(append([]byte(nil), valid...), 0)},
+		{name: "garbage length", data: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{name: "garbage body", data: frame([]byte{0xff, 0xff, 0xff})},
+		{name: "truncated field", data: frame(field(1, []byte("data"))[:4])},
+		{name: "invalid payload", data: frame(field(4, []byte{0xff, 0xff}))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if envelope, err := framework.UnmarshalWsEnvelope(tt.data); err == nil {
+				t.Fatalf("expect an error, got %+v", envelope)
+			}
+		})
+	}
+
+	// unknown fields are skipped
+	envelope, err := framework.UnmarshalWsEnvelope(frame(append(field(9, []byte("x")), field(1, []byte("data"))...)))
+	if err != nil || envelope.Type != framework.TypeData {
+		t.Fatalf("expect the unknown field skipped, got %+v, %v", envelope, err)
+	}
+}
+
+// codecHandler waits for the start message then counts the bytes streamed
+// until the end of the stream, and replies the language of the start message
+// with the count. It speaks ProtoWsCodec, or the JSON control framing without
+// subprotocol
+func codecHandler() *framework.Handler {
+	cfg := framework.DefaultWsConfig
+	cfg.Framing = framework.JSONControlFraming
+
+	handler := &framework.Handler{Name: "test", OnError: framework.LogError, OnPanic: framework.LogPanic}
+	handler.Use(framework.WithRequestID(), framework.WithWebsocketConfig(cfg), framework.WithReplyWsError())
+	handler.Add(framework.AwaitStart(time.Second), func(sess *framework.Session) error {
+		start, _ := framework.StartMessage(sess)
+		var params struct {
+			Language string `json:"language"`
+		}
+		if err := json.Unmarshal(start.Params, &params); err != nil {
+			return framework.WsErrorClient.WithMessage("invalid start params")
+		}
+
+		n := 0
+		err := framework.StreamForeach(sess, func(data []byte) error {
+			n += len(data)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return framework.SendWsResult(sess, map[string]interface{}{"language": params.Language, "bytes": n})
+	})
+
+	return handler
+}
+
+func TestWsCodecSession(t *testing.T) {
+	clients := []struct {
+		name        string
+		subprotocol string
+	}{
+		{name: "proto", subprotocol: framework.SubprotocolProto},
+		{name: "json"},
+	}
+	for _, c := range clients {
+		header := http.Header{}
+		if c.subprotocol != "" {
+			header.Set("Sec-WebSocket-Protocol", c.subprotocol)
+		}
+
+		t.Run(c.name, func(t *testing.T) {
+			client := frameworktest.ServeWs(t, codecHandler(), header)
+			if got := client.Conn.Subprotocol(); got != c.subprotocol {
+				t.Fatalf("expect subprotocol %q, got %q", c.subprotocol, got)
+			}
+
+			client.SendControl(framework.ControlStart, map[string]string{"language": "en-US"})
+			client.SendControl(framework.ControlPing, nil)
+			if pong := client.Expect(framework.TypePong); pong.RequestID == "" {
+				t.Fatalf("expect the request id in the pong")
+			}
+			client.Send([]byte("hello"))
+			client.Send([]byte("world"))
+			// SendEOS sends the legacy EOS frame without subprotocol, which
+			// is data for the JSON control framing
+			if c.subprotocol != "" {
+				client.SendEOS()
+			} else {
+				client.SendControl(framework.ControlEnd, nil)
+			}
+
+			var result struct {
+				Language string `json:"language"`
+				Bytes    int    `json:"bytes"`
+			}
+			resp := client.ExpectSuccess()
+			resp.Decode(t, &result)
+			if result.Language != "en-US" || result.Bytes != 10 || resp.RequestID == "" {
+				t.Fatalf("unexpected result %+v of %+v", result, resp)
+			}
+		})
+
+		t.Run(c.name+" cancel", func(t *testing.T) {
+			client := frameworktest.ServeWs(t, codecHandler(), header)
+			client.SendControl(framework.ControlStart, map[string]string{"language": "en-US"})
+			client.Send([]byte("hello"))
+			client.SendControl(framework.ControlCancel, nil)
+
+			wsErr := client.ExpectError(framework.WsErrorClient.Code)
+			if wsErr.Message != framework.ErrStreamCanceled.Message {
+				t.Fatalf("expect %q, got %q", framework.ErrStreamCanceled.Message, wsErr.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package framework
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WsWritePolicy decides what happens to an outbound message when the
+// outbound queue of a session is full
+type WsWritePolicy int
+
+const (
+	// WsWriteBlock blocks the sender until the queue has room (backpressure)
+	WsWriteBlock WsWritePolicy = iota
+	// WsWriteDrop discards the message and logs a warning
+	WsWriteDrop
+)
+
+var ErrWsWriterClosed = errors.New("websocket writer closed")
+
+type wsMessage struct {
+	messageType int
+	data        []byte
+}
+
+// wsWriter is the only goroutine writing data frames to a websocket connection,
+// because gorilla/websocket supports one concurrent writer only.
+// Control frames are written by WriteControl which is safe to call concurrently.
+type wsWriter struct {
+	sess    *Session
+	conn    *websocket.Conn
+	policy  WsWritePolicy
+	timeout time.Duration
+
+	queue   chan wsMessage
+	closing chan struct{}
+	done    chan struct{}
+
+	// closeMu orders the messages queued before close, a sender holds the
+	// read lock while it queues
+	closeMu sync.RWMutex
+	closed  bool
+
+	mu      sync.Mutex
+	err     error
+	dropped int
+}
+
+func newWsWriter(sess *Session, conn *websocket.Conn, cfg WsConfig) *wsWriter {
+	ret := &wsWriter{
+		sess:    sess,
+		conn:    conn,
+		policy:  cfg.WritePolicy,
+		timeout: cfg.WriteTimeout,
+		queue:   make(chan wsMessage, cfg.QueueSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go ret.loop()
+
+	return ret
+}
+
+func (p *wsWriter) loop() {
+	defer close(p.done)
+
+	for {
+		select {
+		case msg := <-p.queue:
+			p.write(msg)
+		case <-p.closing:
+			// flush messages already queued
+			for {
+				select {
+				case msg := <-p.queue:
+					p.write(msg)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *wsWriter) write(msg wsMessage) {
+	if p.error() != nil {
+		// connection is broken, discard
+		return
+	}
+
+	if p.timeout > 0 {
+		p.conn.SetWriteDeadline(time.Now().Add(p.timeout))
+	}
+
+	err := p.conn.WriteMessage(msg.messageType, msg.data)
+	if err != nil {
+		p.sess.Errorf("wsWriter: fail to write message: %v", err)
+		p.mu.Lock()
+		p.err = err
+		p.mu.Unlock()
+	}
+}
+
+func (p *wsWriter) error() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.err
+}
+
+// send queues a message. It returns the first write error of the connection
+// if any, so senders learn about a broken connection on the next send.
+// A message queued is written, send fails with ErrWsWriterClosed after close
+func (p *wsWriter) send(msg wsMessage) error {
+	if err := p.error(); err != nil {
+		return err
+	}
+
+	p.closeMu.RLock()
+	defer p.closeMu.RUnlock()
+	if p.closed {
+		return ErrWsWriterClosed
+	}
+
+	if p.policy == WsWriteDrop {
+		select {
+		case p.queue <- msg:
+		default:
+			p.mu.Lock()
+			p.dropped++
+			p.mu.Unlock()
+			p.sess.Warningf("wsWriter: outbound queue full, message dropped")
+		}
+		return nil
+	}
+
+	// the loop drains the queue until close, which waits for this send
+	p.queue <- msg
+	return nil
+}
+
+// close stops accepting messages, flushes the queue and waits for the writer goroutine
+func (p *wsWriter) close() {
+	p.closeMu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.closing)
+	}
+	p.closeMu.Unlock()
+	<-p.done
+
+	p.mu.Lock()
+	dropped := p.dropped
+	p.mu.Unlock()
+	if dropped > 0 {
+		p.sess.Warningf("wsWriter: %d outbound messages dropped", dropped)
+	}
+}
@@ -0,0 +1,177 @@
+package framework
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsPair returns the server and client sides of a websocket connection
+func wsPair(t *testing.T) (*websocket.Conn, *websocket.Conn) {
+	t.Helper()
+
+	server := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		conn, err := new(websocket.Upgrader).Upgrade(rw, req, nil)
+		if err != nil {
+			t.Errorf("fail to upgrade: %v", err)
+			return
+		}
+		server <- conn
+	}))
+	t.Cleanup(srv.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("fail to dial: %v", err)
+	}
+	conn := <-server
+	t.Cleanup(func() {
+		client.Close()
+		conn.Close()
+	})
+
+	return conn, client
+}
+
+// newTestWriter returns a writer whose loop isn't started, to fill its queue
+func newTestWriter(policy WsWritePolicy, size int) *wsWriter {
+	return &wsWriter{
+		sess:    new(Session),
+		policy:  policy,
+		queue:   make(chan wsMessage, size),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+func TestWsWriterDrop(t *testing.T) {
+	writer := newTestWriter(WsWriteDrop, 2)
+	for i := 0; i < 5; i++ {
+		err := writer.send(wsMessage{websocket.TextMessage, []byte("m")})
+		if err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+	}
+
+	if len(writer.queue) != 2 || writer.dropped != 3 {
+		t.Fatalf("expect 2 messages queued and 3 dropped, got %d and %d", len(writer.queue), writer.dropped)
+	}
+}
+
+func TestWsWriterBlock(t *testing.T) {
+	writer := newTestWriter(WsWriteBlock, 1)
+	writer.send(wsMessage{websocket.TextMessage, []byte("1")})
+
+	sent := make(chan error, 1)
+	go func() {
+		sent <- writer.send(wsMessage{websocket.TextMessage, []byte("2")})
+	}()
+
+	select {
+	case <-sent:
+		t.Fatalf("send doesn't block on a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	<-writer.queue
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("send still blocked once the queue has room")
+	}
+}
+
+func TestWsWriterCloseFlushes(t *testing.T) {
+	for _, policy := range []WsWritePolicy{WsWriteBlock, WsWriteDrop} {
+		t.Run(fmt.Sprint(policy), func(t *testing.T) {
+			conn, client := wsPair(t)
+			cfg := DefaultWsConfig
+			cfg.WritePolicy = policy
+			cfg.QueueSize = 100
+			writer := newWsWriter(new(Session), conn, cfg)
+
+			const n = 50
+			for i := 0; i < n; i++ {
+				err := writer.send(wsMessage{websocket.TextMessage, []byte(fmt.Sprint(i))})
+				if err != nil {
+					t.Fatalf("send %d: %v", i, err)
+				}
+			}
+			writer.close()
+
+			err := writer.send(wsMessage{websocket.TextMessage, []byte("late")})
+			if err != ErrWsWriterClosed {
+				t.Fatalf("expect ErrWsWriterClosed after close, got %v", err)
+			}
+
+			client.SetReadDeadline(time.Now().Add(5 * time.Second))
+			for i := 0; i < n; i++ {
+				_, data, err := client.ReadMessage()
+				if err != nil {
+					t.Fatalf("message %d lost: %v", i, err)
+				}
+				if string(data) != fmt.Sprint(i) {
+					t.Fatalf("expect message %d, got %s", i, data)
+				}
+			}
+		})
+	}
+}
+
+func TestWsWriterConcurrentClose(t *testing.T) {
+	conn, client := wsPair(t)
+	writer := newWsWriter(new(Session), conn, DefaultWsConfig)
+
+	// every message accepted by send must be written, even when close races
+	accepted := make(chan int, 1000)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			if writer.send(wsMessage{websocket.TextMessage, []byte("m")}) == nil {
+				accepted <- i
+			}
+		}
+	}()
+	time.Sleep(time.Millisecond)
+	writer.close()
+	<-done
+	close(accepted)
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for range accepted {
+		if _, _, err := client.ReadMessage(); err != nil {
+			t.Fatalf("accepted message lost: %v", err)
+		}
+	}
+}
+
+func TestWsConfigDefaults(t *testing.T) {
+	cfg := WsConfig{MaxFrameSize: 1024, WritePolicy: WsWriteDrop}.withDefaults()
+
+	if cfg.QueueSize != DefaultWsConfig.QueueSize || cfg.CloseTimeout != DefaultWsConfig.CloseTimeout ||
+		cfg.WriteTimeout != DefaultWsConfig.WriteTimeout || cfg.Framing != DefaultWsConfig.Framing {
+		t.Fatalf("expect the zero fields of DefaultWsConfig, got %+v", cfg)
+	}
+	if cfg.MaxFrameSize != 1024 || cfg.WritePolicy != WsWriteDrop || len(cfg.Codecs) != 0 {
+		t.Fatalf("expect the fields set kept, got %+v", cfg)
+	}
+
+	// a drop writer of a caller-built config keeps its queue
+	writer := newTestWriter(cfg.WritePolicy, cfg.QueueSize)
+	for i := 0; i < 10; i++ {
+		writer.send(wsMessage{websocket.TextMessage, []byte("m")})
+	}
+	if writer.dropped != 0 {
+		t.Fatalf("expect no message dropped, got %d", writer.dropped)
+	}
+}
@@ -0,0 +1,365 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"tinker/pkg/framework"
+
+	"github.com/gorilla/websocket"
+)
+
+// Options of Replay
+type Options struct {
+	// Target is the base url of the tinker server, e.g. http://localhost:8585
+	Target string
+	// Speed scales the recorded timing: 1 is the original speed, 2 twice as
+	// fast, 0 sends everything without delay
+	Speed float64
+	// Timeout bounds the wait for responses after the last inbound event
+	Timeout time.Duration
+}
+
+// Result is the outcome of replaying one recording
+type Result struct {
+	Expected []*framework.RecordEvent
+	Actual   []*framework.RecordEvent
+	Diffs    []string
+}
+
+func (p *Result) Match() bool {
+	return len(p.Diffs) == 0
+}
+
+// headers which belong to the original connection and must not be replayed
+var skippedHeaders = map[string]bool{
+	"Connection":               true,
+	"Upgrade":                  true,
+	"Content-Length":           true,
+	"Transfer-Encoding":        true,
+	"Sec-Websocket-Key":        true,
+	"Sec-Websocket-Version":    true,
+	"Sec-Websocket-Extensions": true,
+}
+
+// ReplayFile replays the recording at path
+func ReplayFile(path string, opts Options) (*Result, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	events, err := framework.ReadRecording(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return Replay(events, opts)
+}
+
+// Replay replays recorded events against opts.Target and diffs the responses
+// with the recorded ones
+func Replay(events []*framework.RecordEvent, opts Options) (*Result, error) {
+	if len(events) == 0 || events[0].Kind != framework.RecordSession {
+		return nil, fmt.Errorf("recording without session event")
+	}
+	session := events[0]
+	// the messages of grpc calls are not recorded
+	if session.Grpc {
+		return nil, fmt.Errorf("grpc call %s can't be replayed", session.URL)
+	}
+
+	header := make(http.Header)
+	for k, v := range session.Header {
+		// redacted credentials can't be replayed
+		if skippedHeaders[http.CanonicalHeaderKey(k)] || (len(v) == 1 && v[0] == framework.RedactedValue) {
+			continue
+		}
+		header[k] = v
+	}
+
+	ret := new(Result)
+	var inbound []*framework.RecordEvent
+	for _, event := range events[1:] {
+		switch event.Kind {
+		case framework.RecordIn:
+			inbound = append(inbound, event)
+		case framework.RecordOut:
+			ret.Expected = append(ret.Expected, event)
+		case framework.RecordEnd:
+			// replay with the recorded request id so that responses are comparable
+			if event.RequestID != "" {
+				header.Set("X-Request-ID", event.RequestID)
+			}
+		}
+	}
+
+	var err error
+	if session.Websocket {
+		ret.Actual, err = replayWs(session, header, inbound, opts)
+		if err != nil {
+			return nil, err
+		}
+		ret.Diffs = diffWs(ret.Expected, ret.Actual)
+	} else {
+		ret.Actual, err = replayHttp(session, header, inbound, opts)
+		if err != nil {
+			return nil, err
+		}
+		ret.Diffs = diffHttp(ret.Expected, ret.Actual)
+	}
+
+	return ret, nil
+}
+
+// wait sleeps until the scaled offset of event since start
+func wait(start time.Time, event *framework.RecordEvent, speed float64) {
+	if speed <= 0 {
+		return
+	}
+
+	at := start.Add(time.Duration(float64(event.Offset)/speed) * time.Microsecond)
+	time.Sleep(time.Until(at))
+}
+
+func offsetSince(start time.Time) int64 {
+	return time.Since(start).Microseconds()
+}
+
+func replayWs(session *framework.RecordEvent, header http.Header, inbound []*framework.RecordEvent, opts Options) ([]*framework.RecordEvent, error) {
+	url := "ws" + strings.TrimPrefix(opts.Target, "http") + session.URL
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		return nil, fmt.Errorf("fail to dial %s: %v", url, err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+
+	var (
+		mu     sync.Mutex
+		actual []*framework.RecordEvent
+	)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			mu.Lock()
+			actual = append(actual, &framework.RecordEvent{
+				Kind:   framework.RecordOut,
+				Offset: offsetSince(start),
+				Type:   typeName(messageType),
+				Data:   data,
+			})
+			mu.Unlock()
+		}
+	}()
+
+	for _, event := range inbound {
+		wait(start, event, opts.Speed)
+
+		switch event.Type {
+		case "text":
+			err = conn.WriteMessage(websocket.TextMessage, event.Data)
+		case "binary":
+			err = conn.WriteMessage(websocket.BinaryMessage, event.Data)
+		case "close":
+			err = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		}
+		if err != nil {
+			// the server may legitimately close early, e.g. on a limit error
+			break
+		}
+	}
+
+	select {
+	case <-done:
+	case <-time.After(opts.Timeout):
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	return actual, nil
+}
+
+func typeName(messageType int) string {
+	if messageType == websocket.BinaryMessage {
+		return "binary"
+	}
+
+	return "text"
+}
+
+func replayHttp(session *framework.RecordEvent, header http.Header, inbound []*framework.RecordEvent, opts Options) ([]*framework.RecordEvent, error) {
+	var body bytes.Buffer
+	for _, event := range inbound {
+		body.Write(event.Data)
+	}
+
+	req, err := http.NewRequest(session.Method, opts.Target+session.URL, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+
+	client := &http.Client{Timeout: opts.Timeout}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*framework.RecordEvent{{
+		Kind:   framework.RecordOut,
+		Offset: offsetSince(start),
+		Type:   "http",
+		Status: resp.StatusCode,
+		Header: resp.Header,
+		Data:   data,
+	}}, nil
+}
+
+// normalize re-encodes JSON data so that formatting differences don't count
+func normalize(data []byte) string {
+	var v interface{}
+	if json.Unmarshal(data, &v) != nil {
+		return string(data)
+	}
+
+	ret, err := json.Marshal(v)
+	if err != nil {
+		return string(data)
+	}
+
+	return string(ret)
+}
+
+func truncate(s string) string {
+	const max = 256
+	if len(s) > max {
+		return s[:max] + fmt.Sprintf("...(%d bytes)", len(s))
+	}
+
+	return s
+}
+
+// wsStream is the key of the messages whose order is deterministic: JSON
+// messages of the same type and FanIn source. Other messages, e.g. binary
+// envelopes, are all compared by position
+func wsStream(event *framework.RecordEvent) string {
+	var msg struct {
+		Type   string `json:"type"`
+		Source string `json:"source"`
+	}
+	if event.Type != "text" || json.Unmarshal(event.Data, &msg) != nil {
+		return ""
+	}
+
+	if msg.Source != "" {
+		return msg.Type + " from " + msg.Source
+	}
+	return msg.Type
+}
+
+// splitWs groups the messages by wsStream in order. Backpressure messages
+// depend on timing and are skipped
+func splitWs(events []*framework.RecordEvent) (map[string][]*framework.RecordEvent, []string) {
+	ret := make(map[string][]*framework.RecordEvent)
+	var keys []string
+	for _, event := range events {
+		key := wsStream(event)
+		if key == framework.TypeBackpressure {
+			continue
+		}
+		if _, ok := ret[key]; !ok {
+			keys = append(keys, key)
+		}
+		ret[key] = append(ret[key], event)
+	}
+
+	return ret, keys
+}
+
+// diffWs compares the messages of each stream by position, so that the
+// interleaving of FanIn sources or backpressure messages doesn't count
+func diffWs(expected, actual []*framework.RecordEvent) []string {
+	var ret []string
+
+	wantStreams, keys := splitWs(expected)
+	gotStreams, gotKeys := splitWs(actual)
+	for _, key := range gotKeys {
+		if _, ok := wantStreams[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+
+	for _, key := range keys {
+		wantEvents, gotEvents := wantStreams[key], gotStreams[key]
+		n := len(wantEvents)
+		if len(gotEvents) > n {
+			n = len(gotEvents)
+		}
+		for i := 0; i < n; i++ {
+			var want, got string
+			if i < len(wantEvents) {
+				want = normalize(wantEvents[i].Data)
+			}
+			if i < len(gotEvents) {
+				got = normalize(gotEvents[i].Data)
+			}
+
+			if want != got {
+				name := "message"
+				if key != "" {
+					name = key + " message"
+				}
+				ret = append(ret, fmt.Sprintf("%s %d:\n  - %s\n  + %s", name, i+1, truncate(want), truncate(got)))
+			}
+		}
+	}
+
+	return ret
+}
+
+func diffHttp(expected, actual []*framework.RecordEvent) []string {
+	var ret []string
+
+	status := http.StatusOK
+	var body bytes.Buffer
+	for _, event := range expected {
+		if event.Type == "http" {
+			status = event.Status
+		}
+		body.Write(event.Data)
+	}
+
+	if status != actual[0].Status {
+		ret = append(ret, fmt.Sprintf("status:\n  - %d\n  + %d", status, actual[0].Status))
+	}
+
+	want, got := normalize(body.Bytes()), normalize(actual[0].Data)
+	if want != got {
+		ret = append(ret, fmt.Sprintf("body:\n  - %s\n  + %s", truncate(want), truncate(got)))
+	}
+
+	return ret
+}
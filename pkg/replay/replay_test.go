@@ -0,0 +1,89 @@
+package replay
+
+import (
+	"testing"
+
+	"tinker/pkg/framework"
+)
+
+func textEvents(messages ...string) []*framework.RecordEvent {
+	ret := make([]*framework.RecordEvent, len(messages))
+	for i, msg := range messages {
+		ret[i] = &framework.RecordEvent{Kind: framework.RecordOut, Type: "text", Data: []byte(msg)}
+	}
+
+	return ret
+}
+
+func TestDiffWs(t *testing.T) {
+	cases := []struct {
+		name     string
+		expected []*framework.RecordEvent
+		actual   []*framework.RecordEvent
+		diffs    int
+	}{
+		{
+			name:     "same",
+			expected: textEvents(`{"type":"success","data":1}`),
+			actual:   textEvents(`{ "data": 1, "type": "success" }`),
+		},
+		{
+			name: "interleaved sources",
+			expected: textEvents(
+				`{"type":"success","source":"a","seq":1}`,
+				`{"type":"success","source":"b","seq":1}`,
+				`{"type":"success","source":"a","seq":2}`,
+				`{"type":"summary"}`),
+			actual: textEvents(
+				`{"type":"success","source":"b","seq":1}`,
+				`{"type":"success","source":"a","seq":1}`,
+				`{"type":"success","source":"a","seq":2}`,
+				`{"type":"summary"}`),
+		},
+		{
+			name:     "backpressure skipped",
+			expected: textEvents(`{"type":"backpressure","data":{"state":"on"}}`, `{"type":"success"}`),
+			actual:   textEvents(`{"type":"success"}`),
+		},
+		{
+			name:     "different data",
+			expected: textEvents(`{"type":"success","data":1}`),
+			actual:   textEvents(`{"type":"success","data":2}`),
+			diffs:    1,
+		},
+		{
+			name:     "missing message",
+			expected: textEvents(`{"type":"success","source":"a"}`, `{"type":"success","source":"b"}`),
+			actual:   textEvents(`{"type":"success","source":"a"}`),
+			diffs:    1,
+		},
+		{
+			name:     "unexpected type",
+			expected: textEvents(`{"type":"success"}`),
+			actual:   textEvents(`{"type":"success"}`, `{"type":"error"}`),
+			diffs:    1,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			diffs := diffWs(c.expected, c.actual)
+			if len(diffs) != c.diffs {
+				t.Fatalf("expect %d diffs, got %v", c.diffs, diffs)
+			}
+		})
+	}
+}
+
+func TestReplayGrpc(t *testing.T) {
+	events := []*framework.RecordEvent{
+		{Kind: framework.RecordSession, Method: "POST", URL: "/hello.Greeting/Greet", Grpc: true},
+		{Kind: framework.RecordEnd},
+	}
+	if _, err := Replay(events, Options{Target: "http://127.0.0.1:1"}); err == nil {
+		t.Fatalf("expect grpc sessions not to be replayed")
+	}
+	if _, err := Replay(nil, Options{}); err == nil {
+		t.Fatalf("expect an empty recording to fail")
+	}
+}